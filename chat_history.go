@@ -1,11 +1,12 @@
 package whatsapp
 
 import (
-	"github.com/cristalinojr/go-whatsapp/binary"
-	"go.mau.fi/whatsmeow/binary/proto"
-	"log"
+	"fmt"
 	"strconv"
 	"time"
+
+	"github.com/cristalinojr/go-whatsapp/binary"
+	"go.mau.fi/whatsmeow/binary/proto"
 )
 
 type MessageOffsetInfo struct {
@@ -13,7 +14,7 @@ type MessageOffsetInfo struct {
 	FirstMessageOwner bool
 }
 
-func decodeMessages(n *binary.Node) []*proto.WebMessageInfo {
+func (wac *Conn) decodeMessages(n *binary.Node) []*proto.WebMessageInfo {
 
 	var messages = make([]*proto.WebMessageInfo, 0)
 
@@ -26,7 +27,7 @@ func decodeMessages(n *binary.Node) []*proto.WebMessageInfo {
 		case *proto.WebMessageInfo:
 			messages = append(messages, msg.(*proto.WebMessageInfo))
 		default:
-			log.Println("decodeMessages: Non WebMessage encountered")
+			wac.logger().Warn("non WebMessage in message history", "type", fmt.Sprintf("%T", msg))
 		}
 	}
 
@@ -43,7 +44,7 @@ func (wac *Conn) LoadChatMessages(jid string, count int, messageId string, owner
 	}
 
 	if handlers == nil {
-		handlers = wac.handler
+		handlers = wac.handlers()
 	}
 
 	kind := "before"
@@ -59,7 +60,7 @@ func (wac *Conn) LoadChatMessages(jid string, count int, messageId string, owner
 		return err
 	}
 
-	for _, msg := range decodeMessages(node) {
+	for _, msg := range wac.decodeMessages(node) {
 		wac.handleWithCustomHandlers(ParseProtoMessage(msg), handlers)
 		wac.handleWithCustomHandlers(msg, handlers)
 	}
@@ -77,7 +78,7 @@ func (wac *Conn) LoadFullChatHistory(jid string, chunkSize int,
 	}
 
 	if handlers == nil {
-		handlers = wac.handler
+		handlers = wac.handlers()
 	}
 
 	beforeMsg := ""
@@ -91,7 +92,7 @@ func (wac *Conn) LoadFullChatHistory(jid string, chunkSize int,
 			wac.handleWithCustomHandlers(err, handlers)
 		} else {
 
-			msgs := decodeMessages(node)
+			msgs := wac.decodeMessages(node)
 			for _, msg := range msgs {
 				wac.handleWithCustomHandlers(ParseProtoMessage(msg), handlers)
 				wac.handleWithCustomHandlers(msg, handlers)
@@ -121,7 +122,7 @@ func (wac *Conn) LoadFullChatHistoryAfter(jid string, messageId string, chunkSiz
 	}
 
 	if handlers == nil {
-		handlers = wac.handler
+		handlers = wac.handlers()
 	}
 
 	msgOwner := true
@@ -139,7 +140,7 @@ func (wac *Conn) LoadFullChatHistoryAfter(jid string, messageId string, chunkSiz
 				// this will detect two consecutive "not found" errors.
 				// this is done to prevent infinite loop when wrong message id supplied
 				if prevNotFound {
-					log.Println("LoadFullChatHistoryAfter: could not retrieve any messages, wrong message id?")
+					wac.logger().Warn("could not retrieve any messages, wrong message id?", "jid", jid, "id", messageId)
 					return
 				}
 				prevNotFound = true
@@ -159,7 +160,7 @@ func (wac *Conn) LoadFullChatHistoryAfter(jid string, messageId string, chunkSiz
 			wac.handleWithCustomHandlers(err, handlers)
 		} else {
 
-			msgs := decodeMessages(node)
+			msgs := wac.decodeMessages(node)
 			for _, msg := range msgs {
 				wac.handleWithCustomHandlers(ParseProtoMessage(msg), handlers)
 				wac.handleWithCustomHandlers(msg, handlers)
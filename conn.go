@@ -2,14 +2,14 @@
 package whatsapp
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
 type metric byte
@@ -70,45 +70,136 @@ const (
 /*
 Conn is created by NewConn. Interacting with the initialized Conn is the main way of interacting with our package.
 It holds all necessary information to make the package work internally.
+
+All methods of Conn are safe to call concurrently from multiple goroutines, except SetClientVersion, which changes a
+package wide setting. Only one Login or Restore (including their variants) runs at a time, a concurrent call fails
+with ErrLoginInProgress. The exported fields Info,
+ServerLastSeen and the maps of Store are written while the connection is in use and must not be accessed directly
+then, use GetInfo, LastSeen and the methods of Store instead. Handlers are called concurrently unless the Conn uses
+DispatchSync or the handler is a SyncHandler asking to be called synchronously.
 */
 type Conn struct {
-	ws       *websocketWrapper
-	listener *listenerWrapper
-
-	connected bool
-	loggedIn  bool
-	wg        *sync.WaitGroup
-
-	session        *Session
-	sessionLock    uint32
-	handler        []Handler
-	msgCount       int
-	msgTimeout     time.Duration
-	Info           *Info
-	Store          *Store
+	wsLock    sync.RWMutex
+	ws        *websocketWrapper
+	listener  *listenerWrapper
+	transport Transport
+
+	connected atomicBool
+	loggedIn  atomicBool
+
+	stateLock sync.Mutex
+	state     ConnState
+
+	session     *Session
+	sessionLock uint32
+	handlerLock sync.RWMutex
+	handler     []Handler
+	msgCount    int64
+	msgTimeout  time.Duration
+	Store       *Store
+
+	infoLock sync.RWMutex
+	// Deprecated: Info is replaced on every login, use GetInfo to read it safely.
+	Info *Info
+	// Deprecated: ServerLastSeen is updated by every keep-alive, use LastSeen to read it safely.
 	ServerLastSeen time.Time
 
-	timeTag string // last 3 digits obtained after a successful login takeover
+	timeTag string // last 3 digits obtained after a successful login takeover, guarded by writerLock
 
 	longClientName  string
 	shortClientName string
 	clientVersion   string
 
+	keepAliveMin time.Duration
+	keepAliveMax time.Duration
+	httpClient   *http.Client
+	dispatchMode DispatchMode
+	outbound     *outboundQueue
+
+	outbox        Outbox
+	outboxLock    sync.Mutex
+	outboxBacklog atomicBool // the outbox may hold messages new sends must not overtake
+
+	log    Logger
+	obs    Observer
+	tracer Tracer
+
 	loginSessionLock sync.RWMutex
 	Proxy            func(*http.Request) (*url.URL, error)
 
 	writerLock sync.RWMutex
+
+	reconnectLock sync.Mutex
+	reconnect     *ReconnectConfig
+	reconnectStop chan struct{}
+	reconnecting  bool
+	presenceSubs  map[string]struct{}
+
+	sessions       SessionStore
+	phone          *phoneMonitor
+	quality        qualityMonitor
+	stallIntervals int
+
+	closing   atomicBool    // set by Close, guarded by writerLock for writers
+	closed    chan struct{} // closed when the requests still pending on Close are failed
+	closeOnce sync.Once
 }
 
 type websocketWrapper struct {
 	sync.Mutex
-	conn  *websocket.Conn
+	conn  TransportConn
 	close chan struct{}
+	wg    sync.WaitGroup // readPump and keepAlive
+}
+
+// atomicBool is a bool that can be accessed concurrently.
+type atomicBool uint32
+
+func (b *atomicBool) Load() bool {
+	return atomic.LoadUint32((*uint32)(b)) == 1
+}
+
+func (b *atomicBool) Store(v bool) {
+	var u uint32
+	if v {
+		u = 1
+	}
+	atomic.StoreUint32((*uint32)(b), u)
+}
+
+// CompareAndSwap sets the bool to new if it is old and reports whether it did so.
+func (b *atomicBool) CompareAndSwap(old, new bool) bool {
+	var o, n uint32
+	if old {
+		o = 1
+	}
+	if new {
+		n = 1
+	}
+	return atomic.CompareAndSwapUint32((*uint32)(b), o, n)
 }
 
 type listenerWrapper struct {
 	sync.RWMutex
-	m map[string]chan string
+	m       map[string]chan string
+	expires map[string]time.Time // requests nobody waits for anymore are dropped after this time
+	wake    chan struct{}        // closed whenever listeners are removed, created on demand
+}
+
+// removed returns a channel that is closed when the next listener is removed. l must be locked.
+func (l *listenerWrapper) removed() <-chan struct{} {
+	if l.wake == nil {
+		l.wake = make(chan struct{})
+	}
+	return l.wake
+}
+
+// broadcast wakes up everyone waiting for a listener to be removed. l must be locked.
+func (l *listenerWrapper) broadcast() {
+	if l.wake != nil {
+		close(l.wake)
+		l.wake = nil
+	}
 }
 
 /*
@@ -116,157 +207,304 @@ Creates a new connection with a given timeout. The websocket connection to the W
 The goroutine for handling incoming messages is started
 */
 func NewConn(timeout time.Duration) (*Conn, error) {
-	wac := &Conn{
-		handler:    make([]Handler, 0),
-		msgCount:   0,
-		msgTimeout: timeout,
-		Store:      newStore(),
-
-		longClientName:  "github.com/cristalinojr/go-whatsapp",
-		shortClientName: "go-whatsapp",
-		clientVersion:   "0.1.0",
-	}
-	return wac, wac.connect()
+	return NewConnWithOptions(WithTimeout(timeout))
 }
 
 // NewConnWithProxy Create a new connect with a given timeout and a http proxy.
 func NewConnWithProxy(timeout time.Duration, proxy func(*http.Request) (*url.URL, error)) (*Conn, error) {
-	wac := &Conn{
-		handler:    make([]Handler, 0),
-		msgCount:   0,
-		msgTimeout: timeout,
-		Store:      newStore(),
-
-		longClientName:  "github.com/cristalinojr/go-whatsapp",
-		shortClientName: "go-whatsapp",
-		clientVersion:   "0.1.0",
-		Proxy:           proxy,
-	}
-	return wac, wac.connect()
+	return NewConnWithOptions(WithTimeout(timeout), WithProxy(proxy))
+}
+
+/*
+NewConnWithTransport creates a new connection with a given timeout that is established over the given Transport
+instead of a websocket to the WhatsAppWeb servers.
+*/
+func NewConnWithTransport(timeout time.Duration, transport Transport) (*Conn, error) {
+	return NewConnWithOptions(WithTimeout(timeout), WithTransport(transport))
 }
 
 func (wac *Conn) IsConnected() bool {
-	return wac.connected
+	return wac.connected.Load()
 }
 
 func (wac *Conn) IsLoggedIn() bool {
-	return wac.loggedIn
+	return wac.loggedIn.Load()
+}
+
+// websocket returns the current connection, nil if not connected.
+func (wac *Conn) websocket() *websocketWrapper {
+	wac.wsLock.RLock()
+	defer wac.wsLock.RUnlock()
+	return wac.ws
+}
+
+func (wac *Conn) getSession() *Session {
+	wac.loginSessionLock.RLock()
+	defer wac.loginSessionLock.RUnlock()
+	return wac.session
+}
+
+// setSession replaces the session. A published Session is never modified, changes are made to a copy.
+func (wac *Conn) setSession(session *Session) {
+	wac.loginSessionLock.Lock()
+	defer wac.loginSessionLock.Unlock()
+	wac.session = session
+}
+
+// messageCount returns the number of requests sent so far, which is part of message tags and epochs.
+func (wac *Conn) messageCount() int {
+	return int(atomic.LoadInt64(&wac.msgCount))
+}
+
+// GetInfo returns the information about the logged in account, nil if it never logged in.
+func (wac *Conn) GetInfo() *Info {
+	wac.infoLock.RLock()
+	defer wac.infoLock.RUnlock()
+	return wac.Info
+}
+
+func (wac *Conn) setInfo(info *Info) {
+	wac.infoLock.Lock()
+	defer wac.infoLock.Unlock()
+	wac.Info = info
+}
+
+// LastSeen returns the server time received with the last answered keep-alive.
+func (wac *Conn) LastSeen() time.Time {
+	wac.infoLock.RLock()
+	defer wac.infoLock.RUnlock()
+	return wac.ServerLastSeen
+}
+
+func (wac *Conn) setLastSeen(t time.Time) {
+	wac.infoLock.Lock()
+	defer wac.infoLock.Unlock()
+	wac.ServerLastSeen = t
 }
 
-// connect should be guarded with wsWriteMutex
 func (wac *Conn) connect() (err error) {
-	if wac.connected {
+	if wac.closing.Load() {
+		return ErrClosed
+	}
+	if !wac.connected.CompareAndSwap(false, true) {
 		return ErrAlreadyConnected
 	}
-	wac.connected = true
+	wac.setState(StateConnecting, nil)
 	defer func() { // set connected to false on error
 		if err != nil {
-			wac.connected = false
+			wac.connected.Store(false)
+			wac.setState(StateDisconnected, err)
 		}
 	}()
 
-	dialer := &websocket.Dialer{
-		ReadBufferSize:   0,
-		WriteBufferSize:  0,
-		HandshakeTimeout: wac.msgTimeout,
-		Proxy:            wac.Proxy,
+	transport := wac.transport
+	if transport == nil {
+		transport = &WebsocketTransport{Proxy: wac.Proxy}
 	}
 
-	headers := http.Header{"Origin": []string{"https://web.whatsapp.com"}}
-	wsConn, _, err := dialer.Dial("wss://web.whatsapp.com/ws", headers)
+	ctx, cancel := wac.requestContext(context.Background())
+	defer cancel()
+	wsConn, err := transport.Dial(ctx)
 	if err != nil {
+		wac.logger().Error("connect failed", "err", err)
 		return fmt.Errorf("couldn't dial whatsapp web websocket: %w", err)
 	}
+	wac.logger().Info("connected")
 
-	wsConn.SetCloseHandler(func(code int, text string) error {
-		// from default CloseHandler
-		message := websocket.FormatCloseMessage(code, "")
-		err := wsConn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
-
-		// our close handling
-		_, _ = wac.Disconnect()
-		wac.handle(&ErrConnectionClosed{Code: code, Text: text})
-		return err
-	})
-
-	wac.ws = &websocketWrapper{
+	ws := &websocketWrapper{
 		conn:  wsConn,
 		close: make(chan struct{}),
 	}
 
-	wac.listener = &listenerWrapper{
-		m: make(map[string]chan string),
+	// requests of a previous connection will never be answered
+	wac.listener.Lock()
+	wac.listener.m = make(map[string]chan string)
+	wac.listener.expires = make(map[string]time.Time)
+	wac.listener.broadcast()
+	wac.listener.Unlock()
+
+	wac.loggedIn.Store(false)
+	wac.quality.frameReceived(time.Now())
+	wac.wsLock.Lock()
+	wac.ws = ws
+	wac.wsLock.Unlock()
+
+	ws.wg.Add(2)
+	minInterval, maxInterval := wac.keepAliveMin, wac.keepAliveMax
+	if minInterval <= 0 || maxInterval <= minInterval {
+		def := DefaultConfig()
+		minInterval, maxInterval = def.KeepAliveMin, def.KeepAliveMax
+	}
+	go wac.readPump(ws)
+	go wac.keepAlive(ws, int(minInterval/time.Millisecond), int(maxInterval/time.Millisecond))
+	if wac.phone != nil && wac.phone.enabled {
+		ws.wg.Add(1)
+		go wac.monitorPhone(ws)
 	}
 
-	wac.wg = &sync.WaitGroup{}
-	wac.wg.Add(2)
-	go wac.readPump()
-	go wac.keepAlive(20000, 55000)
-
-	wac.loggedIn = false
 	return nil
 }
 
+/*
+Disconnect closes the websocket connection and returns the current session. A running reconnect supervisor is stopped.
+*/
 func (wac *Conn) Disconnect() (Session, error) {
-	if !wac.connected {
+	wac.stopReconnect()
+	return wac.disconnect()
+}
+
+/*
+Close shuts the Conn down gracefully and returns the final session, so it can be persisted. New requests and sends
+fail with ErrClosed right away, while requests that were already sent keep waiting for their responses, e.g. the
+acknowledgements of sent messages, until ctx is done. Requests still pending then fail with ErrClosed and the error of
+ctx is returned together with the session. A running reconnect supervisor is stopped. The Conn can't be connected
+again after Close.
+*/
+func (wac *Conn) Close(ctx context.Context) (Session, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	wac.stopReconnect()
+
+	// requests register their listener while holding writerLock, so all accepted requests are pending from now on
+	wac.writerLock.Lock()
+	wac.closing.Store(true)
+	wac.writerLock.Unlock()
+
+	pending, drainErr := wac.drainRequests(ctx)
+	if drainErr != nil {
+		wac.logger().Warn("closing with pending requests", "pending", pending, "err", drainErr)
+		drainErr = fmt.Errorf("%d requests still pending: %w", pending, drainErr)
+	}
+	wac.failRequests()
+
+	_, err := wac.disconnect()
+	if err == ErrNotConnected {
+		err = nil
+	}
+	var session Session
+	if s := wac.getSession(); s != nil {
+		session = *s
+	}
+	if err != nil {
+		return session, err
+	}
+	return session, drainErr
+}
+
+func (wac *Conn) disconnect() (Session, error) {
+	return wac.disconnectWebsocket(nil)
+}
+
+/*
+disconnectWebsocket closes the current connection. If ws is not nil, the connection is only closed if ws is still the
+current one, so a late teardown of an old connection never closes its successor.
+*/
+func (wac *Conn) disconnectWebsocket(ws *websocketWrapper) (Session, error) {
+	wac.wsLock.Lock()
+	if wac.ws == nil || (ws != nil && wac.ws != ws) {
+		wac.wsLock.Unlock()
 		return Session{}, ErrNotConnected
 	}
-	wac.connected = false
-	wac.loggedIn = false
+	ws = wac.ws
+	wac.ws = nil
+	wac.connected.Store(false)
+	wac.loggedIn.Store(false)
+	wac.wsLock.Unlock()
+
+	wac.logger().Info("disconnected")
+	if s := wac.State(); s != StateReplaced && s != StateLoggedOut {
+		wac.setState(StateDisconnected, nil)
+	}
 
-	close(wac.ws.close) //signal close
-	wac.wg.Wait()       //wait for close
+	close(ws.close) //signal close
+	ws.wg.Wait()    //wait for close
 
 	var err error
-	if wac.ws != nil && wac.ws.conn != nil {
-		err = wac.ws.conn.Close()
+	if ws.conn != nil {
+		err = ws.conn.Close()
 	}
-	wac.ws = nil
 
-	if wac.session == nil {
+	session := wac.getSession()
+	if session == nil {
 		return Session{}, err
 	}
-	return *wac.session, err
+	return *session, err
+}
+
+/*
+markLoggedIn marks the Conn as logged in, unless the connection ws the login ran on was closed in the meantime, e.g.
+by a concurrent Disconnect.
+*/
+func (wac *Conn) markLoggedIn(ws *websocketWrapper) error {
+	wac.wsLock.Lock()
+	defer wac.wsLock.Unlock()
+	if ws == nil || wac.ws != ws {
+		return ErrNotConnected
+	}
+	wac.loggedIn.Store(true)
+	return nil
 }
 
 func (wac *Conn) IsLoginInProgress() bool {
-	return wac.sessionLock == 1
+	return atomic.LoadUint32(&wac.sessionLock) == 1
 }
 
 func (wac *Conn) AdminTest() error {
-	if !wac.connected {
+	return wac.AdminTestContext(context.Background())
+}
+
+// AdminTestContext works like AdminTest, but waits for the phone's answer at most until ctx is done.
+func (wac *Conn) AdminTestContext(ctx context.Context) error {
+	if !wac.connected.Load() {
 		return ErrNotConnected
 	}
 
-	if !wac.loggedIn {
+	if !wac.loggedIn.Load() {
 		return ErrInvalidSession
 	}
 
-	return wac.sendAdminTest()
+	ctx, cancel := wac.requestContext(ctx)
+	defer cancel()
+	return wac.sendAdminTest(ctx)
 }
 
-func (wac *Conn) keepAlive(minIntervalMs int, maxIntervalMs int) {
-	defer wac.wg.Done()
+func (wac *Conn) keepAlive(ws *websocketWrapper, minIntervalMs int, maxIntervalMs int) {
+	defer ws.wg.Done()
 
+	failures := 0
 	for {
+		wac.expireListeners(time.Now())
+		wac.checkStall(time.Duration(maxIntervalMs) * time.Millisecond)
+		start := time.Now()
 		err := wac.sendKeepAlive()
+		wac.observer().KeepAlive(time.Since(start), err)
 		if err != nil {
+			wac.logger().Warn("keep alive failed", "err", err, "failures", failures+1)
 			wac.handle(fmt.Errorf("keepAlive failed: %w", err))
-			//TODO: Consequences?
+			failures++
+			if limit := wac.keepAliveFailureLimit(); limit > 0 && failures >= limit {
+				// the socket is considered dead: closing it makes readPump fail, which hands over to the
+				// reconnect supervisor
+				failures = 0
+				_ = ws.conn.Close()
+			}
+		} else {
+			failures = 0
 		}
 		interval := rand.Intn(maxIntervalMs-minIntervalMs) + minIntervalMs
 		select {
 		case <-time.After(time.Duration(interval) * time.Millisecond):
-		case <-wac.ws.close:
+		case <-ws.close:
 			return
 		}
 	}
 }
 
 func (wac *Conn) GetConnected() bool {
-	return wac.connected
+	return wac.connected.Load()
 }
 
 func (wac *Conn) GetLoggedIn() bool {
-	return wac.loggedIn
+	return wac.loggedIn.Load()
 }
@@ -0,0 +1,69 @@
+package whatsapp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+func TestConnQuality(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	srv.SetClockOffset(time.Hour)
+
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithKeepAlive(10*time.Millisecond, 20*time.Millisecond),
+		whatsapp.WithStallDetection(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+	events := newRecorder(wac)
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for wac.Quality().KeepAlives < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	q := wac.Quality()
+	if q.KeepAlives < 3 || q.MinRTT <= 0 || q.MinRTT > q.P90RTT || q.RTTHistogram.Count != uint64(q.Samples) {
+		t.Fatalf("unexpected quality %+v", q)
+	}
+	if skew := q.ClockSkew - time.Hour; skew < -time.Second || skew > time.Second {
+		t.Errorf("expected a clock skew of about 1h, got %v", q.ClockSkew)
+	}
+
+	if _, err := wac.Send(whatsapp.TextMessage{Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: "hello"}); err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	msg, err := srv.WaitForMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts := time.Unix(int64(msg.GetMessageTimestamp()), 0); ts.Before(time.Now().Add(59 * time.Minute)) {
+		t.Errorf("timestamp %v is not corrected by the clock skew", ts)
+	}
+
+	srv.DropKeepAlives(true)
+	if event := events.nextConnectionEvent(t, whatsapp.Stalled); event.Since.IsZero() {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if !wac.Quality().Stalled {
+		t.Error("quality not marked stalled")
+	}
+	srv.DropKeepAlives(false)
+	deadline = time.Now().Add(3 * time.Second)
+	for wac.Quality().Stalled && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if wac.Quality().Stalled {
+		t.Error("stall did not end after frames arrived again")
+	}
+}
@@ -1,10 +1,13 @@
 package whatsapp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/cristalinojr/go-whatsapp/binary"
+	"io"
+	"net/http"
 	"strconv"
-	"time"
 )
 
 type Presence string
@@ -17,48 +20,158 @@ const (
 	PresencePaused      Presence = "paused"
 )
 
-//TODO: filename? WhatsApp uses Store.Contacts for these functions
-// functions probably shouldn't return a string, maybe build a struct / return json
-// check for further queries
+// ProfilePicThumb is the response of GetProfilePicThumbContext.
+type ProfilePicThumb struct {
+	URL string `json:"eurl"`
+	Tag string `json:"tag"`
+}
+
+// ExistResult is the response of ExistContext. JID is the jid WhatsApp uses for the number, if it exists.
+type ExistResult struct {
+	Exists bool
+	JID    string `json:"jid"`
+}
+
+// Deprecated: use GetProfilePicThumbContext, which decodes the response.
 func (wac *Conn) GetProfilePicThumb(jid string) (<-chan string, error) {
 	data := []interface{}{"query", "ProfilePicThumb", jid}
 	return wac.writeJson(data)
 }
 
+/*
+GetProfilePicThumbContext returns the url of the profile picture of jid. If there is none or it is not visible, the
+error is a StatusResponse with status 404 or 401.
+*/
+func (wac *Conn) GetProfilePicThumbContext(ctx context.Context, jid string) (*ProfilePicThumb, error) {
+	var thumb ProfilePicThumb
+	if err := wac.jsonRequest(ctx, "profile picture query", []interface{}{"query", "ProfilePicThumb", jid}, &thumb); err != nil {
+		return nil, err
+	}
+	return &thumb, nil
+}
+
+/*
+DownloadProfilePicContext downloads the profile picture of jid with the http.Client of the Conn, see
+Config.HTTPClient. The errors of GetProfilePicThumbContext are returned if there is no picture.
+*/
+func (wac *Conn) DownloadProfilePicContext(ctx context.Context, jid string) ([]byte, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	thumb, err := wac.GetProfilePicThumbContext(ctx, jid)
+	if err != nil {
+		return nil, err
+	}
+	if thumb.URL == "" {
+		return nil, ErrNoURLPresent
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, thumb.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := wac.mediaClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading profile picture: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("profile picture download failed with status code %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Deprecated: use GetStatusContext, which decodes the response.
 func (wac *Conn) GetStatus(jid string) (<-chan string, error) {
 	data := []interface{}{"query", "Status", jid}
 	return wac.writeJson(data)
 }
 
+// GetStatusContext returns the status text ("about") of jid.
+func (wac *Conn) GetStatusContext(ctx context.Context, jid string) (string, error) {
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := wac.jsonRequest(ctx, "status query", []interface{}{"query", "Status", jid}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Status, nil
+}
+
+// Deprecated: use SubscribePresenceContext, which waits for the response.
 func (wac *Conn) SubscribePresence(jid string) (<-chan string, error) {
 	data := []interface{}{"action", "presence", "subscribe", jid}
-	return wac.writeJson(data)
+	ch, err := wac.writeJson(data)
+	if err == nil {
+		wac.trackPresenceSubscription(jid)
+	}
+	return ch, err
+}
+
+/*
+SubscribePresenceContext subscribes to the presence updates of jid. The subscription is renewed after reconnects.
+*/
+func (wac *Conn) SubscribePresenceContext(ctx context.Context, jid string) error {
+	if err := wac.jsonRequest(ctx, "presence subscription", []interface{}{"action", "presence", "subscribe", jid}, nil); err != nil {
+		return err
+	}
+	wac.trackPresenceSubscription(jid)
+	return nil
 }
 
 func (wac *Conn) Search(search string, count, page int) (*binary.Node, error) {
-	return wac.query("search", "", "", "", "", search, count, page)
+	return wac.SearchContext(context.Background(), search, count, page)
+}
+
+func (wac *Conn) SearchContext(ctx context.Context, search string, count, page int) (*binary.Node, error) {
+	return wac.queryContext(ctx, "search", "", "", "", "", search, count, page)
 }
 
 func (wac *Conn) LoadMessages(jid string, count int) (*binary.Node, error) {
-	return wac.query("message", jid, "", "before", "true", "", count, 0)
+	return wac.LoadMessagesContext(context.Background(), jid, count)
+}
+
+func (wac *Conn) LoadMessagesContext(ctx context.Context, jid string, count int) (*binary.Node, error) {
+	return wac.queryContext(ctx, "message", jid, "", "before", "true", "", count, 0)
 }
 
 func (wac *Conn) LoadMessagesBefore(jid, messageId string, fromMe bool, count int) (*binary.Node, error) {
-	return wac.query("message", jid, messageId, "before", strconv.FormatBool(fromMe), "", count, 0)
+	return wac.LoadMessagesBeforeContext(context.Background(), jid, messageId, fromMe, count)
+}
+
+func (wac *Conn) LoadMessagesBeforeContext(ctx context.Context, jid, messageId string, fromMe bool, count int) (*binary.Node, error) {
+	return wac.queryContext(ctx, "message", jid, messageId, "before", strconv.FormatBool(fromMe), "", count, 0)
 }
 
 func (wac *Conn) LoadMessagesAfter(jid, messageId string, fromMe bool, count int) (*binary.Node, error) {
-	return wac.query("message", jid, messageId, "after", strconv.FormatBool(fromMe), "", count, 0)
+	return wac.LoadMessagesAfterContext(context.Background(), jid, messageId, fromMe, count)
+}
+
+func (wac *Conn) LoadMessagesAfterContext(ctx context.Context, jid, messageId string, fromMe bool, count int) (*binary.Node, error) {
+	return wac.queryContext(ctx, "message", jid, messageId, "after", strconv.FormatBool(fromMe), "", count, 0)
 }
 
 func (wac *Conn) LoadMediaInfo(jid, messageId string, fromMe bool) (*binary.Node, error) {
-	return wac.query("media", jid, messageId, "", strconv.FormatBool(fromMe), "", 0, 0)
+	return wac.LoadMediaInfoContext(context.Background(), jid, messageId, fromMe)
 }
 
+func (wac *Conn) LoadMediaInfoContext(ctx context.Context, jid, messageId string, fromMe bool) (*binary.Node, error) {
+	return wac.queryContext(ctx, "media", jid, messageId, "", strconv.FormatBool(fromMe), "", 0, 0)
+}
+
+// Deprecated: use PresenceContext, which waits for the response.
 func (wac *Conn) Presence(jid string, presence Presence) (<-chan string, error) {
-	ts := time.Now().Unix()
-	tag := fmt.Sprintf("%d.--%d", ts, wac.msgCount)
+	tag := wac.newMessageTag()
+	return wac.writeBinary(wac.presenceNode(jid, presence), group, ignore, tag)
+}
 
+// PresenceContext sets the own presence, composing, recording and paused are sent to jid only.
+func (wac *Conn) PresenceContext(ctx context.Context, jid string, presence Presence) error {
+	tag := wac.newMessageTag()
+	return wac.binaryRequest(ctx, "presence", wac.presenceNode(jid, presence), group, ignore, tag, nil)
+}
+
+func (wac *Conn) presenceNode(jid string, presence Presence) binary.Node {
 	content := binary.Node{
 		Description: "presence",
 		Attributes: map[string]string{
@@ -74,29 +187,50 @@ func (wac *Conn) Presence(jid string, presence Presence) (<-chan string, error)
 		content.Attributes["to"] = jid
 	}
 
-	n := binary.Node{
+	return binary.Node{
 		Description: "action",
 		Attributes: map[string]string{
 			"type":  "set",
-			"epoch": strconv.Itoa(wac.msgCount),
+			"epoch": strconv.Itoa(wac.messageCount()),
 		},
 		Content: []interface{}{content},
 	}
-
-	return wac.writeBinary(n, group, ignore, tag)
 }
 
+// Deprecated: use ExistContext, which decodes the response.
 func (wac *Conn) Exist(jid string) (<-chan string, error) {
 	data := []interface{}{"query", "exist", jid}
 	return wac.writeJson(data)
 }
 
+// ExistContext checks whether jid is registered on WhatsApp.
+func (wac *Conn) ExistContext(ctx context.Context, jid string) (*ExistResult, error) {
+	var result ExistResult
+	err := wac.jsonRequest(ctx, "exist query", []interface{}{"query", "exist", jid}, &result)
+	var resp StatusResponse
+	if errors.As(err, &resp) && resp.Status == 404 {
+		return &ExistResult{Exists: false}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	result.Exists = true
+	return &result, nil
+}
+
 func (wac *Conn) Emoji() (*binary.Node, error) {
-	return wac.query("emoji", "", "", "", "", "", 0, 0)
+	return wac.EmojiContext(context.Background())
+}
+
+func (wac *Conn) EmojiContext(ctx context.Context) (*binary.Node, error) {
+	return wac.queryContext(ctx, "emoji", "", "", "", "", "", 0, 0)
 }
 
 func (wac *Conn) Contacts() (*binary.Node, error) {
-	node, err := wac.query("contacts", "", "", "", "", "", 0, 0)
+	return wac.ContactsContext(context.Background())
+}
+
+func (wac *Conn) ContactsContext(ctx context.Context) (*binary.Node, error) {
+	node, err := wac.queryContext(ctx, "contacts", "", "", "", "", "", 0, 0)
 	if node != nil && node.Description == "response" && node.Attributes["type"] == "contacts" {
 		wac.updateContacts(node.Content)
 	}
@@ -104,22 +238,35 @@ func (wac *Conn) Contacts() (*binary.Node, error) {
 }
 
 func (wac *Conn) Chats() (*binary.Node, error) {
-	node, err := wac.query("chat", "", "", "", "", "", 0, 0)
+	return wac.ChatsContext(context.Background())
+}
+
+func (wac *Conn) ChatsContext(ctx context.Context) (*binary.Node, error) {
+	node, err := wac.queryContext(ctx, "chat", "", "", "", "", "", 0, 0)
 	if node != nil && node.Description == "response" && node.Attributes["type"] == "chat" {
 		wac.updateChats(node.Content)
 	}
 	return node, err
 }
 
+// Deprecated: use ReadContext, which waits for the response.
 func (wac *Conn) Read(jid, id string) (<-chan string, error) {
-	ts := time.Now().Unix()
-	tag := fmt.Sprintf("%d.--%d", ts, wac.msgCount)
+	tag := wac.newMessageTag()
+	return wac.writeBinary(wac.readNode(jid, id), group, ignore, tag)
+}
 
-	n := binary.Node{
+// ReadContext marks the message id in the chat jid and all messages before it as read.
+func (wac *Conn) ReadContext(ctx context.Context, jid, id string) error {
+	tag := wac.newMessageTag()
+	return wac.binaryRequest(ctx, "read", wac.readNode(jid, id), group, ignore, tag, nil)
+}
+
+func (wac *Conn) readNode(jid, id string) binary.Node {
+	return binary.Node{
 		Description: "action",
 		Attributes: map[string]string{
 			"type":  "set",
-			"epoch": strconv.Itoa(wac.msgCount),
+			"epoch": strconv.Itoa(wac.messageCount()),
 		},
 		Content: []interface{}{binary.Node{
 			Description: "read",
@@ -131,19 +278,23 @@ func (wac *Conn) Read(jid, id string) (<-chan string, error) {
 			},
 		}},
 	}
-
-	return wac.writeBinary(n, group, ignore, tag)
 }
 
 func (wac *Conn) query(t, jid, messageId, kind, owner, search string, count, page int) (*binary.Node, error) {
-	ts := time.Now().Unix()
-	tag := fmt.Sprintf("%d.--%d", ts, wac.msgCount)
+	return wac.queryContext(context.Background(), t, jid, messageId, kind, owner, search, count, page)
+}
+
+func (wac *Conn) queryContext(ctx context.Context, t, jid, messageId, kind, owner, search string, count, page int) (node *binary.Node, err error) {
+	ctx, span := wac.startSpan(ctx, "whatsapp.query", Attr("query.type", t), Attr("jid", jid))
+	defer func() { endSpan(span, err) }()
+
+	tag := wac.newMessageTag()
 
 	n := binary.Node{
 		Description: "query",
 		Attributes: map[string]string{
 			"type":  t,
-			"epoch": strconv.Itoa(wac.msgCount),
+			"epoch": strconv.Itoa(wac.messageCount()),
 		},
 	}
 
@@ -180,24 +331,26 @@ func (wac *Conn) query(t, jid, messageId, kind, owner, search string, count, pag
 		metric = queryMedia
 	}
 
-	ch, err := wac.writeBinary(n, metric, ignore, tag)
-	if err != nil {
-		return nil, err
-	}
-
-	msg, err := wac.decryptBinaryMessage([]byte(<-ch))
-	if err != nil {
-		return nil, err
-	}
-
 	//TODO: use parseProtoMessage
-	return msg, nil
+	return wac.nodeRequest(ctx, "query "+t, n, metric, ignore, tag)
 }
 
 func (wac *Conn) setGroup(t, jid, subject string, participants []string) (<-chan string, error) {
-	ts := time.Now().Unix()
-	tag := fmt.Sprintf("%d.--%d", ts, wac.msgCount)
+	tag := wac.newMessageTag()
+	return wac.writeBinary(wac.groupNode(t, jid, subject, participants, tag), group, ignore, tag)
+}
 
+// setGroupContext sends a group action and decodes the response.
+func (wac *Conn) setGroupContext(ctx context.Context, t, jid, subject string, participants []string) (*GroupResult, error) {
+	tag := wac.newMessageTag()
+	var result GroupResult
+	if err := wac.binaryRequest(ctx, "group "+t, wac.groupNode(t, jid, subject, participants, tag), group, ignore, tag, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (wac *Conn) groupNode(t, jid, subject string, participants []string, tag string) binary.Node {
 	//TODO: get proto or improve encoder to handle []interface{}
 
 	p := buildParticipantNodes(participants)
@@ -205,7 +358,7 @@ func (wac *Conn) setGroup(t, jid, subject string, participants []string) (<-chan
 	g := binary.Node{
 		Description: "group",
 		Attributes: map[string]string{
-			"author": wac.session.Wid,
+			"author": wac.getSession().Wid,
 			"id":     tag,
 			"type":   t,
 		},
@@ -220,16 +373,14 @@ func (wac *Conn) setGroup(t, jid, subject string, participants []string) (<-chan
 		g.Attributes["subject"] = subject
 	}
 
-	n := binary.Node{
+	return binary.Node{
 		Description: "action",
 		Attributes: map[string]string{
 			"type":  "set",
-			"epoch": strconv.Itoa(wac.msgCount),
+			"epoch": strconv.Itoa(wac.messageCount()),
 		},
 		Content: []interface{}{g},
 	}
-
-	return wac.writeBinary(n, group, ignore, tag)
 }
 
 func buildParticipantNodes(participants []string) []binary.Node {
@@ -39,6 +39,19 @@ var (
 	ErrJoinUnauthorized  = errors.New("you're not allowed to join that group")
 
 	ErrInvalidWebsocket = errors.New("invalid websocket")
+	ErrInvalidConfig    = errors.New("invalid config")
+	ErrQueueFull        = errors.New("outbound queue is full")
+	ErrRateLimited      = errors.New("rate limit exceeded")
+	ErrMessageQueued    = errors.New("not connected, message queued in outbox")
+
+	ErrInvalidStateTransition = errors.New("invalid state transition")
+	ErrSessionNotFound        = errors.New("session not found")
+	ErrAccountExists          = errors.New("account is already managed")
+	ErrUnknownAccount         = errors.New("account is not managed")
+	ErrClosed                 = errors.New("connection closed")
+	ErrSealedSession          = errors.New("sealed session can not be opened: wrong passphrase or tampered data")
+	ErrSealVersion            = errors.New("unsupported sealed session version")
+	ErrMissingPassphrase      = errors.New("missing passphrase")
 )
 
 type ErrConnectionFailed struct {
@@ -49,6 +62,10 @@ func (e *ErrConnectionFailed) Error() string {
 	return fmt.Sprintf("connection to WhatsApp servers failed: %v", e.Err)
 }
 
+func (e *ErrConnectionFailed) Unwrap() error {
+	return e.Err
+}
+
 type ErrConnectionClosed struct {
 	Code int
 	Text string
@@ -85,3 +102,29 @@ func (sr *StatusResponse) UnmarshalJSON(data []byte) error {
 func (sr StatusResponse) Error() string {
 	return fmt.Sprintf("%s responded with %d", sr.RequestType, sr.Status)
 }
+
+/*
+ErrInvalidResponse is returned if a payload of the server has an unexpected shape, e.g. a missing field or a field of
+the wrong type. It matches ErrInvalidServerResponse with errors.Is and carries the raw payload for debugging.
+*/
+type ErrInvalidResponse struct {
+	RequestType string
+	Payload     string
+	Err         error
+}
+
+func invalidResponse(requestType, payload string, err error) error {
+	return &ErrInvalidResponse{RequestType: requestType, Payload: payload, Err: err}
+}
+
+func (e *ErrInvalidResponse) Error() string {
+	return fmt.Sprintf("invalid %s response received from server: %v", e.RequestType, e.Err)
+}
+
+func (e *ErrInvalidResponse) Unwrap() error {
+	return e.Err
+}
+
+func (e *ErrInvalidResponse) Is(target error) bool {
+	return target == ErrInvalidServerResponse
+}
@@ -23,7 +23,7 @@ func (h *historyHandler) HandleTextMessage(message whatsapp.TextMessage) {
 	authorID := "-"
 	screenName := "-"
 	if message.Info.FromMe {
-		authorID = h.c.Info.Wid
+		authorID = h.c.GetInfo().Wid
 		screenName = ""
 	} else {
 		if message.Info.Source.Participant != nil {
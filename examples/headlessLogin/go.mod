@@ -0,0 +1,18 @@
+module github.com/cristalinojr/go-whatsapp/examples/headlessLogin
+
+go 1.17
+
+require (
+	github.com/cristalinojr/go-whatsapp v0.0.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.0 // indirect
+	github.com/gorilla/websocket v1.5.0 // indirect
+	go.mau.fi/whatsmeow v0.0.0-20220309174443-4ea4925be30c // indirect
+	golang.org/x/crypto v0.0.0-20220307211146-efcb8507fb70 // indirect
+	golang.org/x/sys v0.0.0-20210927094055-39ccf1dd6fa6 // indirect
+	google.golang.org/protobuf v1.27.1 // indirect
+)
+
+replace github.com/cristalinojr/go-whatsapp => ../../
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+)
+
+// serves the qr code on http://localhost:8080/login/ until the phone scanned it
+func main() {
+	wac, err := whatsapp.NewConn(5 * time.Second)
+	if err != nil {
+		panic(err)
+	}
+
+	login := whatsapp.NewLoginServer(wac)
+	http.Handle("/login/", http.StripPrefix("/login", login))
+	go func() {
+		if err := http.ListenAndServe(":8080", nil); err != nil {
+			fmt.Fprintf(os.Stderr, "error serving login page: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	session, err := login.Login(context.Background(), 5)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error during login: %v\n", err)
+		return
+	}
+	fmt.Printf("login successful as %s\n", session.Wid)
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"fmt"
-	"github.com/Baozisoftware/qrcode-terminal-go"
 	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/qrcode"
 	"os"
 	"time"
 )
@@ -16,8 +16,11 @@ func main() {
 
 	qr := make(chan string)
 	go func() {
-		terminal := qrcodeTerminal.New()
-		terminal.Get(<-qr).Print()
+		code, err := qrcode.EncodeString(<-qr, qrcode.Medium)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Print(code.HalfBlock())
 	}()
 
 	session, err := wac.Login(qr)
@@ -41,13 +41,13 @@ func (*waHandler) HandleTextMessage(message whatsapp.TextMessage) {
 
 /*//Example for media handling. Video, Audio, Document are also possible in the same way
 func (h *waHandler) HandleImageMessage(message whatsapp.ImageMessage) {
-	data, err := message.Download()
+	data, err := h.c.DownloadMedia(message)
 	if err != nil {
 		if err != whatsapp.ErrMediaDownloadFailedWith410 && err != whatsapp.ErrMediaDownloadFailedWith404 {
 			return
 		}
 		if _, err = h.c.LoadMediaInfo(message.Info.RemoteJid, message.Info.Id, strconv.FormatBool(message.Info.FromMe)); err == nil {
-			data, err = message.Download()
+			data, err = h.c.DownloadMedia(message)
 			if err != nil {
 				return
 			}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/gob"
 	"fmt"
 	"github.com/Baozisoftware/qrcode-terminal-go"
 	"github.com/cristalinojr/go-whatsapp"
@@ -47,31 +46,32 @@ func main() {
 
 	fmt.Printf("login successful, session: %v\n", session)
 }
+
+// the session grants full access to the account, so it is sealed with a passphrase
+func sealer() (*whatsapp.SessionSealer, error) {
+	return whatsapp.NewSessionSealer([]byte(os.Getenv("WHATSAPP_SESSION_PASSPHRASE")))
+}
+
 func readSession() (whatsapp.Session, error) {
-	session := whatsapp.Session{}
-	file, err := os.Open(os.TempDir() + "/whatsappSession.gob")
+	s, err := sealer()
 	if err != nil {
-		return session, err
+		return whatsapp.Session{}, err
 	}
-	defer file.Close()
-	decoder := gob.NewDecoder(file)
-	err = decoder.Decode(&session)
+	sealed, err := os.ReadFile(os.TempDir() + "/whatsappSession.sealed")
 	if err != nil {
-		return session, err
+		return whatsapp.Session{}, err
 	}
-	return session, nil
+	return s.Open(sealed)
 }
 
 func writeSession(session whatsapp.Session) error {
-	file, err := os.Create(os.TempDir() + "/whatsappSession.gob")
+	s, err := sealer()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	encoder := gob.NewEncoder(file)
-	err = encoder.Encode(session)
+	sealed, err := s.Seal(session)
 	if err != nil {
 		return err
 	}
-	return nil
+	return os.WriteFile(os.TempDir()+"/whatsappSession.sealed", sealed, 0600)
 }
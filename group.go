@@ -1,7 +1,9 @@
 package whatsapp
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -9,121 +11,204 @@ import (
 	"github.com/cristalinojr/go-whatsapp/binary"
 )
 
+// GroupParticipant is a member of a group as listed in GroupMetadata.
+type GroupParticipant struct {
+	JID          string `json:"id"`
+	IsAdmin      bool   `json:"isAdmin"`
+	IsSuperAdmin bool   `json:"isSuperAdmin"`
+}
+
+// GroupMetadata is the response of GetGroupMetaDataContext.
+type GroupMetadata struct {
+	JID              string             `json:"id"`
+	Owner            string             `json:"owner"`
+	Subject          string             `json:"subject"`
+	Creation         int64              `json:"creation"`
+	SubjectTime      int64              `json:"subjectTime"`
+	SubjectOwner     string             `json:"subjectOwner"`
+	Description      string             `json:"desc"`
+	DescriptionID    string             `json:"descId"`
+	DescriptionTime  int64              `json:"descTime"`
+	DescriptionOwner string             `json:"descOwner"`
+	Participants     []GroupParticipant `json:"participants"`
+}
+
+/*
+GroupResult is the response of a group action. Participants maps the jids the action was applied to onto the status
+code of the single participant, e.g. 200 if the participant was added and 409 if it was already a member.
+*/
+type GroupResult struct {
+	JID          string
+	Participants map[string]int
+}
+
+func (gr *GroupResult) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		JID          string           `json:"gid"`
+		Participants []map[string]int `json:"participants"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	gr.JID = raw.JID
+	gr.Participants = make(map[string]int, len(raw.Participants))
+	for _, p := range raw.Participants {
+		for jid, status := range p {
+			gr.Participants[jid] = status
+		}
+	}
+	return nil
+}
+
+// Deprecated: use GetGroupMetaDataContext, which decodes the response.
 func (wac *Conn) GetGroupMetaData(jid string) (<-chan string, error) {
 	data := []interface{}{"query", "GroupMetadata", jid}
 	return wac.writeJson(data)
 }
 
+// GetGroupMetaDataContext returns the subject, description and participants of the group jid.
+func (wac *Conn) GetGroupMetaDataContext(ctx context.Context, jid string) (*GroupMetadata, error) {
+	var metadata GroupMetadata
+	if err := wac.jsonRequest(ctx, "group metadata query", []interface{}{"query", "GroupMetadata", jid}, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// Deprecated: use CreateGroupContext, which decodes the response.
 func (wac *Conn) CreateGroup(subject string, participants []string) (<-chan string, error) {
 	return wac.setGroup("create", "", subject, participants)
 }
 
+// CreateGroupContext creates a group, the jid of the new group is returned in the GroupResult.
+func (wac *Conn) CreateGroupContext(ctx context.Context, subject string, participants []string) (*GroupResult, error) {
+	return wac.setGroupContext(ctx, "create", "", subject, participants)
+}
+
+// Deprecated: use UpdateGroupSubjectContext, which decodes the response.
 func (wac *Conn) UpdateGroupSubject(subject string, jid string) (<-chan string, error) {
 	return wac.setGroup("subject", jid, subject, nil)
 }
 
+func (wac *Conn) UpdateGroupSubjectContext(ctx context.Context, subject string, jid string) (*GroupResult, error) {
+	return wac.setGroupContext(ctx, "subject", jid, subject, nil)
+}
+
+// Deprecated: use SetAdminContext, which decodes the response.
 func (wac *Conn) SetAdmin(jid string, participants []string) (<-chan string, error) {
 	return wac.setGroup("promote", jid, "", participants)
 }
 
+func (wac *Conn) SetAdminContext(ctx context.Context, jid string, participants []string) (*GroupResult, error) {
+	return wac.setGroupContext(ctx, "promote", jid, "", participants)
+}
+
+// Deprecated: use RemoveAdminContext, which decodes the response.
 func (wac *Conn) RemoveAdmin(jid string, participants []string) (<-chan string, error) {
 	return wac.setGroup("demote", jid, "", participants)
 }
 
+func (wac *Conn) RemoveAdminContext(ctx context.Context, jid string, participants []string) (*GroupResult, error) {
+	return wac.setGroupContext(ctx, "demote", jid, "", participants)
+}
+
+// Deprecated: use AddMemberContext, which decodes the response.
 func (wac *Conn) AddMember(jid string, participants []string) (<-chan string, error) {
 	return wac.setGroup("add", jid, "", participants)
 }
 
+func (wac *Conn) AddMemberContext(ctx context.Context, jid string, participants []string) (*GroupResult, error) {
+	return wac.setGroupContext(ctx, "add", jid, "", participants)
+}
+
+// Deprecated: use RemoveMemberContext, which decodes the response.
 func (wac *Conn) RemoveMember(jid string, participants []string) (<-chan string, error) {
 	return wac.setGroup("remove", jid, "", participants)
 }
 
+func (wac *Conn) RemoveMemberContext(ctx context.Context, jid string, participants []string) (*GroupResult, error) {
+	return wac.setGroupContext(ctx, "remove", jid, "", participants)
+}
+
+// Deprecated: use LeaveGroupContext, which decodes the response.
 func (wac *Conn) LeaveGroup(jid string) (<-chan string, error) {
 	return wac.setGroup("leave", jid, "", nil)
 }
 
-func (wac *Conn) GroupInviteLink(jid string) (string, error) {
-	request := []interface{}{"query", "inviteCode", jid}
-	ch, err := wac.writeJson(request)
-	if err != nil {
-		return "", err
-	}
+func (wac *Conn) LeaveGroupContext(ctx context.Context, jid string) (*GroupResult, error) {
+	return wac.setGroupContext(ctx, "leave", jid, "", nil)
+}
 
-	var response map[string]interface{}
+func (wac *Conn) GroupInviteLink(jid string) (string, error) {
+	return wac.GroupInviteLinkContext(context.Background(), jid)
+}
 
-	select {
-	case r := <-ch:
-		if err := json.Unmarshal([]byte(r), &response); err != nil {
-			return "", fmt.Errorf("error decoding response message: %v\n", err)
-		}
-	case <-time.After(wac.msgTimeout):
-		return "", fmt.Errorf("request timed out")
+// GroupInviteLinkContext works like GroupInviteLink, but waits for the response at most until ctx is done.
+func (wac *Conn) GroupInviteLinkContext(ctx context.Context, jid string) (string, error) {
+	var response struct {
+		Code string `json:"code"`
 	}
-
-	status := int(response["status"].(float64))
-	if status == 401 {
+	err := wac.jsonRequest(ctx, "invite code query", []interface{}{"query", "inviteCode", jid}, &response)
+	var resp StatusResponse
+	if errors.As(err, &resp) && resp.Status == 401 {
 		return "", ErrCantGetInviteLink
-	} else if status != 200 {
-		return "", fmt.Errorf("request responded with %d", status)
+	} else if err != nil {
+		return "", err
 	}
-
-	return response["code"].(string), nil
+	return response.Code, nil
 }
 
 func (wac *Conn) GroupAcceptInviteCode(code string) (jid string, err error) {
-	request := []interface{}{"action", "invite", code}
-	ch, err := wac.writeJson(request)
-	if err != nil {
-		return "", err
-	}
-
-	var response map[string]interface{}
+	return wac.GroupAcceptInviteCodeContext(context.Background(), code)
+}
 
-	select {
-	case r := <-ch:
-		if err := json.Unmarshal([]byte(r), &response); err != nil {
-			return "", fmt.Errorf("error decoding response message: %v\n", err)
-		}
-	case <-time.After(wac.msgTimeout):
-		return "", fmt.Errorf("request timed out")
+// GroupAcceptInviteCodeContext works like GroupAcceptInviteCode, but waits for the response at most until ctx is done.
+func (wac *Conn) GroupAcceptInviteCodeContext(ctx context.Context, code string) (jid string, err error) {
+	var response struct {
+		JID string `json:"gid"`
 	}
-
-	status := int(response["status"].(float64))
-
-	if status == 401 {
+	err = wac.jsonRequest(ctx, "invite", []interface{}{"action", "invite", code}, &response)
+	var resp StatusResponse
+	if errors.As(err, &resp) && resp.Status == 401 {
 		return "", ErrJoinUnauthorized
-	} else if status != 200 {
-		return "", fmt.Errorf("request responded with %d", status)
+	} else if err != nil {
+		return "", err
 	}
-
-	return response["gid"].(string), nil
+	return response.JID, nil
 }
 
-type descriptionID struct {
-	DescID string `json:"descId"`
-}
-
-func (wac *Conn) getDescriptionID(jid string) (string, error) {
-	data, err := wac.GetGroupMetaData(jid)
+func (wac *Conn) getDescriptionID(ctx context.Context, jid string) (string, error) {
+	metadata, err := wac.GetGroupMetaDataContext(ctx, jid)
 	if err != nil {
 		return "none", err
 	}
-	var oldData descriptionID
-	err = json.Unmarshal([]byte(<-data), &oldData)
-	if err != nil {
-		return "none", err
-	}
-	if oldData.DescID == "" {
+	if metadata.DescriptionID == "" {
 		return "none", nil
 	}
-	return oldData.DescID, nil
+	return metadata.DescriptionID, nil
 }
 
+// Deprecated: use UpdateGroupDescriptionContext, which waits for the response.
 func (wac *Conn) UpdateGroupDescription(jid, description string) (<-chan string, error) {
-	prevID, err := wac.getDescriptionID(jid)
+	prevID, err := wac.getDescriptionID(context.Background(), jid)
 	if err != nil {
 		return nil, err
 	}
+	tag := fmt.Sprintf("%d.--%d", time.Now().Unix(), wac.messageCount()*19)
+	return wac.writeBinary(wac.descriptionNode(jid, description, prevID, tag), group, 136, tag)
+}
+
+// UpdateGroupDescriptionContext sets the description of the group jid, an empty description deletes it.
+func (wac *Conn) UpdateGroupDescriptionContext(ctx context.Context, jid, description string) error {
+	prevID, err := wac.getDescriptionID(ctx, jid)
+	if err != nil {
+		return err
+	}
+	tag := fmt.Sprintf("%d.--%d", time.Now().Unix(), wac.messageCount()*19)
+	return wac.binaryRequest(ctx, "group description", wac.descriptionNode(jid, description, prevID, tag), group, 136, tag, nil)
+}
+
+func (wac *Conn) descriptionNode(jid, description, prevID, tag string) binary.Node {
 	newData := map[string]string{
 		"prev": prevID,
 	}
@@ -132,14 +217,13 @@ func (wac *Conn) UpdateGroupDescription(jid, description string) (<-chan string,
 		newData["delete"] = "true"
 		desc = nil
 	} else {
-		newData["id"] = fmt.Sprintf("%d-%d", time.Now().Unix(), wac.msgCount*19)
+		newData["id"] = fmt.Sprintf("%d-%d", time.Now().Unix(), wac.messageCount()*19)
 	}
-	tag := fmt.Sprintf("%d.--%d", time.Now().Unix(), wac.msgCount*19)
-	n := binary.Node{
+	return binary.Node{
 		Description: "action",
 		Attributes: map[string]string{
 			"type":  "set",
-			"epoch": strconv.Itoa(wac.msgCount),
+			"epoch": strconv.Itoa(wac.messageCount()),
 		},
 		Content: []interface{}{
 			binary.Node{
@@ -148,7 +232,7 @@ func (wac *Conn) UpdateGroupDescription(jid, description string) (<-chan string,
 					"id":     tag,
 					"jid":    jid,
 					"type":   "description",
-					"author": wac.Info.Wid,
+					"author": wac.GetInfo().Wid,
 				},
 				Content: []binary.Node{
 					{
@@ -160,5 +244,4 @@ func (wac *Conn) UpdateGroupDescription(jid, description string) (<-chan string,
 			},
 		},
 	}
-	return wac.writeBinary(n, group, 136, tag)
 }
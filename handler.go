@@ -2,7 +2,6 @@ package whatsapp
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/cristalinojr/go-whatsapp/binary"
@@ -101,7 +100,8 @@ type ContactMessageHandler interface {
 The JsonMessageHandler interface needs to be implemented to receive json messages dispatched by the dispatcher.
 These json messages contain status updates of every kind sent by WhatsAppWeb servers. WhatsAppWeb uses these messages
 to built a Store, which is used to save these "secondary" information. These messages may contain
-presence (available, last see) information, or just the battery status of your phone.
+presence (available, last see) information, or just the battery status of your phone. Frames the Conn understands are
+dispatched typed as well, see ServerEventHandler.
 */
 type JsonMessageHandler interface {
 	Handler
@@ -165,6 +165,57 @@ type NewContactHandler interface {
 	HandleNewContact(contact Contact)
 }
 
+/*
+The ConnectionEventHandler interface needs to be implemented to receive connection lifecycle events emitted by the
+reconnect supervisor.
+*/
+type ConnectionEventHandler interface {
+	Handler
+	HandleConnectionEvent(event ConnectionEvent)
+}
+
+/*
+The StateHandler interface needs to be implemented to be notified whenever the state of the connection changes.
+*/
+type StateHandler interface {
+	Handler
+	HandleStateChange(change StateChange)
+}
+
+/*
+The OutboxHandler interface needs to be implemented to receive the results of messages replayed from the outbox.
+*/
+type OutboxHandler interface {
+	Handler
+	HandleOutboxResult(result OutboxResult)
+}
+
+/*
+The PhoneEventHandler interface needs to be implemented to be notified when the phone goes offline, comes back online
+or runs low on battery.
+*/
+type PhoneEventHandler interface {
+	Handler
+	HandlePhoneEvent(event PhoneEvent)
+}
+
+/*
+The LoginEventHandler interface needs to be implemented to follow the progress of logins by qr code, see LoginEvent.
+*/
+type LoginEventHandler interface {
+	Handler
+	HandleLoginEvent(event LoginEvent)
+}
+
+/*
+The ServerEventHandler interface needs to be implemented to receive the typed events of unsolicited JSON frames, like
+replaced sessions or blocklist updates, see ServerEvent.
+*/
+type ServerEventHandler interface {
+	Handler
+	HandleServerEvent(event ServerEvent)
+}
+
 /*
 AddHandler adds an handler to the list of handler that receive dispatched messages.
 The provided handler must at least implement the Handler interface. Additionally implemented
@@ -172,11 +223,15 @@ handlers(TextMessageHandler, ImageMessageHandler) are optional. At runtime it is
 and they are called if so and needed.
 */
 func (wac *Conn) AddHandler(handler Handler) {
+	wac.handlerLock.Lock()
+	defer wac.handlerLock.Unlock()
 	wac.handler = append(wac.handler, handler)
 }
 
 // RemoveHandler removes a handler from the list of handlers that receive dispatched messages.
 func (wac *Conn) RemoveHandler(handler Handler) bool {
+	wac.handlerLock.Lock()
+	defer wac.handlerLock.Unlock()
 	i := -1
 	for k, v := range wac.handler {
 		if v == handler {
@@ -185,7 +240,10 @@ func (wac *Conn) RemoveHandler(handler Handler) bool {
 		}
 	}
 	if i > -1 {
-		wac.handler = append(wac.handler[:i], wac.handler[i+1:]...)
+		// the slice may still be iterated by a dispatch, so it is copied instead of modified in place
+		handlers := make([]Handler, 0, len(wac.handler)-1)
+		handlers = append(handlers, wac.handler[:i]...)
+		wac.handler = append(handlers, wac.handler[i+1:]...)
 		return true
 	}
 	return false
@@ -193,10 +251,22 @@ func (wac *Conn) RemoveHandler(handler Handler) bool {
 
 // RemoveHandlers empties the list of handlers that receive dispatched messages.
 func (wac *Conn) RemoveHandlers() {
+	wac.handlerLock.Lock()
+	defer wac.handlerLock.Unlock()
 	wac.handler = make([]Handler, 0)
 }
 
+// handlers returns the current list of handlers. The returned slice must not be modified.
+func (wac *Conn) handlers() []Handler {
+	wac.handlerLock.RLock()
+	defer wac.handlerLock.RUnlock()
+	return wac.handler
+}
+
 func (wac *Conn) shouldCallSynchronously(handler Handler) bool {
+	if wac.dispatchMode == DispatchSync {
+		return true
+	}
 	sh, ok := handler.(SyncHandler)
 	return ok && sh.ShouldCallSynchronously()
 }
@@ -204,6 +274,7 @@ func (wac *Conn) shouldCallSynchronously(handler Handler) bool {
 func (wac *Conn) handle(message interface{}) {
 	defer func() {
 		if errIfc := recover(); errIfc != nil {
+			wac.logger().Error("panic in handler", "err", errIfc)
 			if err, ok := errIfc.(error); ok {
 				wac.unsafeHandle(fmt.Errorf("panic in WhatsApp handler: %w", err))
 			} else {
@@ -215,7 +286,7 @@ func (wac *Conn) handle(message interface{}) {
 }
 
 func (wac *Conn) unsafeHandle(message interface{}) {
-	wac.handleWithCustomHandlers(message, wac.handler)
+	wac.handleWithCustomHandlers(message, wac.handlers())
 }
 
 func (wac *Conn) handleWithCustomHandlers(message interface{}, handlers []Handler) {
@@ -396,6 +467,72 @@ func (wac *Conn) handleWithCustomHandlers(message interface{}, handlers []Handle
 				}
 			}
 		}
+
+	case ConnectionEvent:
+		for _, h := range handlers {
+			if x, ok := h.(ConnectionEventHandler); ok {
+				if wac.shouldCallSynchronously(h) {
+					x.HandleConnectionEvent(m)
+				} else {
+					go x.HandleConnectionEvent(m)
+				}
+			}
+		}
+
+	case StateChange:
+		for _, h := range handlers {
+			if x, ok := h.(StateHandler); ok {
+				if wac.shouldCallSynchronously(h) {
+					x.HandleStateChange(m)
+				} else {
+					go x.HandleStateChange(m)
+				}
+			}
+		}
+
+	case OutboxResult:
+		for _, h := range handlers {
+			if x, ok := h.(OutboxHandler); ok {
+				if wac.shouldCallSynchronously(h) {
+					x.HandleOutboxResult(m)
+				} else {
+					go x.HandleOutboxResult(m)
+				}
+			}
+		}
+
+	case LoginEvent:
+		for _, h := range handlers {
+			if x, ok := h.(LoginEventHandler); ok {
+				if wac.shouldCallSynchronously(h) {
+					x.HandleLoginEvent(m)
+				} else {
+					go x.HandleLoginEvent(m)
+				}
+			}
+		}
+
+	case ServerEvent:
+		for _, h := range handlers {
+			if x, ok := h.(ServerEventHandler); ok {
+				if wac.shouldCallSynchronously(h) {
+					x.HandleServerEvent(m)
+				} else {
+					go x.HandleServerEvent(m)
+				}
+			}
+		}
+
+	case PhoneEvent:
+		for _, h := range handlers {
+			if x, ok := h.(PhoneEventHandler); ok {
+				if wac.shouldCallSynchronously(h) {
+					x.HandlePhoneEvent(m)
+				} else {
+					go x.HandlePhoneEvent(m)
+				}
+			}
+		}
 	}
 
 }
@@ -420,7 +557,7 @@ func (wac *Conn) handleContacts(contacts interface{}) {
 			contactNode.Attributes["short"],
 		})
 	}
-	for _, h := range wac.handler {
+	for _, h := range wac.handlers() {
 		if x, ok := h.(ContactListHandler); ok {
 			if wac.shouldCallSynchronously(h) {
 				x.HandleContactList(contactList)
@@ -453,7 +590,7 @@ func (wac *Conn) handleChats(chats interface{}) {
 			chatNode.Attributes["spam"],
 		})
 	}
-	for _, h := range wac.handler {
+	for _, h := range wac.handlers() {
 		if x, ok := h.(ChatListHandler); ok {
 			if wac.shouldCallSynchronously(h) {
 				x.HandleChatList(chatList)
@@ -464,6 +601,15 @@ func (wac *Conn) handleChats(chats interface{}) {
 	}
 }
 
+// handleNode parses and handles a node of an action, battery updates are taken over by the Conn first.
+func (wac *Conn) handleNode(n binary.Node) {
+	message := ParseNodeMessage(n)
+	if battery, ok := message.(BatteryMessage); ok {
+		wac.updateBattery(battery.Percentage, battery.Plugged)
+	}
+	wac.handle(message)
+}
+
 func (wac *Conn) dispatch(msg interface{}) {
 	if msg == nil {
 		return
@@ -480,12 +626,12 @@ func (wac *Conn) dispatch(msg interface{}) {
 					}
 
 					if v, ok := con[a].(binary.Node); ok {
-						wac.handle(ParseNodeMessage(v))
+						wac.handleNode(v)
 					}
 				}
 			} else if con, ok := message.Content.([]binary.Node); ok {
 				for a := range con {
-					wac.handle(ParseNodeMessage(con[a]))
+					wac.handleNode(con[a])
 				}
 			} else {
 				wac.handle(message)
@@ -504,6 +650,6 @@ func (wac *Conn) dispatch(msg interface{}) {
 	case string:
 		wac.handle(message)
 	default:
-		fmt.Fprintf(os.Stderr, "unknown type in dipatcher chan: %T", msg)
+		wac.logger().Error("unknown type in dispatcher", "type", fmt.Sprintf("%T", msg))
 	}
 }
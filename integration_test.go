@@ -0,0 +1,403 @@
+package whatsapp_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/binary/proto"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/binary"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+func newTestConn(t *testing.T, srv *whatsapptest.Server, timeout time.Duration) *whatsapp.Conn {
+	t.Helper()
+	wac, err := whatsapp.NewConnWithTransport(timeout, &whatsapp.WebsocketTransport{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("error creating connection: %v", err)
+	}
+	t.Cleanup(func() { _, _ = wac.Disconnect() })
+	return wac
+}
+
+func restoredTestConn(t *testing.T, srv *whatsapptest.Server) *whatsapp.Conn {
+	t.Helper()
+	wac := newTestConn(t, srv, time.Second)
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	return wac
+}
+
+/*
+recorder is a handler recording everything a Conn dispatches, in order. It is called synchronously and never blocks
+the read loop, the next* methods wait for the next unread event of their type. The zero value is ready to use.
+*/
+type recorder struct {
+	mu     sync.Mutex
+	events []interface{}
+	read   map[reflect.Type]int // index of the next unread event per type
+}
+
+// handlerError wraps the errors passed to HandleError, so they are recorded as one type.
+type handlerError struct {
+	err error
+}
+
+// newRecorder returns a recorder added to wac.
+func newRecorder(wac *whatsapp.Conn) *recorder {
+	r := &recorder{}
+	wac.AddHandler(r)
+	return r
+}
+
+func (r *recorder) ShouldCallSynchronously() bool { return true }
+
+func (r *recorder) HandleError(err error)                                { r.record(handlerError{err}) }
+func (r *recorder) HandleTextMessage(message whatsapp.TextMessage)       { r.record(message) }
+func (r *recorder) HandleStateChange(change whatsapp.StateChange)        { r.record(change) }
+func (r *recorder) HandleOutboxResult(result whatsapp.OutboxResult)      { r.record(result) }
+func (r *recorder) HandlePhoneEvent(event whatsapp.PhoneEvent)           { r.record(event) }
+func (r *recorder) HandleConnectionEvent(event whatsapp.ConnectionEvent) { r.record(event) }
+func (r *recorder) HandleLoginEvent(event whatsapp.LoginEvent)           { r.record(event) }
+func (r *recorder) HandleServerEvent(event whatsapp.ServerEvent)         { r.record(event) }
+
+func (r *recorder) record(event interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// unread returns the next unread event of the type of sample, if any, and marks it read.
+func (r *recorder) unread(sample interface{}) (interface{}, bool) {
+	typ := reflect.TypeOf(sample)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.read == nil {
+		r.read = make(map[reflect.Type]int)
+	}
+	for i := r.read[typ]; i < len(r.events); i++ {
+		if reflect.TypeOf(r.events[i]) == typ {
+			r.read[typ] = i + 1
+			return r.events[i], true
+		}
+	}
+	r.read[typ] = len(r.events)
+	return nil, false
+}
+
+// next waits for the next unread event of the type of sample.
+func (r *recorder) next(t *testing.T, sample interface{}) interface{} {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if event, ok := r.unread(sample); ok {
+			return event
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("no %T dispatched", sample)
+	return nil
+}
+
+// expectNone fails if an event of the type of sample is dispatched within d.
+func (r *recorder) expectNone(t *testing.T, sample interface{}, d time.Duration) {
+	t.Helper()
+	time.Sleep(d)
+	if event, ok := r.unread(sample); ok {
+		t.Errorf("unexpected %+v", event)
+	}
+}
+
+func (r *recorder) nextError(t *testing.T) error {
+	t.Helper()
+	return r.next(t, handlerError{}).(handlerError).err
+}
+
+func (r *recorder) nextTextMessage(t *testing.T) whatsapp.TextMessage {
+	t.Helper()
+	return r.next(t, whatsapp.TextMessage{}).(whatsapp.TextMessage)
+}
+
+func (r *recorder) nextOutboxResult(t *testing.T) whatsapp.OutboxResult {
+	t.Helper()
+	return r.next(t, whatsapp.OutboxResult{}).(whatsapp.OutboxResult)
+}
+
+func (r *recorder) nextPhoneEvent(t *testing.T, want whatsapp.PhoneEventType) whatsapp.PhoneEvent {
+	t.Helper()
+	event := r.next(t, whatsapp.PhoneEvent{}).(whatsapp.PhoneEvent)
+	if event.Type != want {
+		t.Fatalf("expected %v, got %+v", want, event)
+	}
+	return event
+}
+
+func (r *recorder) nextConnectionEvent(t *testing.T, want whatsapp.ConnectionEventType) whatsapp.ConnectionEvent {
+	t.Helper()
+	event := r.next(t, whatsapp.ConnectionEvent{}).(whatsapp.ConnectionEvent)
+	if event.Type != want {
+		t.Fatalf("expected %v, got %+v", want, event)
+	}
+	return event
+}
+
+func (r *recorder) nextLoginEvent(t *testing.T, want whatsapp.LoginEventType) whatsapp.LoginEvent {
+	t.Helper()
+	event := r.next(t, whatsapp.LoginEvent{}).(whatsapp.LoginEvent)
+	if event.Type != want {
+		t.Fatalf("expected %v, got %+v", want, event)
+	}
+	return event
+}
+
+// nextServerEvent skips the ConnInfoUpdated events the server sends at any time.
+func (r *recorder) nextServerEvent(t *testing.T, want whatsapp.ServerEventType) whatsapp.ServerEvent {
+	t.Helper()
+	for {
+		event := r.next(t, whatsapp.ServerEvent{}).(whatsapp.ServerEvent)
+		if event.Type == want {
+			return event
+		}
+		if event.Type != whatsapp.ConnInfoUpdated {
+			t.Fatalf("expected %v, got %+v", want, event)
+		}
+	}
+}
+
+// states returns the states the Conn changed to.
+func (r *recorder) states() []whatsapp.ConnState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var states []whatsapp.ConnState
+	for _, event := range r.events {
+		if change, ok := event.(whatsapp.StateChange); ok {
+			states = append(states, change.To)
+		}
+	}
+	return states
+}
+
+// handlerErrors returns the errors passed to HandleError.
+func (r *recorder) handlerErrors() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var errs []error
+	for _, event := range r.events {
+		if e, ok := event.(handlerError); ok {
+			errs = append(errs, e.err)
+		}
+	}
+	return errs
+}
+
+func TestLoginWithQRCode(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := newTestConn(t, srv, time.Second)
+
+	qr := make(chan string)
+	scanned := make(chan whatsapptest.Credentials, 1)
+	go func() {
+		creds, err := srv.Scan(<-qr)
+		if err != nil {
+			t.Errorf("error scanning qr code: %v", err)
+		}
+		scanned <- creds
+	}()
+
+	session, err := wac.Login(qr)
+	if err != nil {
+		t.Fatalf("error during login: %v", err)
+	}
+	creds := <-scanned
+	if !reflect.DeepEqual(session, whatsapp.Session(creds)) {
+		t.Errorf("session does not match the server credentials:\n%+v\n%+v", session, creds)
+	}
+	if !wac.IsLoggedIn() {
+		t.Error("connection is not logged in")
+	}
+	if wac.GetInfo().Wid != creds.Wid {
+		t.Errorf("info wid: got %q, want %q", wac.GetInfo().Wid, creds.Wid)
+	}
+}
+
+func TestRestoreSession(t *testing.T) {
+	for _, challenge := range []bool{false, true} {
+		srv := whatsapptest.NewServer()
+		srv.RequireChallenge(challenge)
+		wac := newTestConn(t, srv, time.Second)
+
+		creds := srv.Pair()
+		session, err := wac.RestoreWithSession(whatsapp.Session(creds))
+		if err != nil {
+			t.Fatalf("challenge %v: error restoring session: %v", challenge, err)
+		}
+		rotated := srv.Credentials()
+		if session.ClientToken != rotated.ClientToken || session.ServerToken != rotated.ServerToken {
+			t.Errorf("challenge %v: tokens were not rotated", challenge)
+		}
+		if session.ClientToken == creds.ClientToken {
+			t.Errorf("challenge %v: client token did not change", challenge)
+		}
+		_, _ = wac.Disconnect()
+		srv.Close()
+	}
+}
+
+func TestRestoreContextDeadlineWins(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := newTestConn(t, srv, 100*time.Millisecond)
+	srv.SetResponseDelay(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := wac.RestoreWithSessionContext(ctx, whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("restore did not wait for the deadline of ctx: %v", err)
+	}
+}
+
+func TestRestoreUnpairedSession(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := newTestConn(t, srv, 200*time.Millisecond)
+
+	session := whatsapp.Session(srv.Pair())
+	session.ClientToken = "invalid"
+	if _, err := wac.RestoreWithSession(session); !errors.Is(err, whatsapp.ErrUnpaired) {
+		t.Fatalf("expected ErrUnpaired, got %v", err)
+	}
+}
+
+func TestReceiveTextMessage(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := restoredTestConn(t, srv)
+	events := newRecorder(wac)
+
+	jid, id, text, fromMe := "4915100000000@s.whatsapp.net", "3EB0C431C26A1916E07A", "incoming", false
+	ts := uint64(time.Now().Unix())
+	if err := srv.PushMessage(&proto.WebMessageInfo{
+		Key:              &proto.MessageKey{RemoteJID: &jid, ID: &id, FromMe: &fromMe},
+		MessageTimestamp: &ts,
+		Message:          &proto.Message{Conversation: &text},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := events.nextTextMessage(t); msg.Text != text || msg.Info.Id != id || msg.Info.RemoteJid != jid {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+}
+
+func TestTypedRequests(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	srv.HandleJSON("query", "GroupMetadata", func(args []interface{}) interface{} {
+		return map[string]interface{}{
+			"id":      args[2],
+			"owner":   "4915100000000@c.us",
+			"subject": "test group",
+			"descId":  "desc-1",
+			"participants": []map[string]interface{}{
+				{"id": "4915100000000@c.us", "isAdmin": true, "isSuperAdmin": true},
+				{"id": "4915100000001@c.us", "isAdmin": false, "isSuperAdmin": false},
+			},
+		}
+	})
+	srv.HandleJSON("query", "exist", func(args []interface{}) interface{} {
+		if args[2] == "4915100000000@c.us" {
+			return map[string]interface{}{"status": 200, "jid": "4915100000000@c.us"}
+		}
+		return map[string]interface{}{"status": 404}
+	})
+	srv.HandleJSON("query", "Status", func(args []interface{}) interface{} {
+		return map[string]interface{}{"status": "available"}
+	})
+	srv.HandleJSON("query", "ProfilePicThumb", func(args []interface{}) interface{} {
+		return map[string]interface{}{"status": 401}
+	})
+	srv.HandleAction("group", func(action *binary.Node) interface{} {
+		return map[string]interface{}{
+			"status":       200,
+			"gid":          "4915100000000-1600000000@g.us",
+			"participants": []map[string]int{{"4915100000001@c.us": 200}},
+		}
+	})
+	wac := restoredTestConn(t, srv)
+	ctx := context.Background()
+
+	metadata, err := wac.GetGroupMetaDataContext(ctx, "4915100000000-1600000000@g.us")
+	if err != nil {
+		t.Fatalf("error getting group metadata: %v", err)
+	}
+	if metadata.Subject != "test group" || metadata.DescriptionID != "desc-1" || len(metadata.Participants) != 2 || !metadata.Participants[0].IsSuperAdmin {
+		t.Errorf("unexpected group metadata %+v", metadata)
+	}
+
+	if result, err := wac.ExistContext(ctx, "4915100000000@c.us"); err != nil || !result.Exists || result.JID != "4915100000000@c.us" {
+		t.Errorf("expected existing jid, got %+v, %v", result, err)
+	}
+	if result, err := wac.ExistContext(ctx, "4915100000009@c.us"); err != nil || result.Exists {
+		t.Errorf("expected missing jid, got %+v, %v", result, err)
+	}
+
+	if status, err := wac.GetStatusContext(ctx, "4915100000000@c.us"); err != nil || status != "available" {
+		t.Errorf("unexpected status %q: %v", status, err)
+	}
+
+	var resp whatsapp.StatusResponse
+	if _, err := wac.GetProfilePicThumbContext(ctx, "4915100000000@c.us"); !errors.As(err, &resp) || resp.Status != 401 {
+		t.Errorf("expected status 401, got %v", err)
+	}
+
+	result, err := wac.CreateGroupContext(ctx, "test group", []string{"4915100000001@c.us"})
+	if err != nil {
+		t.Fatalf("error creating group: %v", err)
+	}
+	if result.JID != "4915100000000-1600000000@g.us" || result.Participants["4915100000001@c.us"] != 200 {
+		t.Errorf("unexpected group result %+v", result)
+	}
+
+	if err := wac.PresenceContext(ctx, "", whatsapp.PresenceAvailable); err != nil {
+		t.Errorf("error setting presence: %v", err)
+	}
+	if err := wac.UpdateGroupDescriptionContext(ctx, "4915100000000-1600000000@g.us", "new description"); err != nil {
+		t.Errorf("error updating group description: %v", err)
+	}
+}
+
+func TestInvalidServerResponse(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := restoredTestConn(t, srv)
+	defer wac.Disconnect()
+	events := newRecorder(wac)
+
+	srv.HandleJSON("query", "inviteCode", func(args []interface{}) interface{} {
+		return map[string]interface{}{"status": 200, "code": 42}
+	})
+	_, err := wac.GroupInviteLink("123-456@g.us")
+	var invalid *whatsapp.ErrInvalidResponse
+	if !errors.Is(err, whatsapp.ErrInvalidServerResponse) || !errors.As(err, &invalid) || !strings.Contains(invalid.Payload, `"code":42`) {
+		t.Fatalf("expected ErrInvalidResponse with the payload, got %v", err)
+	}
+
+	// an unsolicited frame of the wrong shape is reported without breaking the connection
+	if err := srv.PushJSON([]interface{}{"Conn", map[string]interface{}{"battery": "full"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := events.nextError(t); !errors.Is(err, whatsapp.ErrInvalidServerResponse) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := wac.AdminTest(); err != nil {
+		t.Errorf("connection broken by an invalid frame: %v", err)
+	}
+}
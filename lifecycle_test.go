@@ -0,0 +1,137 @@
+package whatsapp_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+func TestStateChanges(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := newTestConn(t, srv, time.Second)
+	if s := wac.State(); s != whatsapp.StateConnecting {
+		t.Fatalf("expected state Connecting after connect, got %v", s)
+	}
+	events := newRecorder(wac)
+
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	if _, err := wac.Disconnect(); err != nil {
+		t.Fatalf("error disconnecting: %v", err)
+	}
+
+	expected := []whatsapp.ConnState{whatsapp.StateLoggingIn, whatsapp.StateLoggedIn, whatsapp.StateDisconnected}
+	if states := events.states(); !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected states %v, got %v", expected, states)
+	}
+	for _, err := range events.handlerErrors() {
+		if errors.Is(err, whatsapp.ErrInvalidStateTransition) {
+			t.Error(err)
+		}
+	}
+}
+
+func TestStateLoggedOutAfterUnpairedRestore(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := newTestConn(t, srv, 200*time.Millisecond)
+
+	session := whatsapp.Session(srv.Pair())
+	session.ClientToken = "invalid"
+	if _, err := wac.RestoreWithSession(session); !errors.Is(err, whatsapp.ErrUnpaired) {
+		t.Fatalf("expected ErrUnpaired, got %v", err)
+	}
+	if s := wac.State(); s != whatsapp.StateLoggedOut {
+		t.Errorf("expected state LoggedOut, got %v", s)
+	}
+	if _, err := wac.Disconnect(); err != nil {
+		t.Fatalf("error disconnecting: %v", err)
+	}
+	if s := wac.State(); s != whatsapp.StateLoggedOut {
+		t.Errorf("expected state LoggedOut to be kept after disconnect, got %v", s)
+	}
+}
+
+func TestCloseDrainsPendingSends(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := restoredTestConn(t, srv)
+	srv.SetResponseDelay(200 * time.Millisecond)
+
+	sent := make(chan error, 1)
+	go func() {
+		_, err := wac.Send(whatsapp.TextMessage{Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: "hello"})
+		sent <- err
+	}()
+	if _, err := srv.WaitForMessage(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	session, err := wac.Close(ctx)
+	if err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	if session.Wid != srv.Credentials().Wid || session.ClientToken == "" {
+		t.Errorf("unexpected session returned: %v", session)
+	}
+	if err := <-sent; err != nil {
+		t.Errorf("in-flight send was not drained: %v", err)
+	}
+
+	if _, err := wac.Send(whatsapp.TextMessage{Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: "late"}); !errors.Is(err, whatsapp.ErrClosed) {
+		t.Errorf("expected ErrClosed for a send after Close, got %v", err)
+	}
+	if _, err := wac.RestoreWithSession(session); !errors.Is(err, whatsapp.ErrClosed) {
+		t.Errorf("expected ErrClosed for a restore after Close, got %v", err)
+	}
+}
+
+func TestCloseFailsPendingRequests(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := newTestConn(t, srv, 10*time.Second)
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	srv.SetResponseDelay(5 * time.Second)
+
+	sent := make(chan error, 1)
+	go func() {
+		_, err := wac.Send(whatsapp.TextMessage{Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: "hello"})
+		sent <- err
+	}()
+	if _, err := srv.WaitForMessage(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	session, err := wac.Close(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline of the drain, got %v", err)
+	}
+	if session.Wid == "" {
+		t.Error("no session returned")
+	}
+	select {
+	case err := <-sent:
+		if !errors.Is(err, whatsapp.ErrClosed) {
+			t.Errorf("expected ErrClosed for the pending send, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending send still waiting after Close")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Close took %v", d)
+	}
+}
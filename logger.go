@@ -0,0 +1,65 @@
+package whatsapp
+
+import (
+	"fmt"
+)
+
+/*
+Logger receives the log output of a Conn. The arguments following the message are alternating keys and values, as
+in log/slog, so a *slog.Logger can be passed to WithLogger directly. Implementations must be safe for concurrent use.
+
+Keys used by the Conn are "tag" for the message tag of a frame, "jid" and "id" for messages, "state" for state changes
+and "err" for errors. Key material and tokens are never logged.
+*/
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// nopLogger discards everything, it is used if no Logger is configured.
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// logger returns the configured Logger, a Conn without one logs nothing.
+func (wac *Conn) logger() Logger {
+	if wac.log == nil {
+		return nopLogger{}
+	}
+	return wac.log
+}
+
+// redacted replaces secret bytes in log output, only the length is kept.
+func redacted(secret []byte) string {
+	if len(secret) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("[redacted %d bytes]", len(secret))
+}
+
+// redactedToken replaces a secret token in log output.
+func redactedToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+/*
+String describes the session without its secrets, so a Session can be logged or printed safely. The client id is kept,
+it identifies the session in the device list of the phone.
+*/
+func (s Session) String() string {
+	return fmt.Sprintf("{ClientId:%s ClientToken:%s ServerToken:%s EncKey:%s MacKey:%s Wid:%s}",
+		s.ClientId, redactedToken(s.ClientToken), redactedToken(s.ServerToken), redacted(s.EncKey), redacted(s.MacKey), s.Wid)
+}
+
+// GoString keeps the secrets out of %#v as well.
+func (s Session) GoString() string {
+	return "whatsapp.Session" + s.String()
+}
@@ -0,0 +1,57 @@
+package whatsapp
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSessionStringRedactsSecrets(t *testing.T) {
+	s := Session{
+		ClientId:    "client",
+		ClientToken: "client-token",
+		ServerToken: "server-token",
+		EncKey:      []byte("0123456789abcdef0123456789abcdef"),
+		MacKey:      []byte("fedcba9876543210fedcba9876543210"),
+		Wid:         "4915100000000@c.us",
+	}
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		out := fmt.Sprintf(format, s)
+		for _, secret := range []string{"client-token", "server-token", "0123456789abcdef", "fedcba9876543210", "48 49 50"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("%s leaks %q: %s", format, secret, out)
+			}
+		}
+		if !strings.Contains(out, "client") || !strings.Contains(out, s.Wid) {
+			t.Errorf("%s lost the client id or wid: %s", format, out)
+		}
+	}
+}
+
+func TestConnWithoutLoggerDiscards(t *testing.T) {
+	// the standard logger catches anything falling back to log or the default slog.Logger
+	var out bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&out)
+
+	wac := &Conn{}
+	if _, ok := wac.logger().(nopLogger); !ok {
+		t.Fatalf("expected the nop logger, got %T", wac.logger())
+	}
+	wac.logger().Debug("nothing happens", "err", ErrNotConnected)
+	wac.logger().Info("nothing happens", "err", ErrNotConnected)
+	wac.logger().Warn("nothing happens", "err", ErrNotConnected)
+	wac.logger().Error("nothing happens", "err", ErrNotConnected)
+	if out.Len() != 0 {
+		t.Errorf("a Conn without logger wrote %q", out.String())
+	}
+
+	configured := slog.New(slog.NewTextHandler(&out, nil))
+	wac.log = configured
+	if wac.logger() != Logger(configured) {
+		t.Errorf("expected the configured logger, got %T", wac.logger())
+	}
+}
@@ -0,0 +1,71 @@
+package whatsapp_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+// syncBuffer is a bytes.Buffer that may be written by several goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLoggerReceivesLifecycleWithoutSecrets(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+
+	var out syncBuffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithLogger(logger),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := srv.Pair()
+	session, err := wac.RestoreWithSession(whatsapp.Session(creds))
+	if err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	if _, err := wac.Send(whatsapp.TextMessage{Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: "hello"}); err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	logger.Info("session", "session", session)
+	_, _ = wac.Disconnect()
+
+	logs := out.String()
+	for _, want := range []string{"msg=connected", "msg=\"session restored\"", "msg=\"relaying message\"", "msg=\"sent binary frame\"", "msg=disconnected", "state=LoggedIn"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("log output is missing %s", want)
+		}
+	}
+	for _, secret := range []string{creds.ClientToken, creds.ServerToken, session.ClientToken, session.ServerToken,
+		base64.StdEncoding.EncodeToString(creds.EncKey), base64.StdEncoding.EncodeToString(creds.MacKey)} {
+		if strings.Contains(logs, secret) {
+			t.Errorf("log output leaks a secret:\n%s", logs)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package whatsapp
+
+import (
+	"time"
+)
+
+type LoginEventType int
+
+const (
+	// QRIssued is emitted with the first qr code of a login.
+	QRIssued LoginEventType = iota
+	// QRRefreshed is emitted whenever the previous qr code expired and was replaced.
+	QRRefreshed
+	// QRScanned is emitted as soon as the phone scanned the qr code, before the keys are exchanged.
+	QRScanned
+	// LoginSucceeded is emitted once the Conn is logged in.
+	LoginSucceeded
+	// LoginFailed is emitted if the login failed, was cancelled or no qr code was scanned in time.
+	LoginFailed
+)
+
+func (t LoginEventType) String() string {
+	switch t {
+	case QRIssued:
+		return "qr issued"
+	case QRRefreshed:
+		return "qr refreshed"
+	case QRScanned:
+		return "qr scanned"
+	case LoginSucceeded:
+		return "login succeeded"
+	case LoginFailed:
+		return "login failed"
+	}
+	return "unknown"
+}
+
+/*
+LoginEvent describes the progress of a login. It is passed to the callback of LoginWithEvents and dispatched to every
+handler implementing LoginEventHandler, for logins started with any of the Login functions.
+*/
+type LoginEvent struct {
+	Type LoginEventType
+
+	// QR is the data to show as qr code, it can be scanned for TTL until Expires. Only set for QRIssued and
+	// QRRefreshed.
+	QR      string
+	TTL     time.Duration
+	Expires time.Time
+	// Attempt counts the qr codes of the login, starting with 0 for the issued one.
+	Attempt int
+
+	// Session and Info are set for LoginSucceeded, Err for LoginFailed.
+	Session Session
+	Info    *Info
+	Err     error
+}
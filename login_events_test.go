@@ -0,0 +1,115 @@
+package whatsapp_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+func TestLoginWithEvents(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	srv.SetQRTTL(100 * time.Millisecond)
+	wac := newTestConn(t, srv, time.Second)
+	handler := newRecorder(wac)
+
+	var events []whatsapp.LoginEvent
+	session, err := wac.LoginWithEvents(context.Background(), 3, func(event whatsapp.LoginEvent) {
+		events = append(events, event)
+		switch event.Type {
+		case whatsapp.QRIssued:
+			// the refreshed qr code lives long enough to be scanned
+			srv.SetQRTTL(time.Minute)
+		case whatsapp.QRRefreshed:
+			go func() {
+				if _, err := srv.Scan(event.QR); err != nil {
+					t.Errorf("error scanning qr code: %v", err)
+				}
+			}()
+		}
+	})
+	if err != nil {
+		t.Fatalf("error during login: %v", err)
+	}
+
+	types := make([]whatsapp.LoginEventType, len(events))
+	for i, event := range events {
+		types[i] = event.Type
+	}
+	want := []whatsapp.LoginEventType{whatsapp.QRIssued, whatsapp.QRRefreshed, whatsapp.QRScanned, whatsapp.LoginSucceeded}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("unexpected events %v", types)
+	}
+	issued, refreshed, succeeded := events[0], events[1], events[3]
+	if issued.TTL != 100*time.Millisecond || issued.Attempt != 0 || issued.QR == "" || time.Until(issued.Expires) > issued.TTL {
+		t.Errorf("unexpected issued qr code %+v", issued)
+	}
+	if refreshed.TTL != time.Minute || refreshed.Attempt != 1 || refreshed.QR == issued.QR {
+		t.Errorf("unexpected refreshed qr code %+v", refreshed)
+	}
+	if !reflect.DeepEqual(succeeded.Session, session) || succeeded.Info == nil || succeeded.Info.Wid != session.Wid {
+		t.Errorf("unexpected success %+v", succeeded)
+	}
+	for _, typ := range want {
+		handler.nextLoginEvent(t, typ)
+	}
+}
+
+func TestLoginWithEventsCancelled(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := newTestConn(t, srv, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var failure whatsapp.LoginEvent
+	_, err := wac.LoginWithEvents(ctx, 0, func(event whatsapp.LoginEvent) {
+		switch event.Type {
+		case whatsapp.QRIssued:
+			cancel()
+		case whatsapp.LoginFailed:
+			failure = event
+		}
+	})
+	if !errors.Is(err, context.Canceled) || !errors.Is(failure.Err, context.Canceled) {
+		t.Fatalf("expected a cancelled login, got %v and event %+v", err, failure)
+	}
+	if wac.IsConnected() {
+		t.Error("cancelled login did not disconnect")
+	}
+
+	// nothing of the cancelled login is left behind
+	session, err := wac.LoginWithEvents(context.Background(), 0, func(event whatsapp.LoginEvent) {
+		if event.Type == whatsapp.QRIssued {
+			go func() { _, _ = srv.Scan(event.QR) }()
+		}
+	})
+	if err != nil || session.Wid == "" {
+		t.Fatalf("error logging in after a cancelled login: %v", err)
+	}
+}
+
+func TestLoginUnreadQRChan(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	srv.SetQRTTL(50 * time.Millisecond)
+	wac := newTestConn(t, srv, time.Second)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := wac.LoginWithRetry(make(chan string), 1)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if !errors.Is(err, whatsapp.ErrLoginTimedOut) {
+			t.Fatalf("expected ErrLoginTimedOut, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("login blocked on an unread qr channel")
+	}
+}
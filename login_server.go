@@ -0,0 +1,222 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp/qrcode"
+)
+
+/*
+LoginServer is an http.Handler for logging in on headless servers: it shows the qr code of a running login in the
+browser, where the phone can scan it. It serves
+
+	/          a page showing the qr code, which follows the events below
+	/qr.png    the current qr code as PNG image
+	/qr.svg    the current qr code as SVG image
+	/events    Server-Sent Events: "qr" whenever a new qr code is issued, with the time it expires at in
+	           milliseconds since the epoch, "scanned" once the phone scanned it, then "success" or "failure"
+
+relative to the path it is mounted at, e.g. with mux.Handle("/login/", http.StripPrefix("/login", server)). The qr
+code images respond with 404 while there is no qr code to scan.
+*/
+type LoginServer struct {
+	wac     *Conn
+	running atomicBool
+
+	mu      sync.Mutex
+	seq     int // number of the current qr code, 0 before the first one
+	qr      string
+	code    *qrcode.Code
+	expires time.Time
+	scanned bool
+	done    bool
+	wid     string
+	err     error
+	changed chan struct{} // closed and replaced on every change
+}
+
+// NewLoginServer creates a LoginServer for logins of wac, which are started with LoginServer.Login.
+func NewLoginServer(wac *Conn) *LoginServer {
+	return &LoginServer{wac: wac, changed: make(chan struct{})}
+}
+
+// Login logs wac in like LoginWithEvents, while the qr codes and the progress are served over HTTP.
+func (s *LoginServer) Login(ctx context.Context, maxRetries int) (Session, error) {
+	if !s.running.CompareAndSwap(false, true) {
+		return Session{}, ErrLoginInProgress
+	}
+	defer s.running.Store(false)
+	s.update(func() {
+		s.qr, s.code, s.expires, s.scanned, s.done, s.wid, s.err = "", nil, time.Time{}, false, false, "", nil
+	})
+
+	session, err := s.wac.LoginWithEvents(ctx, maxRetries, func(event LoginEvent) {
+		switch event.Type {
+		case QRIssued, QRRefreshed:
+			code, err := qrcode.EncodeString(event.QR, qrcode.Medium)
+			if err != nil {
+				s.wac.logger().Error("error encoding qr code", "err", err)
+			}
+			s.update(func() {
+				s.seq++
+				s.qr, s.code, s.expires = event.QR, code, event.Expires
+			})
+		case QRScanned:
+			s.update(func() {
+				s.qr, s.code, s.scanned = "", nil, true
+			})
+		}
+	})
+	s.update(func() {
+		s.qr, s.code, s.done, s.wid, s.err = "", nil, true, session.Wid, err
+	})
+	return session, err
+}
+
+// QR returns the data of the qr code that can currently be scanned, or an empty string.
+func (s *LoginServer) QR() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.qr
+}
+
+// update changes the state with f and wakes up all event streams.
+func (s *LoginServer) update(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *LoginServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/qr.png"):
+		s.serveCode(w, "image/png", func(c *qrcode.Code) ([]byte, error) { return c.PNG(8) })
+	case strings.HasSuffix(r.URL.Path, "/qr.svg"):
+		s.serveCode(w, "image/svg+xml", func(c *qrcode.Code) ([]byte, error) { return c.SVG(), nil })
+	case strings.HasSuffix(r.URL.Path, "/events"):
+		s.serveEvents(w, r)
+	default:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = loginPage.Execute(w, nil)
+	}
+}
+
+func (s *LoginServer) serveCode(w http.ResponseWriter, contentType string, render func(*qrcode.Code) ([]byte, error)) {
+	s.mu.Lock()
+	code := s.code
+	s.mu.Unlock()
+	if code == nil {
+		http.Error(w, "no qr code to scan", http.StatusNotFound)
+		return
+	}
+	data, err := render(code)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(data)
+}
+
+// serveEvents streams the qr codes and the result of the login. A stream joining late starts with the current state.
+func (s *LoginServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sent, sentScanned := 0, false
+	for {
+		s.mu.Lock()
+		seq, hasCode, expires, scanned, changed := s.seq, s.code != nil, s.expires, s.scanned, s.changed
+		done, wid, err := s.done, s.wid, s.err
+		s.mu.Unlock()
+
+		if hasCode && seq != sent {
+			writeEvent(w, "qr", map[string]int64{"seq": int64(seq), "expires": expires.UnixNano() / int64(time.Millisecond)})
+			sent = seq
+		}
+		if scanned && !sentScanned {
+			writeEvent(w, "scanned", struct{}{})
+			sentScanned = true
+		}
+		if done && err != nil {
+			writeEvent(w, "failure", map[string]string{"error": err.Error()})
+		} else if done {
+			writeEvent(w, "success", map[string]string{"wid": wid})
+		}
+		flusher.Flush()
+		if done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, data interface{}) {
+	payload, _ := json.Marshal(data)
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>WhatsApp login</title>
+<style>
+body { font-family: sans-serif; text-align: center; margin-top: 3em; }
+img { width: 320px; height: 320px; }
+</style>
+</head>
+<body>
+<img id="qr" alt="">
+<p id="status">Waiting for the qr code…</p>
+<script>
+var img = document.getElementById("qr"), status = document.getElementById("status");
+var events = new EventSource("events");
+events.addEventListener("qr", function (e) {
+	img.src = "qr.svg?seq=" + JSON.parse(e.data).seq;
+	status.textContent = "Scan the qr code with WhatsApp on your phone.";
+});
+events.addEventListener("scanned", function () {
+	img.removeAttribute("src");
+	status.textContent = "Logging in…";
+});
+events.addEventListener("success", function (e) {
+	events.close();
+	img.removeAttribute("src");
+	status.textContent = "Logged in as " + JSON.parse(e.data).wid + ".";
+});
+events.addEventListener("failure", function (e) {
+	events.close();
+	img.removeAttribute("src");
+	status.textContent = "Login failed: " + JSON.parse(e.data).error;
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,180 @@
+package whatsapp_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+type sseEvent struct {
+	name, data string
+}
+
+// readEvents parses the Server-Sent Events of body into events until body is closed.
+func readEvents(body io.Reader, events chan<- sseEvent) {
+	defer close(events)
+	scanner := bufio.NewScanner(body)
+	var event sseEvent
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			events <- event
+			event = sseEvent{}
+		}
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("event stream closed")
+		}
+		return event
+	case <-time.After(3 * time.Second):
+		t.Fatal("no event received")
+	}
+	return sseEvent{}
+}
+
+func TestLoginServer(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	srv.SetQRTTL(100 * time.Millisecond)
+	wac := newTestConn(t, srv, time.Second)
+	login := whatsapp.NewLoginServer(wac)
+	web := httptest.NewServer(login)
+	defer web.Close()
+
+	if resp, err := http.Get(web.URL + "/qr.png"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 before the login started, got %v %v", resp, err)
+	}
+	resp, err := http.Get(web.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	events := make(chan sseEvent, 8)
+	go readEvents(resp.Body, events)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := login.Login(context.Background(), 5)
+		result <- err
+	}()
+
+	qrEvent := func() (seq int, expires time.Time) {
+		t.Helper()
+		event := nextEvent(t, events)
+		var data struct{ Seq, Expires int64 }
+		if err := json.Unmarshal([]byte(event.data), &data); event.name != "qr" || err != nil {
+			t.Fatalf("unexpected event %+v", event)
+		}
+		return int(data.Seq), time.Unix(0, data.Expires*int64(time.Millisecond))
+	}
+	if seq, expires := qrEvent(); seq != 1 || expires.Before(time.Now().Add(-time.Second)) || expires.After(time.Now().Add(time.Second)) {
+		t.Fatalf("unexpected qr code %d expiring at %v", seq, expires)
+	}
+	first := login.QR()
+	// the next qr code is valid long enough to be scanned
+	srv.SetQRTTL(time.Minute)
+	for path, contentType := range map[string]string{"/qr.png": "image/png", "/qr.svg": "image/svg+xml"} {
+		resp, err := http.Get(web.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != contentType || len(body) == 0 {
+			t.Errorf("%s: unexpected response %d %q", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+	}
+
+	if seq, expires := qrEvent(); seq != 2 || expires.Before(time.Now().Add(50*time.Second)) {
+		t.Fatalf("qr code not refreshed: %d expiring at %v", seq, expires)
+	}
+	if login.QR() == first {
+		t.Error("refreshed qr code did not change")
+	}
+	if _, err := srv.Scan(login.QR()); err != nil {
+		t.Fatalf("error scanning qr code: %v", err)
+	}
+	if event := nextEvent(t, events); event.name != "scanned" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event := nextEvent(t, events); event.name != "success" || !strings.Contains(event.data, srv.Credentials().Wid) {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("error during login: %v", err)
+	}
+	if _, ok := <-events; ok {
+		t.Error("event stream not closed after the login")
+	}
+
+	page, err := http.Get(web.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(page.Body)
+	page.Body.Close()
+	if !strings.Contains(string(body), `new EventSource("events")`) {
+		t.Error("login page does not follow the events")
+	}
+}
+
+func TestLoginServerFailure(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := newTestConn(t, srv, time.Second)
+	login := whatsapp.NewLoginServer(wac)
+	web := httptest.NewServer(login)
+	defer web.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, err := login.Login(ctx, 0)
+		result <- err
+	}()
+
+	resp, err := http.Get(web.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := make(chan sseEvent, 8)
+	go readEvents(resp.Body, events)
+	if event := nextEvent(t, events); event.name != "qr" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	cancel()
+	if event := nextEvent(t, events); event.name != "failure" || !strings.Contains(event.data, "context canceled") {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if resp, err := http.Get(web.URL + "/qr.svg"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 after the login failed, got %v %v", resp, err)
+	}
+}
@@ -0,0 +1,320 @@
+package whatsapp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+/*
+HandlerFactory creates the handler of a single account managed by a Manager. It is called once for every account, so
+the returned handler knows which account its events belong to, while the handlers of all accounts can share state.
+*/
+type HandlerFactory func(wid string) Handler
+
+// ManagerConfig configures a Manager.
+type ManagerConfig struct {
+	// Store holds the sessions of the managed accounts. Every Conn saves its session to it whenever the tokens change
+	// and deletes it once the account is logged out, see WithSessionStore.
+	Store SessionStore
+	// Options are applied to the Conn of every account.
+	Options []Option
+	// AccountOptions returns additional options for the Conn of a single account, e.g. a proxy per account. Login
+	// calls it with an empty wid, as the account of a new login is only known after pairing.
+	AccountOptions func(wid string) []Option
+	// RestoreConcurrency limits how many sessions RestoreAll restores at the same time. Defaults to 4.
+	RestoreConcurrency int
+}
+
+// AccountStatus describes a single account of a Manager.
+type AccountStatus struct {
+	Wid       string
+	State     ConnState
+	Connected bool
+	LoggedIn  bool
+}
+
+// ManagerStatus is the aggregate status of all accounts of a Manager.
+type ManagerStatus struct {
+	Accounts []AccountStatus // sorted by Wid
+	ByState  map[ConnState]int
+}
+
+/*
+Manager runs the Conns of many accounts in one process. The Conns are keyed by the Wid of their session and share
+the handlers added with AddHandler. All methods are safe for concurrent use.
+*/
+type Manager struct {
+	cfg ManagerConfig
+
+	mu        sync.RWMutex
+	conns     map[string]*Conn
+	pending   map[string]struct{} // accounts being restored or added
+	factories []HandlerFactory
+}
+
+// NewManager creates a Manager without any accounts, use RestoreAll to restore the accounts stored in cfg.Store.
+func NewManager(cfg ManagerConfig) (*Manager, error) {
+	if cfg.Store == nil {
+		return nil, fmt.Errorf("%w: missing session store", ErrInvalidConfig)
+	}
+	if cfg.RestoreConcurrency < 0 {
+		return nil, fmt.Errorf("%w: negative restore concurrency", ErrInvalidConfig)
+	}
+	if cfg.RestoreConcurrency == 0 {
+		cfg.RestoreConcurrency = 4
+	}
+	return &Manager{cfg: cfg, conns: make(map[string]*Conn), pending: make(map[string]struct{})}, nil
+}
+
+/*
+AddHandler adds a handler created by factory to every current and future account.
+*/
+func (m *Manager) AddHandler(factory HandlerFactory) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.factories = append(m.factories, factory)
+	for wid, wac := range m.conns {
+		wac.AddHandler(factory(wid))
+	}
+}
+
+/*
+RestoreAll restores every session of the store that is not managed yet. At most RestoreConcurrency sessions are
+restored at the same time. The errors of the accounts that could not be restored are returned by Wid, err is only set
+if the store could not be listed.
+*/
+func (m *Manager) RestoreAll(ctx context.Context) (failed map[string]error, err error) {
+	wids, err := m.cfg.Store.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+
+	var mu sync.Mutex
+	failed = make(map[string]error)
+	sem := make(chan struct{}, m.cfg.RestoreConcurrency)
+	var wg sync.WaitGroup
+	for _, wid := range wids {
+		if _, ok := m.Conn(wid); ok {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			mu.Lock()
+			failed[wid] = ctx.Err()
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(wid string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if _, err := m.restoreStored(ctx, wid); err != nil {
+				mu.Lock()
+				failed[wid] = err
+				mu.Unlock()
+			}
+		}(wid)
+	}
+	wg.Wait()
+	return failed, nil
+}
+
+func (m *Manager) restoreStored(ctx context.Context, wid string) (*Conn, error) {
+	session, err := m.cfg.Store.Load(wid)
+	if err != nil {
+		return nil, fmt.Errorf("error loading session: %w", err)
+	}
+	return m.Restore(ctx, session)
+}
+
+/*
+Restore adds the account of session and restores it. The session with the new tokens is saved to the store by the Conn.
+Concurrent calls for the same account fail with ErrAccountExists until the first one returned.
+*/
+func (m *Manager) Restore(ctx context.Context, session Session) (*Conn, error) {
+	if err := m.reserve(session.Wid); err != nil {
+		return nil, err
+	}
+	defer m.release(session.Wid)
+	wac, attached, err := m.newConn(session.Wid)
+	if err != nil {
+		return nil, err
+	}
+	restored, err := wac.RestoreWithSessionContext(ctx, session)
+	if err != nil {
+		_, _ = wac.Disconnect()
+		return nil, err
+	}
+	if err := m.add(restored.Wid, wac, attached); err != nil {
+		_, _ = wac.Disconnect()
+		return nil, err
+	}
+	return wac, nil
+}
+
+/*
+Login pairs a new account by qr code, see Conn.LoginWithRetryContext. The new session is saved to the store and the
+account is managed from then on.
+*/
+func (m *Manager) Login(ctx context.Context, qrChan chan<- string) (*Conn, Session, error) {
+	wac, attached, err := m.newConn("")
+	if err != nil {
+		return nil, Session{}, err
+	}
+	session, err := wac.LoginWithRetryContext(ctx, qrChan, 0)
+	if err != nil {
+		_, _ = wac.Disconnect()
+		return nil, Session{}, err
+	}
+	if err := m.reserve(session.Wid); err != nil {
+		_, _ = wac.Disconnect()
+		return nil, Session{}, err
+	}
+	defer m.release(session.Wid)
+	if err := m.add(session.Wid, wac, attached); err != nil {
+		_, _ = wac.Disconnect()
+		return nil, Session{}, err
+	}
+	return wac, session, nil
+}
+
+/*
+newConn creates an unmanaged Conn for the account wid, which is empty for a new login. If wid is known, the shared
+handlers are added right away, so they receive the events of the restore as well. attached is the number of handlers
+added.
+*/
+func (m *Manager) newConn(wid string) (wac *Conn, attached int, err error) {
+	opts := append([]Option(nil), m.cfg.Options...)
+	if m.cfg.AccountOptions != nil {
+		opts = append(opts, m.cfg.AccountOptions(wid)...)
+	}
+	opts = append(opts, WithSessionStore(m.cfg.Store))
+	wac, err = NewConnWithOptions(opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if wid != "" {
+		m.mu.RLock()
+		factories := m.factories
+		m.mu.RUnlock()
+		for _, factory := range factories {
+			wac.AddHandler(factory(wid))
+		}
+		attached = len(factories)
+	}
+	return wac, attached, nil
+}
+
+// reserve marks the account wid as pending, so no other Conn is created for it until release is called.
+func (m *Manager) reserve(wid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.conns[wid]; ok {
+		return fmt.Errorf("%w: %s", ErrAccountExists, wid)
+	}
+	if _, ok := m.pending[wid]; ok {
+		return fmt.Errorf("%w: %s", ErrAccountExists, wid)
+	}
+	m.pending[wid] = struct{}{}
+	return nil
+}
+
+// release removes the reservation of reserve.
+func (m *Manager) release(wid string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pending, wid)
+}
+
+// add manages wac as the account wid and adds the shared handlers that were not added by newConn.
+func (m *Manager) add(wid string, wac *Conn, attached int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.conns[wid]; ok {
+		return fmt.Errorf("%w: %s", ErrAccountExists, wid)
+	}
+	m.conns[wid] = wac
+	for _, factory := range m.factories[attached:] {
+		wac.AddHandler(factory(wid))
+	}
+	return nil
+}
+
+// Conn returns the Conn of the account wid.
+func (m *Manager) Conn(wid string) (*Conn, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	wac, ok := m.conns[wid]
+	return wac, ok
+}
+
+// Accounts returns the Wids of all managed accounts, sorted.
+func (m *Manager) Accounts() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	wids := make([]string, 0, len(m.conns))
+	for wid := range m.conns {
+		wids = append(wids, wid)
+	}
+	sort.Strings(wids)
+	return wids
+}
+
+// Status returns the status of every account and the number of accounts in each state.
+func (m *Manager) Status() ManagerStatus {
+	status := ManagerStatus{ByState: make(map[ConnState]int)}
+	for _, wid := range m.Accounts() {
+		wac, ok := m.Conn(wid)
+		if !ok {
+			continue
+		}
+		s := AccountStatus{Wid: wid, State: wac.State(), Connected: wac.IsConnected(), LoggedIn: wac.IsLoggedIn()}
+		status.Accounts = append(status.Accounts, s)
+		status.ByState[s.State]++
+	}
+	return status
+}
+
+/*
+Remove disconnects the account wid and stops managing it. The final session is saved to the store, so the account
+can be restored again later.
+*/
+func (m *Manager) Remove(wid string) (Session, error) {
+	m.mu.Lock()
+	wac, ok := m.conns[wid]
+	delete(m.conns, wid)
+	m.mu.Unlock()
+	if !ok {
+		return Session{}, fmt.Errorf("%w: %s", ErrUnknownAccount, wid)
+	}
+
+	session, err := wac.Disconnect()
+	if err != nil && err != ErrNotConnected {
+		return session, err
+	}
+	if session.Wid != "" {
+		if err := m.cfg.Store.Save(session); err != nil {
+			return session, fmt.Errorf("error saving session: %w", err)
+		}
+	}
+	return session, nil
+}
+
+/*
+Shutdown removes all accounts, see Remove. The errors of the accounts that could not be removed cleanly are returned
+by Wid.
+*/
+func (m *Manager) Shutdown() map[string]error {
+	failed := make(map[string]error)
+	for _, wid := range m.Accounts() {
+		if _, err := m.Remove(wid); err != nil {
+			failed[wid] = err
+		}
+	}
+	return failed
+}
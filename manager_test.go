@@ -0,0 +1,153 @@
+package whatsapp_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+func TestManager(t *testing.T) {
+	wids := []string{"4915100000001@c.us", "4915100000002@c.us", "4915100000003@c.us"}
+	servers := make(map[string]*whatsapptest.Server)
+	store := whatsapp.NewMemorySessionStore()
+	for _, wid := range wids {
+		srv := whatsapptest.NewServer()
+		defer srv.Close()
+		srv.SetAccount(whatsapptest.Account{Wid: wid, Pushname: "test", Platform: "android", Battery: 80, Connected: true})
+		servers[wid] = srv
+		_ = store.Save(whatsapp.Session(srv.Pair()))
+	}
+	// the last account is unpaired on the server
+	unpaired := wids[2]
+	servers[unpaired].Pair()
+
+	mgr, err := whatsapp.NewManager(whatsapp.ManagerConfig{
+		Store:   store,
+		Options: []whatsapp.Option{whatsapp.WithTimeout(time.Second)},
+		AccountOptions: func(wid string) []whatsapp.Option {
+			return []whatsapp.Option{whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: servers[wid].URL})}
+		},
+		RestoreConcurrency: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Shutdown()
+
+	var mu sync.Mutex
+	recorders := make(map[string]*recorder)
+	mgr.AddHandler(func(wid string) whatsapp.Handler {
+		mu.Lock()
+		defer mu.Unlock()
+		recorders[wid] = &recorder{}
+		return recorders[wid]
+	})
+
+	failed, err := mgr.RestoreAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || !errors.Is(failed[unpaired], whatsapp.ErrUnpaired) {
+		t.Fatalf("unexpected restore failures %v", failed)
+	}
+	accounts := mgr.Accounts()
+	if len(accounts) != 2 || accounts[0] != wids[0] || accounts[1] != wids[1] {
+		t.Fatalf("unexpected accounts %v", accounts)
+	}
+
+	// the rotated tokens were saved
+	for _, wid := range accounts {
+		saved, _ := store.Load(wid)
+		if creds := servers[wid].Credentials(); saved.ServerToken != creds.ServerToken || saved.ClientToken != creds.ClientToken {
+			t.Errorf("session of %s not saved after restore", wid)
+		}
+	}
+
+	status := mgr.Status()
+	if status.ByState[whatsapp.StateLoggedIn] != 2 || len(status.Accounts) != 2 || !status.Accounts[0].LoggedIn {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// events are routed to the handler of the account
+	wac, _ := mgr.Conn(wids[0])
+	_, _ = wac.Disconnect()
+	mu.Lock()
+	got := recorders[wids[0]].states()
+	mu.Unlock()
+	if len(got) == 0 || got[len(got)-1] != whatsapp.StateDisconnected {
+		t.Errorf("state change not routed to account handler: %v", got)
+	}
+	mu.Lock()
+	other := recorders[wids[1]].states()
+	mu.Unlock()
+	if other[len(other)-1] != whatsapp.StateLoggedIn {
+		t.Errorf("state change of one account reached another: %v", other)
+	}
+
+	if _, err := mgr.Remove(wids[1]); err != nil {
+		t.Fatalf("error removing account: %v", err)
+	}
+	if _, ok := mgr.Conn(wids[1]); ok {
+		t.Error("removed account is still managed")
+	}
+	if _, err := mgr.Remove(wids[1]); !errors.Is(err, whatsapp.ErrUnknownAccount) {
+		t.Errorf("expected ErrUnknownAccount, got %v", err)
+	}
+
+	// a removed account can be restored again from the store
+	if _, err := mgr.RestoreAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	accounts = mgr.Accounts()
+	sort.Strings(accounts)
+	if len(accounts) != 2 || accounts[1] != wids[1] {
+		t.Errorf("account not restored again: %v", accounts)
+	}
+}
+
+func TestManagerRequiresStore(t *testing.T) {
+	if _, err := whatsapp.NewManager(whatsapp.ManagerConfig{}); !errors.Is(err, whatsapp.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestManagerRestoreConcurrently(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	srv.SetAccount(whatsapptest.Account{Wid: "4915100000001@c.us", Pushname: "test", Platform: "android", Battery: 80, Connected: true})
+	session := whatsapp.Session(srv.Pair())
+	srv.SetResponseDelay(50 * time.Millisecond)
+
+	mgr, err := whatsapp.NewManager(whatsapp.ManagerConfig{
+		Store:   whatsapp.NewMemorySessionStore(),
+		Options: []whatsapp.Option{whatsapp.WithTimeout(time.Second), whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL})},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Shutdown()
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := mgr.Restore(context.Background(), session)
+			errs <- err
+		}()
+	}
+	first, second := <-errs, <-errs
+	if first != nil {
+		first, second = second, first
+	}
+	if first != nil || !errors.Is(second, whatsapp.ErrAccountExists) {
+		t.Errorf("expected one restore to fail with ErrAccountExists, got %v and %v", first, second)
+	}
+	if accounts := mgr.Accounts(); len(accounts) != 1 {
+		t.Errorf("unexpected accounts %v", accounts)
+	}
+}
@@ -2,6 +2,7 @@ package whatsapp
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -18,14 +19,23 @@ import (
 	"github.com/cristalinojr/go-whatsapp/crypto/hkdf"
 )
 
+/*
+Download downloads and decrypts media with http.DefaultClient. Use Conn.Download to send the request through the
+proxy and http.Client of a Conn.
+*/
 func Download(url string, mediaKey []byte, appInfo MediaType, fileLength int) ([]byte, error) {
 	if url == "" {
 		return nil, ErrNoURLPresent
 	}
-	file, mac, err := downloadMedia(url)
+	file, mac, err := downloadMedia(context.Background(), http.DefaultClient, url)
 	if err != nil {
 		return nil, err
 	}
+	return decryptMedia(file, mac, mediaKey, appInfo, fileLength)
+}
+
+// decryptMedia validates and decrypts downloaded media.
+func decryptMedia(file, mac, mediaKey []byte, appInfo MediaType, fileLength int) ([]byte, error) {
 	iv, cipherKey, macKey, _, err := getMediaKeys(mediaKey, appInfo)
 	if err != nil {
 		return nil, err
@@ -43,6 +53,57 @@ func Download(url string, mediaKey []byte, appInfo MediaType, fileLength int) ([
 	return data, nil
 }
 
+/*
+Download works like the package level Download, but the request is sent with the http.Client of the Conn, see
+Config.HTTPClient, and the transfer is reported to the Observer of the Conn.
+*/
+func (wac *Conn) Download(url string, mediaKey []byte, appInfo MediaType, fileLength int) ([]byte, error) {
+	return wac.DownloadContext(context.Background(), url, mediaKey, appInfo, fileLength)
+}
+
+// DownloadContext works like Download, but aborts the download as soon as ctx is done.
+func (wac *Conn) DownloadContext(ctx context.Context, url string, mediaKey []byte, appInfo MediaType, fileLength int) (data []byte, err error) {
+	start := time.Now()
+	ctx, span := wac.startSpan(ctx, "whatsapp.Download", Attr("media.type", string(appInfo)))
+	defer func() {
+		wac.observer().MediaTransferred(MediaDownload, appInfo, len(data), time.Since(start), err)
+		endSpan(span, err)
+	}()
+
+	if url == "" {
+		return nil, ErrNoURLPresent
+	}
+	httpCtx, httpSpan := wac.startSpan(ctx, "whatsapp.download.http")
+	file, mac, err := downloadMedia(httpCtx, wac.mediaClient(), url)
+	endSpan(httpSpan, err)
+	if err != nil {
+		return nil, err
+	}
+	return decryptMedia(file, mac, mediaKey, appInfo, fileLength)
+}
+
+/*
+MediaMessage is implemented by the received messages carrying media, ImageMessage, VideoMessage, AudioMessage,
+DocumentMessage and StickerMessage, and by pointers to them.
+*/
+type MediaMessage interface {
+	media() (url string, mediaKey []byte, appInfo MediaType, fileLength int)
+}
+
+/*
+DownloadMedia downloads and decrypts the media of a received message with the http.Client of the Conn. Unlike the
+Download methods of the messages, it respects the Proxy and HTTPClient the Conn was configured with.
+*/
+func (wac *Conn) DownloadMedia(msg MediaMessage) ([]byte, error) {
+	return wac.DownloadMediaContext(context.Background(), msg)
+}
+
+// DownloadMediaContext works like DownloadMedia, but aborts the download as soon as ctx is done.
+func (wac *Conn) DownloadMediaContext(ctx context.Context, msg MediaMessage) ([]byte, error) {
+	url, mediaKey, appInfo, fileLength := msg.media()
+	return wac.DownloadContext(ctx, url, mediaKey, appInfo, fileLength)
+}
+
 func validateMedia(iv []byte, file []byte, macKey []byte, mac []byte) error {
 	h := hmac.New(sha256.New, macKey)
 	n, err := h.Write(append(iv, file...))
@@ -63,14 +124,29 @@ func getMediaKeys(mediaKey []byte, appInfo MediaType) (iv, cipherKey, macKey, re
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
-	return mediaKeyExpanded[:16], mediaKeyExpanded[16:48], mediaKeyExpanded[48:80], mediaKeyExpanded[80:], nil
+	// the keys are capped, so appending to one of them, e.g. to the iv when computing the mac, can not overwrite
+	// the next one
+	return mediaKeyExpanded[:16:16], mediaKeyExpanded[16:48:48], mediaKeyExpanded[48:80:80], mediaKeyExpanded[80:], nil
 }
 
-func downloadMedia(url string) (file []byte, mac []byte, err error) {
-	resp, err := http.Get(url)
+// mediaClient returns the http.Client for media requests.
+func (wac *Conn) mediaClient() *http.Client {
+	if wac.httpClient == nil {
+		return http.DefaultClient
+	}
+	return wac.httpClient
+}
+
+func downloadMedia(ctx context.Context, client *http.Client, url string) (file []byte, mac []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, nil, err
 	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		if resp.StatusCode == 404 {
 			return nil, nil, ErrMediaDownloadFailedWith404
@@ -80,7 +156,6 @@ func downloadMedia(url string) (file []byte, mac []byte, err error) {
 		}
 		return nil, nil, fmt.Errorf("download failed with status code %d", resp.StatusCode)
 	}
-	defer resp.Body.Close()
 	if resp.ContentLength <= 10 {
 		return nil, nil, ErrTooShortFile
 	}
@@ -105,21 +180,22 @@ type MediaConn struct {
 	} `json:"media_conn"`
 }
 
-func (wac *Conn) queryMediaConn() (hostname, auth string, ttl int, err error) {
+func (wac *Conn) queryMediaConn(ctx context.Context) (hostname, auth string, ttl int, err error) {
 	queryReq := []interface{}{"query", "mediaConn"}
-	ch, err := wac.writeJson(queryReq)
+	tag, ch, err := wac.writeJsonRequest(queryReq)
 	if err != nil {
 		return "", "", 0, err
 	}
 
 	var resp MediaConn
-	select {
-	case r := <-ch:
-		if err = json.Unmarshal([]byte(r), &resp); err != nil {
-			return "", "", 0, fmt.Errorf("error decoding query media conn response: %v", err)
-		}
-	case <-time.After(wac.msgTimeout):
+	r, err := wac.awaitResponse(ctx, tag, ch)
+	if err == context.DeadlineExceeded {
 		return "", "", 0, fmt.Errorf("query media conn timed out")
+	} else if err != nil {
+		return "", "", 0, fmt.Errorf("query media conn aborted: %w", err)
+	}
+	if err = json.Unmarshal([]byte(r), &resp); err != nil {
+		return "", "", 0, invalidResponse("query media conn", r, err)
 	}
 
 	if resp.Status != 200 {
@@ -147,6 +223,21 @@ var mediaTypeMap = map[MediaType]string{
 }
 
 func (wac *Conn) Upload(reader io.Reader, appInfo MediaType) (downloadURL string, mediaKey []byte, fileEncSha256 []byte, fileSha256 []byte, fileLength uint64, err error) {
+	return wac.UploadContext(context.Background(), reader, appInfo)
+}
+
+/*
+UploadContext works like Upload. The media connection query and the HTTP upload are aborted when ctx is done. If ctx
+has no deadline, the timeout of the Conn only applies to the media connection query.
+*/
+func (wac *Conn) UploadContext(ctx context.Context, reader io.Reader, appInfo MediaType) (downloadURL string, mediaKey []byte, fileEncSha256 []byte, fileSha256 []byte, fileLength uint64, err error) {
+	start := time.Now()
+	ctx, span := wac.startSpan(ctx, "whatsapp.Upload", Attr("media.type", string(appInfo)))
+	defer func() {
+		wac.observer().MediaTransferred(MediaUpload, appInfo, int(fileLength), time.Since(start), err)
+		endSpan(span, err)
+	}()
+
 	data, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return "", nil, nil, nil, 0, err
@@ -179,7 +270,11 @@ func (wac *Conn) Upload(reader io.Reader, appInfo MediaType) (downloadURL string
 	sha.Write(append(enc, mac...))
 	fileEncSha256 = sha.Sum(nil)
 
-	hostname, auth, _, err := wac.queryMediaConn()
+	queryCtx, cancel := wac.requestContext(ctx)
+	queryCtx, querySpan := wac.startSpan(queryCtx, "whatsapp.queryMediaConn")
+	hostname, auth, _, err := wac.queryMediaConn(queryCtx)
+	endSpan(querySpan, err)
+	cancel()
 	if err != nil {
 		return "", nil, nil, nil, 0, err
 	}
@@ -199,7 +294,7 @@ func (wac *Conn) Upload(reader io.Reader, appInfo MediaType) (downloadURL string
 
 	body := bytes.NewReader(append(enc, mac...))
 
-	req, err := http.NewRequest("POST", uploadURL.String(), body)
+	req, err := http.NewRequestWithContext(ctx, "POST", uploadURL.String(), body)
 	if err != nil {
 		return "", nil, nil, nil, 0, err
 	}
@@ -207,17 +302,20 @@ func (wac *Conn) Upload(reader io.Reader, appInfo MediaType) (downloadURL string
 	req.Header.Set("Origin", "https://web.whatsapp.com")
 	req.Header.Set("Referer", "https://web.whatsapp.com/")
 
-	client := &http.Client{}
 	// Submit the request
-	res, err := client.Do(req)
+	_, httpSpan := wac.startSpan(ctx, "whatsapp.upload.http", Attr("http.host", hostname))
+	res, err := wac.mediaClient().Do(req)
+	if err == nil {
+		defer res.Body.Close()
+	}
+	if err == nil && res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("upload failed with status code %d", res.StatusCode)
+	}
+	endSpan(httpSpan, err)
 	if err != nil {
 		return "", nil, nil, nil, 0, err
 	}
 
-	if res.StatusCode != http.StatusOK {
-		return "", nil, nil, nil, 0, fmt.Errorf("upload failed with status code %d", res.StatusCode)
-	}
-
 	var jsonRes map[string]string
 	json.NewDecoder(res.Body).Decode(&jsonRes)
 
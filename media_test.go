@@ -0,0 +1,119 @@
+package whatsapp_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/binary/proto"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+type countingTransport struct {
+	next  http.RoundTripper
+	mu    sync.Mutex
+	paths []string
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.paths = append(c.paths, req.Method+" "+req.URL.Path)
+	c.mu.Unlock()
+	return c.next.RoundTrip(req)
+}
+
+func TestMediaUsesConnHTTPClient(t *testing.T) {
+	var mu sync.Mutex
+	files := map[string][]byte{"/pp/4915100000000.jpg": []byte("profile picture")}
+	media := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			files["/d"+r.URL.Path] = body
+			fmt.Fprintf(w, `{"url":"https://%s/d%s"}`, r.Host, r.URL.Path)
+			return
+		}
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer media.Close()
+	mediaURL, _ := url.Parse(media.URL)
+
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	srv.HandleJSON("query", "mediaConn", func(args []interface{}) interface{} {
+		return map[string]interface{}{"status": 200, "media_conn": map[string]interface{}{
+			"auth": "auth", "ttl": 3600, "hosts": []map[string]interface{}{{"hostname": mediaURL.Host}},
+		}}
+	})
+	srv.HandleJSON("query", "ProfilePicThumb", func(args []interface{}) interface{} {
+		return map[string]interface{}{"eurl": media.URL + "/pp/4915100000000.jpg", "tag": "1"}
+	})
+
+	// the media server is only trusted by its own client, requests around the Conn's client fail
+	transport := &countingTransport{next: media.Client().Transport}
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithHTTPClient(&http.Client{Transport: transport}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+
+	content := []byte("an image that is long enough")
+	downloadURL, mediaKey, _, _, length, err := wac.Upload(bytes.NewReader(content), whatsapp.MediaImage)
+	if err != nil {
+		t.Fatalf("error uploading: %v", err)
+	}
+	data, err := wac.Download(downloadURL, mediaKey, whatsapp.MediaImage, int(length))
+	if err != nil {
+		t.Fatalf("error downloading: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("downloaded %q, uploaded %q", data, content)
+	}
+	received := whatsapp.ParseProtoMessage(&proto.WebMessageInfo{Message: &proto.Message{ImageMessage: &proto.ImageMessage{
+		URL: &downloadURL, MediaKey: mediaKey, FileLength: &length,
+	}}}).(whatsapp.ImageMessage)
+	if data, err := wac.DownloadMedia(received); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("error downloading received media: %q, %v", data, err)
+	}
+	pic, err := wac.DownloadProfilePicContext(context.Background(), "4915100000000@s.whatsapp.net")
+	if err != nil {
+		t.Fatalf("error downloading profile picture: %v", err)
+	}
+	if string(pic) != "profile picture" {
+		t.Errorf("unexpected profile picture %q", pic)
+	}
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	if len(transport.paths) != 4 {
+		t.Errorf("expected upload, downloads and profile picture to use the client, got %v", transport.paths)
+	}
+	if _, err := whatsapp.Download(downloadURL, mediaKey, whatsapp.MediaImage, int(length)); err == nil {
+		t.Error("expected the package level Download to bypass the client and fail")
+	}
+	if _, err := received.Download(); err == nil {
+		t.Error("expected the Download of the message to bypass the client and fail")
+	}
+}
@@ -1,8 +1,10 @@
 package whatsapp
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -24,94 +26,165 @@ const (
 )
 
 func (wac *Conn) SendRaw(msg *proto.WebMessageInfo, output chan<- error) {
-	ch, err := wac.sendProto(msg)
-	if err != nil {
-		output <- fmt.Errorf("could not send proto: %w", err)
-		return
-	}
-	response := <-ch
-	resp := StatusResponse{RequestType: "message sending"}
-	if err = json.Unmarshal([]byte(response), &resp); err != nil {
-		output <- fmt.Errorf("error decoding sending response: %w", err)
-	} else if resp.Status != 200 {
-		output <- resp
-	} else {
-		output <- nil
-	}
+	wac.SendRawContext(context.Background(), msg, output)
+}
+
+/*
+SendRawContext works like SendRaw, but waits for the server acknowledgement at most until ctx is done. If ctx has no
+deadline, the timeout of the Conn is applied.
+*/
+func (wac *Conn) SendRawContext(ctx context.Context, msg *proto.WebMessageInfo, output chan<- error) {
+	output <- wac.relay(ctx, msg)
 }
 
 func (wac *Conn) Send(msg interface{}) (string, error) {
+	return wac.SendContext(context.Background(), msg)
+}
+
+/*
+SendContext works like Send. The media upload and the wait for the server acknowledgement are aborted when ctx is
+done. If ctx has no deadline, the timeout of the Conn is applied to the acknowledgement.
+
+If an Outbox is configured and the connection is unavailable, the message is stored instead. SendContext then returns
+the message ID together with ErrMessageQueued and the result is reported to OutboxHandlers once it was replayed. While
+stored messages are replayed, SendContext waits for the replay, messages that are still stored afterwards are never
+overtaken, the message is stored behind them.
+*/
+func (wac *Conn) SendContext(ctx context.Context, msg interface{}) (id string, err error) {
+	ctx, span := wac.startSpan(ctx, "whatsapp.Send", Attr("message.type", fmt.Sprintf("%T", msg)))
+	defer func() {
+		span.SetAttributes(Attr("message.id", id))
+		if errors.Is(err, ErrMessageQueued) {
+			// queueing is the expected outcome while offline, not a failure of the span
+			span.SetAttributes(Attr("message.queued", true))
+			endSpan(span, nil)
+			return
+		}
+		endSpan(span, err)
+	}()
+
+	if wac.closing.Load() {
+		return "ERROR", ErrClosed
+	}
+	if err := wac.phoneOffline(); err != nil {
+		return "ERROR", err
+	}
+
 	var msgProto *proto.WebMessageInfo
 
 	switch m := msg.(type) {
 	case *proto.WebMessageInfo:
 		msgProto = m
 	case TextMessage:
-		msgProto = getTextProto(m)
+		msgProto = getTextProto(m, wac.ServerTime())
 	case ImageMessage:
 		var err error
-		m.url, m.mediaKey, m.fileEncSha256, m.fileSha256, m.fileLength, err = wac.Upload(m.Content, MediaImage)
+		m.url, m.mediaKey, m.fileEncSha256, m.fileSha256, m.fileLength, err = wac.UploadContext(ctx, m.Content, MediaImage)
 		if err != nil {
 			return "ERROR", fmt.Errorf("image upload failed: %v", err)
 		}
-		msgProto = getImageProto(m)
+		msgProto = getImageProto(m, wac.ServerTime())
 	case VideoMessage:
 		var err error
-		m.url, m.mediaKey, m.fileEncSha256, m.fileSha256, m.fileLength, err = wac.Upload(m.Content, MediaVideo)
+		m.url, m.mediaKey, m.fileEncSha256, m.fileSha256, m.fileLength, err = wac.UploadContext(ctx, m.Content, MediaVideo)
 		if err != nil {
 			return "ERROR", fmt.Errorf("video upload failed: %v", err)
 		}
-		msgProto = getVideoProto(m)
+		msgProto = getVideoProto(m, wac.ServerTime())
 	case DocumentMessage:
 		var err error
-		m.url, m.mediaKey, m.fileEncSha256, m.fileSha256, m.fileLength, err = wac.Upload(m.Content, MediaDocument)
+		m.url, m.mediaKey, m.fileEncSha256, m.fileSha256, m.fileLength, err = wac.UploadContext(ctx, m.Content, MediaDocument)
 		if err != nil {
 			return "ERROR", fmt.Errorf("document upload failed: %v", err)
 		}
-		msgProto = getDocumentProto(m)
+		msgProto = getDocumentProto(m, wac.ServerTime())
 	case AudioMessage:
 		var err error
-		m.url, m.mediaKey, m.fileEncSha256, m.fileSha256, m.fileLength, err = wac.Upload(m.Content, MediaAudio)
+		m.url, m.mediaKey, m.fileEncSha256, m.fileSha256, m.fileLength, err = wac.UploadContext(ctx, m.Content, MediaAudio)
 		if err != nil {
 			return "ERROR", fmt.Errorf("audio upload failed: %v", err)
 		}
-		msgProto = getAudioProto(m)
+		msgProto = getAudioProto(m, wac.ServerTime())
 	case LocationMessage:
-		msgProto = GetLocationProto(m)
+		msgProto = getLocationProto(m, wac.ServerTime())
 	case LiveLocationMessage:
-		msgProto = GetLiveLocationProto(m)
+		msgProto = getLiveLocationProto(m, wac.ServerTime())
 	case ContactMessage:
-		msgProto = getContactMessageProto(m)
+		msgProto = getContactMessageProto(m, wac.ServerTime())
 	default:
 		return "ERROR", fmt.Errorf("cannot match type %T, use message types declared in the package", msg)
 	}
 	status := proto.WebMessageInfo_PENDING
 	msgProto.Status = &status
-	ch, err := wac.sendProto(msgProto)
+	span.SetAttributes(Attr("jid", msgProto.GetKey().GetRemoteJID()))
+
+	if wac.outbox != nil && (!wac.connected.Load() || !wac.loggedIn.Load() || wac.queueBehindOutbox(msgProto)) {
+		return getMessageInfo(msgProto).Id, wac.queueOffline(msgProto)
+	}
+	if err := wac.relay(ctx, msgProto); err != nil {
+		if wac.outbox != nil && isConnectionError(err) {
+			return getMessageInfo(msgProto).Id, wac.queueOffline(msgProto)
+		}
+		return "ERROR", err
+	}
+	return getMessageInfo(msgProto).Id, nil
+}
+
+// relay sends msgProto and waits for the server to acknowledge it.
+func (wac *Conn) relay(ctx context.Context, msgProto *proto.WebMessageInfo) (err error) {
+	start := time.Now()
+	ctx, span := wac.startSpan(ctx, "whatsapp.relay", Attr("jid", msgProto.GetKey().GetRemoteJID()), Attr("message.id", msgProto.GetKey().GetId()))
+	defer func() {
+		wac.observer().MessageSent(time.Since(start), err)
+		endSpan(span, err)
+	}()
+
+	wac.logger().Debug("relaying message", "jid", msgProto.GetKey().GetRemoteJID(), "id", msgProto.GetKey().GetId())
+	ch, err := wac.sendProto(ctx, msgProto)
 	if err != nil {
-		return "ERROR", fmt.Errorf("could not send proto: %v", err)
+		return fmt.Errorf("could not send proto: %w", err)
 	}
 
-	select {
-	case response := <-ch:
-		resp := StatusResponse{RequestType: "message sending"}
-		if err = json.Unmarshal([]byte(response), &resp); err != nil {
-			return "ERROR", fmt.Errorf("error decoding sending response: %v\n", err)
-		} else if resp.Status != 200 {
-			return "ERROR", resp
-		}
-		return getMessageInfo(msgProto).Id, nil
-	case <-time.After(wac.msgTimeout):
-		return "ERROR", fmt.Errorf("sending message timed out")
+	ackCtx, cancel := wac.requestContext(ctx)
+	defer cancel()
+	_, ackSpan := wac.startSpan(ackCtx, "whatsapp.ack")
+	response, err := wac.awaitResponse(ackCtx, msgProto.GetKey().GetId(), ch)
+	endSpan(ackSpan, err)
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf("sending message timed out: %w", ErrConnectionTimeout)
+	} else if err != nil {
+		return fmt.Errorf("sending message aborted: %w", err)
+	}
+
+	resp := StatusResponse{RequestType: "message sending"}
+	if err = json.Unmarshal([]byte(response), &resp); err != nil {
+		return invalidResponse(resp.RequestType, response, err)
+	} else if resp.Status != 200 {
+		wac.logger().Warn("message rejected", "jid", msgProto.GetKey().GetRemoteJID(), "id", msgProto.GetKey().GetId(), "status", resp.Status)
+		return resp
 	}
+	return nil
 }
 
-func (wac *Conn) sendProto(p *proto.WebMessageInfo) (<-chan string, error) {
+/*
+sendProto relays p. If a rate limit is configured, the message first waits in the outbound queue with the priority
+carried by ctx.
+*/
+func (wac *Conn) sendProto(ctx context.Context, p *proto.WebMessageInfo) (<-chan string, error) {
+	if wac.outbound != nil {
+		_, span := wac.startSpan(ctx, "whatsapp.queue", Attr("priority", int(priorityFromContext(ctx))))
+		err := wac.outbound.acquire(ctx, p.GetKey().GetRemoteJID(), priorityFromContext(ctx))
+		endSpan(span, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	n := binary.Node{
 		Description: "action",
 		Attributes: map[string]string{
 			"type":  "relay",
-			"epoch": strconv.Itoa(wac.msgCount),
+			"epoch": strconv.Itoa(wac.messageCount()),
 		},
 		Content: []interface{}{p},
 	}
@@ -157,27 +230,37 @@ func (wac *Conn) RevokeMessage(remotejid, msgid string, fromme bool) (revokeid s
 // DeleteMessage deletes a single message for the user (removes the msgbox). To
 // delete the message for everyone, use RevokeMessage
 func (wac *Conn) DeleteMessage(remotejid, msgid string, fromMe bool) error {
-	ch, err := wac.deleteChatProto(remotejid, msgid, fromMe)
+	return wac.DeleteMessageContext(context.Background(), remotejid, msgid, fromMe)
+}
+
+// DeleteMessageContext works like DeleteMessage, but waits for the server response at most until ctx is done.
+func (wac *Conn) DeleteMessageContext(ctx context.Context, remotejid, msgid string, fromMe bool) error {
+	ctx, cancel := wac.requestContext(ctx)
+	defer cancel()
+
+	tag := fmt.Sprintf("%s.--%d", wac.getTimeTag(), wac.messageCount())
+	ch, err := wac.deleteChatProto(tag, remotejid, msgid, fromMe)
 	if err != nil {
 		return fmt.Errorf("could not send proto: %v", err)
 	}
 
-	select {
-	case response := <-ch:
-		resp := StatusResponse{RequestType: "message deletion"}
-		if err = json.Unmarshal([]byte(response), &resp); err != nil {
-			return fmt.Errorf("error decoding deletion response: %v", err)
-		} else if resp.Status != 200 {
-			return resp
-		}
-		return nil
-	case <-time.After(wac.msgTimeout):
+	response, err := wac.awaitResponse(ctx, tag, ch)
+	if err == context.DeadlineExceeded {
 		return fmt.Errorf("deleting message timed out")
+	} else if err != nil {
+		return fmt.Errorf("deleting message aborted: %w", err)
+	}
+
+	resp := StatusResponse{RequestType: "message deletion"}
+	if err = json.Unmarshal([]byte(response), &resp); err != nil {
+		return invalidResponse(resp.RequestType, response, err)
+	} else if resp.Status != 200 {
+		return resp
 	}
+	return nil
 }
 
-func (wac *Conn) deleteChatProto(remotejid, msgid string, fromMe bool) (<-chan string, error) {
-	tag := fmt.Sprintf("%s.--%d", wac.timeTag, wac.msgCount)
+func (wac *Conn) deleteChatProto(tag, remotejid, msgid string, fromMe bool) (<-chan string, error) {
 
 	owner := "true"
 	if !fromMe {
@@ -186,7 +269,7 @@ func (wac *Conn) deleteChatProto(remotejid, msgid string, fromMe bool) (<-chan s
 	n := binary.Node{
 		Description: "action",
 		Attributes: map[string]string{
-			"epoch": strconv.Itoa(wac.msgCount),
+			"epoch": strconv.Itoa(wac.messageCount()),
 			"type":  "set",
 		},
 		Content: []interface{}{
@@ -255,14 +338,18 @@ func getMessageInfo(msg *proto.WebMessageInfo) MessageInfo {
 	}
 }
 
-func getInfoProto(info *MessageInfo) *proto.WebMessageInfo {
+/*
+getInfoProto builds the proto of info. A missing id and timestamp are generated, the timestamp from now, which is the
+skew corrected server time when sending.
+*/
+func getInfoProto(info *MessageInfo, now time.Time) *proto.WebMessageInfo {
 	if info.Id == "" || len(info.Id) < 2 {
 		b := make([]byte, 10)
 		rand.Read(b)
 		info.Id = strings.ToUpper(hex.EncodeToString(b))
 	}
 	if info.Timestamp == 0 {
-		info.Timestamp = uint64(time.Now().Unix())
+		info.Timestamp = uint64(now.Unix())
 	}
 	info.FromMe = true
 
@@ -340,8 +427,8 @@ func getTextMessage(msg *proto.WebMessageInfo) TextMessage {
 	return text
 }
 
-func getTextProto(msg TextMessage) *proto.WebMessageInfo {
-	p := getInfoProto(&msg.Info)
+func getTextProto(msg TextMessage, now time.Time) *proto.WebMessageInfo {
+	p := getInfoProto(&msg.Info, now)
 	contextInfo := getContextInfoProto(&msg.ContextInfo)
 
 	if contextInfo == nil {
@@ -397,8 +484,8 @@ func getImageMessage(msg *proto.WebMessageInfo) ImageMessage {
 	return imageMessage
 }
 
-func getImageProto(msg ImageMessage) *proto.WebMessageInfo {
-	p := getInfoProto(&msg.Info)
+func getImageProto(msg ImageMessage, now time.Time) *proto.WebMessageInfo {
+	p := getInfoProto(&msg.Info, now)
 	contextInfo := getContextInfoProto(&msg.ContextInfo)
 
 	p.Message = &proto.Message{
@@ -418,10 +505,15 @@ func getImageProto(msg ImageMessage) *proto.WebMessageInfo {
 }
 
 /*
-Download is the function to retrieve media data. The media gets downloaded, validated and returned.
+Download is the function to retrieve media data. The media gets downloaded, validated and returned. It uses
+http.DefaultClient, use Conn.DownloadMedia to download through the proxy and http.Client of the Conn.
 */
 func (m *ImageMessage) Download() ([]byte, error) {
-	return Download(m.url, m.mediaKey, MediaImage, int(m.fileLength))
+	return Download(m.media())
+}
+
+func (m ImageMessage) media() (string, []byte, MediaType, int) {
+	return m.url, m.mediaKey, MediaImage, int(m.fileLength)
 }
 
 /*
@@ -465,8 +557,8 @@ func getVideoMessage(msg *proto.WebMessageInfo) VideoMessage {
 	return videoMessage
 }
 
-func getVideoProto(msg VideoMessage) *proto.WebMessageInfo {
-	p := getInfoProto(&msg.Info)
+func getVideoProto(msg VideoMessage, now time.Time) *proto.WebMessageInfo {
+	p := getInfoProto(&msg.Info, now)
 	contextInfo := getContextInfoProto(&msg.ContextInfo)
 
 	p.Message = &proto.Message{
@@ -488,10 +580,15 @@ func getVideoProto(msg VideoMessage) *proto.WebMessageInfo {
 }
 
 /*
-Download is the function to retrieve media data. The media gets downloaded, validated and returned.
+Download is the function to retrieve media data. The media gets downloaded, validated and returned. It uses
+http.DefaultClient, use Conn.DownloadMedia to download through the proxy and http.Client of the Conn.
 */
 func (m *VideoMessage) Download() ([]byte, error) {
-	return Download(m.url, m.mediaKey, MediaVideo, int(m.fileLength))
+	return Download(m.media())
+}
+
+func (m VideoMessage) media() (string, []byte, MediaType, int) {
+	return m.url, m.mediaKey, MediaVideo, int(m.fileLength)
 }
 
 /*
@@ -530,8 +627,8 @@ func getAudioMessage(msg *proto.WebMessageInfo) AudioMessage {
 	return audioMessage
 }
 
-func getAudioProto(msg AudioMessage) *proto.WebMessageInfo {
-	p := getInfoProto(&msg.Info)
+func getAudioProto(msg AudioMessage, now time.Time) *proto.WebMessageInfo {
+	p := getInfoProto(&msg.Info, now)
 	contextInfo := getContextInfoProto(&msg.ContextInfo)
 	p.Message = &proto.Message{
 		AudioMessage: &proto.AudioMessage{
@@ -550,10 +647,15 @@ func getAudioProto(msg AudioMessage) *proto.WebMessageInfo {
 }
 
 /*
-Download is the function to retrieve media data. The media gets downloaded, validated and returned.
+Download is the function to retrieve media data. The media gets downloaded, validated and returned. It uses
+http.DefaultClient, use Conn.DownloadMedia to download through the proxy and http.Client of the Conn.
 */
 func (m *AudioMessage) Download() ([]byte, error) {
-	return Download(m.url, m.mediaKey, MediaAudio, int(m.fileLength))
+	return Download(m.media())
+}
+
+func (m AudioMessage) media() (string, []byte, MediaType, int) {
+	return m.url, m.mediaKey, MediaAudio, int(m.fileLength)
 }
 
 /*
@@ -597,8 +699,8 @@ func getDocumentMessage(msg *proto.WebMessageInfo) DocumentMessage {
 	return documentMessage
 }
 
-func getDocumentProto(msg DocumentMessage) *proto.WebMessageInfo {
-	p := getInfoProto(&msg.Info)
+func getDocumentProto(msg DocumentMessage, now time.Time) *proto.WebMessageInfo {
+	p := getInfoProto(&msg.Info, now)
 	contextInfo := getContextInfoProto(&msg.ContextInfo)
 	p.Message = &proto.Message{
 		DocumentMessage: &proto.DocumentMessage{
@@ -618,10 +720,15 @@ func getDocumentProto(msg DocumentMessage) *proto.WebMessageInfo {
 }
 
 /*
-Download is the function to retrieve media data. The media gets downloaded, validated and returned.
+Download is the function to retrieve media data. The media gets downloaded, validated and returned. It uses
+http.DefaultClient, use Conn.DownloadMedia to download through the proxy and http.Client of the Conn.
 */
 func (m *DocumentMessage) Download() ([]byte, error) {
-	return Download(m.url, m.mediaKey, MediaDocument, int(m.fileLength))
+	return Download(m.media())
+}
+
+func (m DocumentMessage) media() (string, []byte, MediaType, int) {
+	return m.url, m.mediaKey, MediaDocument, int(m.fileLength)
 }
 
 /*
@@ -656,7 +763,11 @@ func GetLocationMessage(msg *proto.WebMessageInfo) LocationMessage {
 }
 
 func GetLocationProto(msg LocationMessage) *proto.WebMessageInfo {
-	p := getInfoProto(&msg.Info)
+	return getLocationProto(msg, time.Now())
+}
+
+func getLocationProto(msg LocationMessage, now time.Time) *proto.WebMessageInfo {
+	p := getInfoProto(&msg.Info, now)
 	contextInfo := getContextInfoProto(&msg.ContextInfo)
 
 	p.Message = &proto.Message{
@@ -709,7 +820,11 @@ func GetLiveLocationMessage(msg *proto.WebMessageInfo) LiveLocationMessage {
 }
 
 func GetLiveLocationProto(msg LiveLocationMessage) *proto.WebMessageInfo {
-	p := getInfoProto(&msg.Info)
+	return getLiveLocationProto(msg, time.Now())
+}
+
+func getLiveLocationProto(msg LiveLocationMessage, now time.Time) *proto.WebMessageInfo {
+	p := getInfoProto(&msg.Info, now)
 	contextInfo := getContextInfoProto(&msg.ContextInfo)
 	p.Message = &proto.Message{
 		LiveLocationMessage: &proto.LiveLocationMessage{
@@ -762,11 +877,15 @@ func getStickerMessage(msg *proto.WebMessageInfo) StickerMessage {
 }
 
 /*
-Download is the function to retrieve Sticker media data. The media gets downloaded, validated and returned.
+Download is the function to retrieve Sticker media data. The media gets downloaded, validated and returned. It uses
+http.DefaultClient, use Conn.DownloadMedia to download through the proxy and http.Client of the Conn.
 */
-
 func (m *StickerMessage) Download() ([]byte, error) {
-	return Download(m.url, m.mediaKey, MediaImage, int(m.fileLength))
+	return Download(m.media())
+}
+
+func (m StickerMessage) media() (string, []byte, MediaType, int) {
+	return m.url, m.mediaKey, MediaImage, int(m.fileLength)
 }
 
 /*
@@ -796,8 +915,8 @@ func getContactMessage(msg *proto.WebMessageInfo) ContactMessage {
 	return contactMessage
 }
 
-func getContactMessageProto(msg ContactMessage) *proto.WebMessageInfo {
-	p := getInfoProto(&msg.Info)
+func getContactMessageProto(msg ContactMessage, now time.Time) *proto.WebMessageInfo {
+	p := getInfoProto(&msg.Info, now)
 	contextInfo := getContextInfoProto(&msg.ContextInfo)
 
 	p.Message = &proto.Message{
@@ -0,0 +1,340 @@
+package whatsapp
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the latency histograms in seconds.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Histogram is a snapshot of a latency histogram. Counts[i] is the number of observations up to Buckets[i] seconds.
+type Histogram struct {
+	Buckets []float64
+	Counts  []uint64
+	Count   uint64
+	Sum     float64
+}
+
+func newHistogram() Histogram {
+	return Histogram{Buckets: latencyBuckets, Counts: make([]uint64, len(latencyBuckets))}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	for i, upper := range h.Buckets {
+		if s <= upper {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += s
+}
+
+func (h Histogram) clone() Histogram {
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// MediaStats counts the transfers in one direction.
+type MediaStats struct {
+	Transfers uint64
+	Errors    uint64
+	Bytes     uint64
+	Latency   Histogram
+}
+
+// MetricsSnapshot is a copy of the values collected by Metrics.
+type MetricsSnapshot struct {
+	JSONFramesSent       uint64
+	BinaryFramesSent     uint64
+	BytesSent            uint64
+	JSONFramesReceived   uint64
+	BinaryFramesReceived uint64
+	BytesReceived        uint64
+	DecodeErrors         uint64
+
+	MessagesSent   uint64
+	MessagesFailed uint64
+	SendLatency    Histogram
+
+	KeepAlives        uint64
+	KeepAliveFailures uint64
+	KeepAliveRTT      Histogram
+
+	Uploads   MediaStats
+	Downloads MediaStats
+
+	// PendingRequests is the number of requests waiting for a response, summed up over the Conns.
+	PendingRequests int
+}
+
+/*
+Metrics is an in-memory Observer. One Metrics may be shared by several Conns, it then sums up their traffic. Metrics
+is an http.Handler serving the collected values in the Prometheus text format, so it can be mounted on /metrics
+without any further dependency.
+*/
+type Metrics struct {
+	labels string
+
+	mu sync.Mutex
+	s  MetricsSnapshot
+}
+
+/*
+NewMetrics creates an empty Metrics. The labels are added to every exported sample, e.g. to tell apart the Metrics of
+several accounts served by one handler with PrometheusHandler.
+*/
+func NewMetrics(labels map[string]string) *Metrics {
+	m := &Metrics{labels: formatLabels(labels)}
+	m.s.SendLatency = newHistogram()
+	m.s.KeepAliveRTT = newHistogram()
+	m.s.Uploads.Latency = newHistogram()
+	m.s.Downloads.Latency = newHistogram()
+	return m
+}
+
+func (m *Metrics) FrameSent(binary bool, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if binary {
+		m.s.BinaryFramesSent++
+	} else {
+		m.s.JSONFramesSent++
+	}
+	m.s.BytesSent += uint64(size)
+}
+
+func (m *Metrics) FrameReceived(binary bool, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if binary {
+		m.s.BinaryFramesReceived++
+	} else {
+		m.s.JSONFramesReceived++
+	}
+	m.s.BytesReceived += uint64(size)
+}
+
+func (m *Metrics) DecodeError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.s.DecodeErrors++
+}
+
+func (m *Metrics) MessageSent(latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.s.MessagesFailed++
+		return
+	}
+	m.s.MessagesSent++
+	m.s.SendLatency.observe(latency)
+}
+
+func (m *Metrics) MediaTransferred(direction MediaDirection, mediaType MediaType, size int, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := &m.s.Uploads
+	if direction == MediaDownload {
+		stats = &m.s.Downloads
+	}
+	stats.Transfers++
+	if err != nil {
+		stats.Errors++
+		return
+	}
+	stats.Bytes += uint64(size)
+	stats.Latency.observe(latency)
+}
+
+func (m *Metrics) KeepAlive(rtt time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.s.KeepAlives++
+	if err != nil {
+		m.s.KeepAliveFailures++
+		return
+	}
+	m.s.KeepAliveRTT.observe(rtt)
+}
+
+func (m *Metrics) PendingRequestsChanged(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.s.PendingRequests += delta
+}
+
+// Snapshot returns a copy of the collected values.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.s
+	s.SendLatency = s.SendLatency.clone()
+	s.KeepAliveRTT = s.KeepAliveRTT.clone()
+	s.Uploads.Latency = s.Uploads.Latency.clone()
+	s.Downloads.Latency = s.Downloads.Latency.clone()
+	return s
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	PrometheusHandler(m).ServeHTTP(w, r)
+}
+
+/*
+PrometheusHandler serves the values of all given Metrics in the Prometheus text format. The Metrics should carry
+distinct labels, see NewMetrics.
+*/
+func PrometheusHandler(metrics ...*Metrics) http.Handler {
+	return PrometheusHandlerFunc(func() []*Metrics { return metrics })
+}
+
+/*
+PrometheusHandlerFunc works like PrometheusHandler, but asks provider for the Metrics on every request, so Metrics
+created later, e.g. in the AccountOptions of a Manager for accounts added at runtime, are served as well.
+*/
+func PrometheusHandlerFunc(provider func() []*Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		writePrometheus(bw, provider())
+		_ = bw.Flush()
+	})
+}
+
+type promSample struct {
+	labels string
+	value  float64
+}
+
+type promWriter struct {
+	w       *bufio.Writer
+	metrics []*Metrics
+	snaps   []MetricsSnapshot
+}
+
+func writePrometheus(w *bufio.Writer, metrics []*Metrics) {
+	p := promWriter{w: w, metrics: metrics}
+	for _, m := range metrics {
+		p.snaps = append(p.snaps, m.Snapshot())
+	}
+
+	p.family("whatsapp_frames_sent_total", "counter", "Frames written to the websocket.", func(s MetricsSnapshot) []promSample {
+		return []promSample{{`type="json"`, float64(s.JSONFramesSent)}, {`type="binary"`, float64(s.BinaryFramesSent)}}
+	})
+	p.family("whatsapp_frames_received_total", "counter", "Frames read from the websocket.", func(s MetricsSnapshot) []promSample {
+		return []promSample{{`type="json"`, float64(s.JSONFramesReceived)}, {`type="binary"`, float64(s.BinaryFramesReceived)}}
+	})
+	p.family("whatsapp_sent_bytes_total", "counter", "Bytes written to the websocket.", func(s MetricsSnapshot) []promSample {
+		return []promSample{{"", float64(s.BytesSent)}}
+	})
+	p.family("whatsapp_received_bytes_total", "counter", "Bytes read from the websocket.", func(s MetricsSnapshot) []promSample {
+		return []promSample{{"", float64(s.BytesReceived)}}
+	})
+	p.family("whatsapp_decode_errors_total", "counter", "Received frames that could not be decoded.", func(s MetricsSnapshot) []promSample {
+		return []promSample{{"", float64(s.DecodeErrors)}}
+	})
+	p.family("whatsapp_messages_sent_total", "counter", "Relayed messages by result.", func(s MetricsSnapshot) []promSample {
+		return []promSample{{`result="ok"`, float64(s.MessagesSent)}, {`result="error"`, float64(s.MessagesFailed)}}
+	})
+	p.histogram("whatsapp_send_latency_seconds", "Time from relaying a message until the server acknowledged it.", func(s MetricsSnapshot) []labeledHistogram {
+		return []labeledHistogram{{"", s.SendLatency}}
+	})
+	p.family("whatsapp_keepalives_total", "counter", "Keep-alives by result.", func(s MetricsSnapshot) []promSample {
+		return []promSample{{`result="ok"`, float64(s.KeepAlives - s.KeepAliveFailures)}, {`result="error"`, float64(s.KeepAliveFailures)}}
+	})
+	p.histogram("whatsapp_keepalive_rtt_seconds", "Round trip time of the keep-alives.", func(s MetricsSnapshot) []labeledHistogram {
+		return []labeledHistogram{{"", s.KeepAliveRTT}}
+	})
+	p.family("whatsapp_media_transfers_total", "counter", "Media uploads and downloads by result.", func(s MetricsSnapshot) []promSample {
+		return []promSample{
+			{`direction="upload",result="ok"`, float64(s.Uploads.Transfers - s.Uploads.Errors)},
+			{`direction="upload",result="error"`, float64(s.Uploads.Errors)},
+			{`direction="download",result="ok"`, float64(s.Downloads.Transfers - s.Downloads.Errors)},
+			{`direction="download",result="error"`, float64(s.Downloads.Errors)},
+		}
+	})
+	p.family("whatsapp_media_bytes_total", "counter", "Size of the transferred media.", func(s MetricsSnapshot) []promSample {
+		return []promSample{{`direction="upload"`, float64(s.Uploads.Bytes)}, {`direction="download"`, float64(s.Downloads.Bytes)}}
+	})
+	p.histogram("whatsapp_media_latency_seconds", "Duration of media uploads and downloads.", func(s MetricsSnapshot) []labeledHistogram {
+		return []labeledHistogram{{`direction="upload"`, s.Uploads.Latency}, {`direction="download"`, s.Downloads.Latency}}
+	})
+	p.family("whatsapp_pending_requests", "gauge", "Requests waiting for a response.", func(s MetricsSnapshot) []promSample {
+		return []promSample{{"", float64(s.PendingRequests)}}
+	})
+}
+
+func (p *promWriter) header(name, typ, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (p *promWriter) family(name, typ, help string, samples func(MetricsSnapshot) []promSample) {
+	p.header(name, typ, help)
+	for i, snap := range p.snaps {
+		for _, sample := range samples(snap) {
+			fmt.Fprintf(p.w, "%s%s %s\n", name, joinLabels(p.metrics[i].labels, sample.labels), formatFloat(sample.value))
+		}
+	}
+}
+
+type labeledHistogram struct {
+	labels string
+	h      Histogram
+}
+
+func (p *promWriter) histogram(name, help string, histograms func(MetricsSnapshot) []labeledHistogram) {
+	p.header(name, "histogram", help)
+	for i, snap := range p.snaps {
+		for _, lh := range histograms(snap) {
+			labels := joinLabels(p.metrics[i].labels, lh.labels)
+			inner := strings.TrimSuffix(strings.TrimPrefix(labels, "{"), "}")
+			if inner != "" {
+				inner += ","
+			}
+			for b, upper := range lh.h.Buckets {
+				fmt.Fprintf(p.w, "%s_bucket{%sle=\"%s\"} %d\n", name, inner, formatFloat(upper), lh.h.Counts[b])
+			}
+			fmt.Fprintf(p.w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, inner, lh.h.Count)
+			fmt.Fprintf(p.w, "%s_sum%s %s\n", name, labels, formatFloat(lh.h.Sum))
+			fmt.Fprintf(p.w, "%s_count%s %d\n", name, labels, lh.h.Count)
+		}
+	}
+}
+
+// formatLabels renders labels sorted by name, without braces.
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + strconv.Quote(labels[name])
+	}
+	return strings.Join(parts, ",")
+}
+
+func joinLabels(a, b string) string {
+	switch {
+	case a == "" && b == "":
+		return ""
+	case a == "":
+		return "{" + b + "}"
+	case b == "":
+		return "{" + a + "}"
+	}
+	return "{" + a + "," + b + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,108 @@
+package whatsapp
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsCollects(t *testing.T) {
+	m := NewMetrics(nil)
+	m.FrameSent(true, 100)
+	m.FrameSent(false, 10)
+	m.FrameReceived(true, 50)
+	m.DecodeError(ErrInvalidWsData)
+	m.MessageSent(30*time.Millisecond, nil)
+	m.MessageSent(time.Second, errors.New("failed"))
+	m.MediaTransferred(MediaDownload, MediaImage, 1000, 200*time.Millisecond, nil)
+	m.KeepAlive(20*time.Millisecond, nil)
+	m.KeepAlive(0, ErrConnectionTimeout)
+	m.PendingRequestsChanged(3)
+
+	s := m.Snapshot()
+	switch {
+	case s.BinaryFramesSent != 1 || s.JSONFramesSent != 1 || s.BytesSent != 110:
+		t.Errorf("unexpected sent frames %+v", s)
+	case s.BinaryFramesReceived != 1 || s.BytesReceived != 50 || s.DecodeErrors != 1:
+		t.Errorf("unexpected received frames %+v", s)
+	case s.MessagesSent != 1 || s.MessagesFailed != 1 || s.SendLatency.Count != 1:
+		t.Errorf("unexpected sends %+v", s)
+	case s.Downloads.Transfers != 1 || s.Downloads.Bytes != 1000 || s.Uploads.Transfers != 0:
+		t.Errorf("unexpected media transfers %+v", s)
+	case s.KeepAlives != 2 || s.KeepAliveFailures != 1 || s.PendingRequests != 3:
+		t.Errorf("unexpected keep-alives %+v", s)
+	}
+	// 30ms falls into the 50ms bucket and all larger ones
+	if s.SendLatency.Counts[2] != 0 || s.SendLatency.Counts[3] != 1 || s.SendLatency.Counts[len(s.SendLatency.Counts)-1] != 1 {
+		t.Errorf("unexpected latency buckets %v", s.SendLatency.Counts)
+	}
+
+	// the snapshot must not share the buckets with the live histogram
+	m.MessageSent(time.Millisecond, nil)
+	if s.SendLatency.Counts[0] != 0 {
+		t.Error("snapshot changed after it was taken")
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	a := NewMetrics(map[string]string{"account": "a"})
+	b := NewMetrics(map[string]string{"account": "b"})
+	a.FrameSent(true, 100)
+	b.MessageSent(30*time.Millisecond, nil)
+	b.PendingRequestsChanged(2)
+
+	rec := httptest.NewRecorder()
+	PrometheusHandler(a, b).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	out := string(body)
+	for _, want := range []string{
+		"# TYPE whatsapp_frames_sent_total counter\n",
+		`whatsapp_frames_sent_total{account="a",type="binary"} 1` + "\n",
+		`whatsapp_frames_sent_total{account="b",type="binary"} 0` + "\n",
+		"# TYPE whatsapp_send_latency_seconds histogram\n",
+		`whatsapp_send_latency_seconds_bucket{account="b",le="0.025"} 0` + "\n",
+		`whatsapp_send_latency_seconds_bucket{account="b",le="0.05"} 1` + "\n",
+		`whatsapp_send_latency_seconds_bucket{account="b",le="+Inf"} 1` + "\n",
+		`whatsapp_send_latency_seconds_count{account="b"} 1` + "\n",
+		`whatsapp_pending_requests{account="b"} 2` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q", want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	NewMetrics(nil).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "whatsapp_decode_errors_total 0\n") {
+		t.Errorf("unexpected output without labels:\n%s", rec.Body.String())
+	}
+}
+
+func TestMetricsSharedPendingRequests(t *testing.T) {
+	m := NewMetrics(nil)
+	// two Conns report their changes interleaved
+	m.PendingRequestsChanged(2)
+	m.PendingRequestsChanged(1)
+	m.PendingRequestsChanged(-2)
+	if n := m.Snapshot().PendingRequests; n != 1 {
+		t.Errorf("expected 1 pending request, got %d", n)
+	}
+}
+
+func TestPrometheusHandlerFunc(t *testing.T) {
+	var metrics []*Metrics
+	handler := PrometheusHandlerFunc(func() []*Metrics { return metrics })
+	metrics = append(metrics, NewMetrics(map[string]string{"account": "added later"}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `whatsapp_pending_requests{account="added later"} 0`) {
+		t.Errorf("metrics added later are missing:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,63 @@
+package whatsapp
+
+import (
+	"time"
+)
+
+// MediaDirection tells an Observer whether media was uploaded or downloaded.
+type MediaDirection int
+
+const (
+	MediaUpload MediaDirection = iota
+	MediaDownload
+)
+
+func (d MediaDirection) String() string {
+	if d == MediaDownload {
+		return "download"
+	}
+	return "upload"
+}
+
+/*
+Observer is notified about the traffic of a Conn, e.g. to collect metrics. The methods are called synchronously from
+the goroutines reading and writing the connection, so they must be safe for concurrent use and return quickly. Embed
+NopObserver to implement only some of them. Metrics is the Observer shipped with the package.
+*/
+type Observer interface {
+	// FrameSent is called for every frame written to the websocket, size is the length of the frame in bytes.
+	FrameSent(binary bool, size int)
+	// FrameReceived is called for every frame read from the websocket.
+	FrameReceived(binary bool, size int)
+	// DecodeError is called if a received frame could not be decoded or decrypted.
+	DecodeError(err error)
+	// MessageSent is called after a relayed message was acknowledged by the server, or failed.
+	MessageSent(latency time.Duration, err error)
+	// MediaTransferred is called after an upload or download finished, size is the size of the plain media.
+	MediaTransferred(direction MediaDirection, mediaType MediaType, size int, latency time.Duration, err error)
+	// KeepAlive is called after every keep-alive with the round trip time.
+	KeepAlive(rtt time.Duration, err error)
+	// PendingRequestsChanged is called whenever the number of requests waiting for a response changes, delta is the
+	// change, so the changes of several Conns add up.
+	PendingRequestsChanged(delta int)
+}
+
+// NopObserver ignores everything. It can be embedded into an Observer that only needs some of the methods.
+type NopObserver struct{}
+
+func (NopObserver) FrameSent(binary bool, size int)              {}
+func (NopObserver) FrameReceived(binary bool, size int)          {}
+func (NopObserver) DecodeError(err error)                        {}
+func (NopObserver) MessageSent(latency time.Duration, err error) {}
+func (NopObserver) MediaTransferred(direction MediaDirection, mediaType MediaType, size int, latency time.Duration, err error) {
+}
+func (NopObserver) KeepAlive(rtt time.Duration, err error) {}
+func (NopObserver) PendingRequestsChanged(delta int)       {}
+
+// observer returns the configured Observer, a Conn without one reports to NopObserver.
+func (wac *Conn) observer() Observer {
+	if wac.obs == nil {
+		return NopObserver{}
+	}
+	return wac.obs
+}
@@ -0,0 +1,48 @@
+package whatsapp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+func TestObserverMetrics(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+
+	metrics := whatsapp.NewMetrics(map[string]string{"account": "test"})
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithObserver(metrics),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	if _, err := wac.Send(whatsapp.TextMessage{Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: "hello"}); err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	if _, err := wac.Download("", nil, whatsapp.MediaImage, 0); err == nil {
+		t.Fatal("expected download without url to fail")
+	}
+
+	s := metrics.Snapshot()
+	switch {
+	case s.JSONFramesSent == 0 || s.BinaryFramesSent != 1:
+		t.Errorf("unexpected sent frames %+v", s)
+	case s.JSONFramesReceived == 0:
+		t.Errorf("no received frames counted %+v", s)
+	case s.MessagesSent != 1 || s.SendLatency.Count != 1:
+		t.Errorf("send not counted %+v", s)
+	case s.Downloads.Errors != 1:
+		t.Errorf("failed download not counted %+v", s.Downloads)
+	case s.PendingRequests != 0 && s.PendingRequests != 1: // the keep-alive may be waiting
+		t.Errorf("requests left pending: %d", s.PendingRequests)
+	}
+}
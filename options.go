@@ -0,0 +1,273 @@
+package whatsapp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DispatchMode controls how handlers are called by the dispatcher.
+type DispatchMode int
+
+const (
+	// DispatchAsync calls every handler in its own goroutine, unless it implements SyncHandler.
+	DispatchAsync DispatchMode = iota
+	// DispatchSync calls all handlers synchronously from the goroutine reading the websocket.
+	DispatchSync
+)
+
+/*
+Config holds the settings of a Conn. Start from DefaultConfig and change what you need, or use NewConnWithOptions.
+*/
+type Config struct {
+	// Timeout is applied to every request waiting for a response, and to the steps of Login and Restore. It must be
+	// positive.
+	Timeout time.Duration
+	// Proxy is used when dialing the default websocket transport and for media requests, unless HTTPClient was
+	// replaced. It can not be combined with Transport.
+	Proxy func(*http.Request) (*url.URL, error)
+	// Transport replaces the websocket connection to the WhatsAppWeb servers.
+	Transport Transport
+
+	// LongClientName, ShortClientName and ClientVersion are displayed in the WhatsApp Web device list.
+	LongClientName  string
+	ShortClientName string
+	ClientVersion   string
+
+	// KeepAliveMin and KeepAliveMax bound the random interval between two keep-alives. They are used in whole
+	// milliseconds and must differ by at least one.
+	KeepAliveMin time.Duration
+	KeepAliveMax time.Duration
+
+	// HTTPClient is used for all media requests: uploads, downloads and profile pictures. Its proxy, timeouts and TLS
+	// settings apply as they are, Proxy only changes the default client. The client derived for the Proxy bounds
+	// dialing, the TLS handshake and the wait for the response headers by Timeout, the transfer itself is not limited.
+	HTTPClient *http.Client
+	// Dispatch sets how handlers are called.
+	Dispatch DispatchMode
+	// RateLimit enables the outbound queue for relayed messages if set.
+	RateLimit *RateLimit
+	// Outbox stores messages sent while the connection is unavailable, they are replayed after the next restore.
+	Outbox Outbox
+	// SessionStore persists the session whenever its tokens change, see WithSessionStore.
+	SessionStore SessionStore
+	// Logger receives the log output of the Conn. Nothing is logged if it is nil.
+	Logger Logger
+	// Observer is notified about frames, sends, media transfers and keep-alives, e.g. a Metrics.
+	Observer Observer
+	// Tracer creates spans around sends, media transfers, queries, restores and logins.
+	Tracer Tracer
+	// StallIntervals is the number of keep-alive intervals without any received frame after which a Stalled
+	// ConnectionEvent is emitted. 0 disables the detection.
+	StallIntervals int
+	// PhoneMonitor enables the periodic admin tests that tell whether the phone is reachable if set.
+	PhoneMonitor *PhoneMonitorConfig
+}
+
+// DefaultConfig returns the configuration NewConn uses.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:         20 * time.Second,
+		LongClientName:  "github.com/cristalinojr/go-whatsapp",
+		ShortClientName: "go-whatsapp",
+		ClientVersion:   "0.1.0",
+		KeepAliveMin:    20 * time.Second,
+		KeepAliveMax:    55 * time.Second,
+		HTTPClient:      http.DefaultClient,
+		Dispatch:        DispatchAsync,
+		StallIntervals:  3,
+	}
+}
+
+// Validate reports the first invalid setting, wrapped in ErrInvalidConfig.
+func (c Config) Validate() error {
+	switch {
+	case c.Timeout <= 0:
+		return fmt.Errorf("%w: timeout must be positive", ErrInvalidConfig)
+	case c.Proxy != nil && c.Transport != nil:
+		return fmt.Errorf("%w: proxy can not be combined with a custom transport", ErrInvalidConfig)
+	case c.LongClientName == "" || c.ShortClientName == "" || c.ClientVersion == "":
+		return fmt.Errorf("%w: client names and version must not be empty", ErrInvalidConfig)
+	case c.KeepAliveMin < time.Millisecond:
+		return fmt.Errorf("%w: keep-alive interval too short", ErrInvalidConfig)
+	case c.KeepAliveMax.Truncate(time.Millisecond) <= c.KeepAliveMin.Truncate(time.Millisecond):
+		// the interval is drawn in whole milliseconds
+		return fmt.Errorf("%w: maximum keep-alive interval must be at least 1ms greater than the minimum", ErrInvalidConfig)
+	case c.HTTPClient == nil:
+		return fmt.Errorf("%w: missing http client", ErrInvalidConfig)
+	case c.Dispatch != DispatchAsync && c.Dispatch != DispatchSync:
+		return fmt.Errorf("%w: unknown dispatch mode %d", ErrInvalidConfig, c.Dispatch)
+	case c.StallIntervals < 0:
+		return fmt.Errorf("%w: negative stall intervals", ErrInvalidConfig)
+	}
+	if r := c.RateLimit; r != nil {
+		switch {
+		case r.Rate < 0 || r.PerChatRate < 0:
+			return fmt.Errorf("%w: negative rate limit", ErrInvalidConfig)
+		case r.Burst < 0 || r.PerChatBurst < 0 || r.MaxQueued < 0:
+			return fmt.Errorf("%w: negative burst or queue size", ErrInvalidConfig)
+		case r.Policy != QueueBlock && r.Policy != QueueFailFast:
+			return fmt.Errorf("%w: unknown queue policy %d", ErrInvalidConfig, r.Policy)
+		}
+	}
+	if p := c.PhoneMonitor; p != nil {
+		switch {
+		case p.Interval < 0 || p.Timeout < 0 || p.Failures < 0:
+			return fmt.Errorf("%w: negative phone monitor interval, timeout or failures", ErrInvalidConfig)
+		case p.LowBattery < 0 || p.LowBattery > 100:
+			return fmt.Errorf("%w: low battery threshold out of range", ErrInvalidConfig)
+		}
+	}
+	return nil
+}
+
+// Option changes a single setting of the Config passed to NewConnWithOptions.
+type Option func(*Config)
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Config) { c.Timeout = timeout }
+}
+
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) Option {
+	return func(c *Config) { c.Proxy = proxy }
+}
+
+func WithTransport(transport Transport) Option {
+	return func(c *Config) { c.Transport = transport }
+}
+
+func WithClientName(long, short, version string) Option {
+	return func(c *Config) { c.LongClientName, c.ShortClientName, c.ClientVersion = long, short, version }
+}
+
+func WithKeepAlive(min, max time.Duration) Option {
+	return func(c *Config) { c.KeepAliveMin, c.KeepAliveMax = min, max }
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Config) { c.HTTPClient = client }
+}
+
+func WithDispatchMode(mode DispatchMode) Option {
+	return func(c *Config) { c.Dispatch = mode }
+}
+
+func WithRateLimit(limit RateLimit) Option {
+	return func(c *Config) { c.RateLimit = &limit }
+}
+
+func WithOutbox(outbox Outbox) Option {
+	return func(c *Config) { c.Outbox = outbox }
+}
+
+/*
+WithSessionStore saves the session to store after every login and restore, including restores that had to resolve a
+challenge, and whenever the server rotates the tokens. The session is deleted from store on Logout and once the server
+reports it unpaired. Errors of the store are logged and passed to the handlers, they never fail the login itself.
+*/
+func WithSessionStore(store SessionStore) Option {
+	return func(c *Config) { c.SessionStore = store }
+}
+
+func WithStallDetection(intervals int) Option {
+	return func(c *Config) { c.StallIntervals = intervals }
+}
+
+func WithPhoneMonitor(cfg PhoneMonitorConfig) Option {
+	return func(c *Config) { c.PhoneMonitor = &cfg }
+}
+
+func WithLogger(logger Logger) Option {
+	return func(c *Config) { c.Logger = logger }
+}
+
+func WithObserver(observer Observer) Option {
+	return func(c *Config) { c.Observer = observer }
+}
+
+func WithTracer(tracer Tracer) Option {
+	return func(c *Config) { c.Tracer = tracer }
+}
+
+/*
+NewConnWithOptions creates a new connection from DefaultConfig changed by the given options. The connection to the
+WhatsAppWeb servers is established right away.
+*/
+func NewConnWithOptions(opts ...Option) (*Conn, error) {
+	cfg := DefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return NewConnWithConfig(cfg)
+}
+
+/*
+NewConnWithConfig creates a new connection from cfg. The connection to the WhatsAppWeb servers is established right
+away. An invalid cfg is rejected before anything is dialed.
+*/
+func NewConnWithConfig(cfg Config) (*Conn, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	wac := newConn(cfg)
+	return wac, wac.connect()
+}
+
+func newConn(cfg Config) *Conn {
+	var outbound *outboundQueue
+	if cfg.RateLimit != nil {
+		outbound = newOutboundQueue(*cfg.RateLimit)
+	}
+	return &Conn{
+		handler:    make([]Handler, 0),
+		listener:   &listenerWrapper{m: make(map[string]chan string), expires: make(map[string]time.Time)},
+		closed:     make(chan struct{}),
+		msgCount:   0,
+		msgTimeout: cfg.Timeout,
+		Store:      newStore(),
+		transport:  cfg.Transport,
+		Proxy:      cfg.Proxy,
+
+		longClientName:  cfg.LongClientName,
+		shortClientName: cfg.ShortClientName,
+		clientVersion:   cfg.ClientVersion,
+
+		keepAliveMin:   cfg.KeepAliveMin,
+		keepAliveMax:   cfg.KeepAliveMax,
+		stallIntervals: cfg.StallIntervals,
+		httpClient:     mediaClient(cfg),
+		dispatchMode:   cfg.Dispatch,
+		outbound:       outbound,
+		outbox:         cfg.Outbox,
+		sessions:       cfg.SessionStore,
+		log:            cfg.Logger,
+		obs:            cfg.Observer,
+		tracer:         cfg.Tracer,
+		phone:          newPhoneMonitor(cfg.PhoneMonitor),
+	}
+}
+
+/*
+mediaClient returns the http.Client media is transferred with. If a Proxy is configured and the default client was
+kept, a client using the proxy is derived, so media does not bypass it. An unreachable proxy must not block forever, so
+the derived transport gives up after the request timeout until the response headers arrived. The client has no
+overall timeout, which would also cut off large transfers.
+*/
+func mediaClient(cfg Config) *http.Client {
+	if cfg.Proxy == nil || cfg.HTTPClient != http.DefaultClient {
+		return cfg.HTTPClient
+	}
+	var transport *http.Transport
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = t.Clone()
+	} else {
+		transport = &http.Transport{}
+	}
+	transport.Proxy = cfg.Proxy
+	transport.DialContext = (&net.Dialer{Timeout: cfg.Timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = cfg.Timeout
+	transport.ResponseHeaderTimeout = cfg.Timeout
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,105 @@
+package whatsapp
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+
+	invalid := []Option{
+		WithTimeout(-time.Second),
+		WithTimeout(0),
+		WithClientName("", "short", "1.0"),
+		WithKeepAlive(0, time.Second),
+		WithKeepAlive(time.Second, time.Second),
+		WithKeepAlive(time.Second, time.Second+500*time.Microsecond),
+		WithHTTPClient(nil),
+		WithDispatchMode(DispatchMode(42)),
+		WithRateLimit(RateLimit{Rate: -1}),
+		WithRateLimit(RateLimit{MaxQueued: -1}),
+		WithRateLimit(RateLimit{Policy: QueuePolicy(42)}),
+		WithPhoneMonitor(PhoneMonitorConfig{Interval: -time.Second}),
+		WithPhoneMonitor(PhoneMonitorConfig{LowBattery: 101}),
+		func(c *Config) {
+			c.Proxy = http.ProxyFromEnvironment
+			c.Transport = &WebsocketTransport{}
+		},
+	}
+	for i, opt := range invalid {
+		cfg := DefaultConfig()
+		opt(&cfg)
+		if err := cfg.Validate(); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("option %d: expected ErrInvalidConfig, got %v", i, err)
+		}
+	}
+}
+
+func TestNewConnWithInvalidOptions(t *testing.T) {
+	wac, err := NewConnWithOptions(WithKeepAlive(time.Minute, time.Second))
+	if !errors.Is(err, ErrInvalidConfig) || wac != nil {
+		t.Fatalf("expected the config to be rejected, got %v", err)
+	}
+}
+
+func TestOptionsAreApplied(t *testing.T) {
+	client := &http.Client{}
+	cfg := DefaultConfig()
+	for _, opt := range []Option{
+		WithTimeout(time.Minute),
+		WithClientName("long", "short", "1.2.3"),
+		WithKeepAlive(time.Second, 2*time.Second),
+		WithHTTPClient(client),
+		WithDispatchMode(DispatchSync),
+	} {
+		opt(&cfg)
+	}
+
+	wac := newConn(cfg)
+	if wac.msgTimeout != time.Minute || wac.longClientName != "long" || wac.shortClientName != "short" ||
+		wac.clientVersion != "1.2.3" || wac.keepAliveMin != time.Second || wac.keepAliveMax != 2*time.Second ||
+		wac.httpClient != client {
+		t.Errorf("options were not applied: %+v", wac)
+	}
+	if !wac.shouldCallSynchronously(&h1{}) {
+		t.Error("expected handlers to be called synchronously")
+	}
+}
+
+func TestMediaClientUsesProxy(t *testing.T) {
+	proxyURL, _ := url.Parse("http://proxy.example:3128")
+	cfg := DefaultConfig()
+	WithProxy(http.ProxyURL(proxyURL))(&cfg)
+
+	client := newConn(cfg).httpClient
+	if client == http.DefaultClient {
+		t.Fatal("media bypasses the proxy")
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://mmg.whatsapp.net/d/f/file.enc", nil)
+	got, err := client.Transport.(*http.Transport).Proxy(req)
+	if err != nil || got == nil || got.String() != proxyURL.String() {
+		t.Errorf("unexpected proxy %v, %v", got, err)
+	}
+	if client.Timeout != 0 {
+		t.Errorf("derived client limits whole transfers to %v", client.Timeout)
+	}
+	if transport := client.Transport.(*http.Transport); transport.TLSHandshakeTimeout != cfg.Timeout || transport.ResponseHeaderTimeout != cfg.Timeout {
+		t.Errorf("derived transport has handshake timeout %v and header timeout %v, want %v",
+			transport.TLSHandshakeTimeout, transport.ResponseHeaderTimeout, cfg.Timeout)
+	}
+
+	custom := &http.Client{}
+	WithHTTPClient(custom)(&cfg)
+	if newConn(cfg).httpClient != custom {
+		t.Error("configured http client was replaced")
+	}
+	if newConn(DefaultConfig()).httpClient != http.DefaultClient {
+		t.Error("default client replaced without a proxy")
+	}
+}
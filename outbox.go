@@ -0,0 +1,263 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	protobuf "github.com/golang/protobuf/proto"
+	"go.mau.fi/whatsmeow/binary/proto"
+)
+
+/*
+Outbox stores messages that could not be sent because the connection was unavailable. Implementations must be safe
+for concurrent use. Put must ignore a message whose ID is already stored, Pending returns the stored messages in the
+order they were put and Remove deletes the message with the given ID, if any.
+*/
+type Outbox interface {
+	Put(msg *proto.WebMessageInfo) error
+	Pending() ([]*proto.WebMessageInfo, error)
+	Remove(id string) error
+}
+
+/*
+OutboxResult reports the outcome of replaying a queued message. Err is nil if the server accepted the message.
+*/
+type OutboxResult struct {
+	ID      string
+	Message *proto.WebMessageInfo
+	Err     error
+}
+
+/*
+FileOutbox is the default Outbox. Every message is stored in its own file inside a directory, so queued messages
+survive a restart of the application.
+*/
+type FileOutbox struct {
+	dir string
+
+	mu    sync.Mutex
+	files map[string]string // message id -> file name
+	seq   int64
+}
+
+const outboxFileSuffix = ".msg"
+
+// NewFileOutbox opens the outbox stored in dir. The directory is created if it does not exist.
+func NewFileOutbox(dir string) (*FileOutbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating outbox directory: %w", err)
+	}
+	o := &FileOutbox{dir: dir, files: make(map[string]string)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading outbox directory: %w", err)
+	}
+	for _, e := range entries {
+		seq, id, ok := parseOutboxFileName(e.Name())
+		if !ok {
+			continue
+		}
+		o.files[id] = e.Name()
+		if seq > o.seq {
+			o.seq = seq
+		}
+	}
+	return o, nil
+}
+
+// parseOutboxFileName splits a file name of the form <seq>-<hex id>.msg.
+func parseOutboxFileName(name string) (seq int64, id string, ok bool) {
+	if !strings.HasSuffix(name, outboxFileSuffix) {
+		return 0, "", false
+	}
+	parts := strings.SplitN(strings.TrimSuffix(name, outboxFileSuffix), "-", 2)
+	if len(parts) != 2 {
+		return 0, "", false
+	}
+	seq, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	rawId, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return 0, "", false
+	}
+	return seq, string(rawId), true
+}
+
+func (o *FileOutbox) Put(msg *proto.WebMessageInfo) error {
+	id := msg.GetKey().GetID()
+	if id == "" {
+		return ErrMissingMessageTag
+	}
+	data, err := protobuf.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error marshaling message: %w", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.files[id]; ok {
+		return nil
+	}
+
+	// the sequence keeps the files in send order, even after a restart
+	seq := time.Now().UnixNano()
+	if seq <= o.seq {
+		seq = o.seq + 1
+	}
+	name := fmt.Sprintf("%020d-%x%s", seq, id, outboxFileSuffix)
+
+	tmp := filepath.Join(o.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing outbox file: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(o.dir, name)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("error writing outbox file: %w", err)
+	}
+	o.files[id] = name
+	o.seq = seq
+	return nil
+}
+
+func (o *FileOutbox) Pending() ([]*proto.WebMessageInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading outbox directory: %w", err)
+	}
+	// os.ReadDir returns the entries sorted by name, which is the order they were put
+	msgs := make([]*proto.WebMessageInfo, 0, len(entries))
+	for _, e := range entries {
+		if _, _, ok := parseOutboxFileName(e.Name()); !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(o.dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading outbox file: %w", err)
+		}
+		msg := &proto.WebMessageInfo{}
+		if err := protobuf.Unmarshal(data, msg); err != nil {
+			return nil, fmt.Errorf("error unmarshaling outbox file %s: %w", e.Name(), err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func (o *FileOutbox) Remove(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	name, ok := o.files[id]
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(o.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing outbox file: %w", err)
+	}
+	delete(o.files, id)
+	return nil
+}
+
+// outboxRetryDelay is the time after which a replay stopped by the rate limit or a missing acknowledgement is retried.
+var outboxRetryDelay = 5 * time.Second
+
+/*
+isConnectionError reports whether err means the message never reached the websocket, so it can safely be queued.
+*/
+func isConnectionError(err error) bool {
+	var connErr *ErrConnectionFailed
+	return errors.Is(err, ErrInvalidWebsocket) || errors.Is(err, ErrNotConnected) || errors.As(err, &connErr)
+}
+
+/*
+isTransientError reports whether a replayed message may still be delivered later: it did not reach the websocket, the
+Conn was closed, the outbound queue refused it or the server did not acknowledge it in time. Such messages stay in the
+outbox.
+*/
+func isTransientError(err error) bool {
+	return isConnectionError(err) || errors.Is(err, ErrClosed) || errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrQueueFull) || errors.Is(err, ErrConnectionTimeout) || errors.Is(err, context.DeadlineExceeded)
+}
+
+/*
+queueBehindOutbox reports whether msg has to be stored behind the messages in the outbox. It waits for a running
+replay, so msg is only relayed directly once the outbox is empty.
+*/
+func (wac *Conn) queueBehindOutbox(msg *proto.WebMessageInfo) bool {
+	if !wac.outboxBacklog.Load() {
+		return false
+	}
+	wac.outboxLock.Lock()
+	defer wac.outboxLock.Unlock()
+	return wac.outboxBacklog.Load()
+}
+
+/*
+queueOffline stores msg in the outbox. The returned error is ErrMessageQueued if it was stored.
+*/
+func (wac *Conn) queueOffline(msg *proto.WebMessageInfo) error {
+	if err := wac.outbox.Put(msg); err != nil {
+		wac.logger().Error("error queueing message", "jid", msg.GetKey().GetRemoteJID(), "id", msg.GetKey().GetId(), "err", err)
+		return fmt.Errorf("error queueing message: %w", err)
+	}
+	wac.outboxBacklog.Store(true)
+	wac.logger().Info("message queued", "jid", msg.GetKey().GetRemoteJID(), "id", msg.GetKey().GetId())
+	return ErrMessageQueued
+}
+
+/*
+flushOutbox replays the messages stored in the outbox in order. It stops at the first message that fails with a
+transient error and keeps it and the messages behind it. If the connection went away again, they are replayed after
+the next successful restore, otherwise after outboxRetryDelay. Messages keep their ID, so the server drops a message
+that already arrived before.
+*/
+func (wac *Conn) flushOutbox() {
+	wac.outboxLock.Lock()
+	defer wac.outboxLock.Unlock()
+	if !wac.loggedIn.Load() || wac.closing.Load() {
+		return
+	}
+
+	msgs, err := wac.outbox.Pending()
+	if err != nil {
+		wac.handle(fmt.Errorf("error reading outbox: %w", err))
+		return
+	}
+	for _, msg := range msgs {
+		err := wac.relay(context.Background(), msg)
+		if isTransientError(err) {
+			wac.logger().Warn("outbox replay stopped", "id", msg.GetKey().GetId(), "err", err)
+			if !isConnectionError(err) && !errors.Is(err, ErrClosed) {
+				time.AfterFunc(outboxRetryDelay, wac.flushOutbox)
+			}
+			return
+		}
+		id := msg.GetKey().GetID()
+		if rmErr := wac.outbox.Remove(id); rmErr != nil {
+			wac.handle(fmt.Errorf("error removing message %s from outbox: %w", id, rmErr))
+		}
+		wac.handle(OutboxResult{ID: id, Message: msg, Err: err})
+	}
+	wac.outboxBacklog.Store(false)
+}
+
+// OutboxPending returns the messages waiting in the outbox. It is empty if no outbox is configured.
+func (wac *Conn) OutboxPending() ([]*proto.WebMessageInfo, error) {
+	if wac.outbox == nil {
+		return nil, nil
+	}
+	return wac.outbox.Pending()
+}
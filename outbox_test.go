@@ -0,0 +1,201 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/binary/proto"
+
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+func outboxMessage(id string) *proto.WebMessageInfo {
+	return &proto.WebMessageInfo{
+		Key:     &proto.MessageKey{ID: &id},
+		Message: &proto.Message{Conversation: &id},
+	}
+}
+
+func pendingIds(t *testing.T, o Outbox) []string {
+	t.Helper()
+	msgs, err := o.Pending()
+	if err != nil {
+		t.Fatalf("error reading pending messages: %v", err)
+	}
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		ids = append(ids, msg.GetKey().GetID())
+	}
+	return ids
+}
+
+func TestFileOutbox(t *testing.T) {
+	dir := t.TempDir()
+	o, err := NewFileOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"3EB0C", "3EB0A", "3EB0B", "3EB0A"} {
+		if err := o.Put(outboxMessage(id)); err != nil {
+			t.Fatalf("error putting %s: %v", id, err)
+		}
+	}
+	if ids := pendingIds(t, o); !reflect.DeepEqual(ids, []string{"3EB0C", "3EB0A", "3EB0B"}) {
+		t.Errorf("unexpected pending messages %v", ids)
+	}
+
+	if err := o.Remove("3EB0A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Remove("unknown"); err != nil {
+		t.Errorf("removing an unknown id failed: %v", err)
+	}
+
+	reopened, err := NewFileOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Put(outboxMessage("3EB0D")); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Put(outboxMessage("3EB0B")); err != nil {
+		t.Fatal(err)
+	}
+	if ids := pendingIds(t, reopened); !reflect.DeepEqual(ids, []string{"3EB0C", "3EB0B", "3EB0D"}) {
+		t.Errorf("unexpected pending messages after reopening %v", ids)
+	}
+
+	msgs, _ := reopened.Pending()
+	if msgs[0].GetMessage().GetConversation() != "3EB0C" {
+		t.Errorf("message content not preserved: %v", msgs[0])
+	}
+}
+
+func TestFileOutboxRejectsMissingId(t *testing.T) {
+	o, err := NewFileOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Put(&proto.WebMessageInfo{}); err != ErrMissingMessageTag {
+		t.Errorf("expected ErrMissingMessageTag, got %v", err)
+	}
+}
+
+type outboxResultRecorder struct {
+	results chan OutboxResult
+}
+
+func (r *outboxResultRecorder) ShouldCallSynchronously() bool { return true }
+func (r *outboxResultRecorder) HandleError(err error)         {}
+func (r *outboxResultRecorder) HandleOutboxResult(result OutboxResult) {
+	r.results <- result
+}
+
+func TestOutboxKeepsRateLimitedMessagesInOrder(t *testing.T) {
+	defer func(delay time.Duration) { outboxRetryDelay = delay }(outboxRetryDelay)
+	outboxRetryDelay = 20 * time.Millisecond
+
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	outbox, err := NewFileOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wac, err := NewConnWithOptions(
+		WithTransport(&WebsocketTransport{URL: srv.URL}),
+		WithTimeout(time.Second),
+		WithOutbox(outbox),
+		WithRateLimit(RateLimit{PerChatRate: 5, PerChatBurst: 1, Policy: QueueFailFast}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+	recorder := &outboxResultRecorder{results: make(chan OutboxResult, 16)}
+	wac.AddHandler(recorder)
+
+	send := func(text string) {
+		t.Helper()
+		_, err := wac.Send(TextMessage{Info: MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: text})
+		if !errors.Is(err, ErrMessageQueued) {
+			t.Fatalf("expected %q to be queued, got %v", text, err)
+		}
+	}
+	want := []string{"first", "second", "third"}
+	for _, text := range want {
+		send(text)
+	}
+	if _, err := wac.RestoreWithSession(Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	// the stored messages are held back by the rate limit, a new message has to wait behind them
+	send("fourth")
+	want = append(want, "fourth")
+
+	for _, text := range want {
+		msg, err := srv.WaitForMessage(3 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := msg.GetMessage().GetConversation(); got != text {
+			t.Fatalf("got %q, want %q", got, text)
+		}
+		select {
+		case result := <-recorder.results:
+			if result.Err != nil {
+				t.Fatalf("message %s failed: %v", result.ID, result.Err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no outbox result reported")
+		}
+	}
+	if ids := pendingIds(t, outbox); len(ids) != 0 {
+		t.Errorf("outbox not empty after replay: %v", ids)
+	}
+}
+
+func TestOutboxKeepsMessagesOnClose(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	outbox, err := NewFileOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wac, err := NewConnWithOptions(WithTransport(&WebsocketTransport{URL: srv.URL}), WithTimeout(3*time.Second), WithOutbox(outbox))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &outboxResultRecorder{results: make(chan OutboxResult, 16)}
+	wac.AddHandler(recorder)
+
+	for _, text := range []string{"first", "second", "third"} {
+		_, err := wac.Send(TextMessage{Info: MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: text})
+		if !errors.Is(err, ErrMessageQueued) {
+			t.Fatalf("expected %q to be queued, got %v", text, err)
+		}
+	}
+	// the replay waits for the first acknowledgement while the Conn is closed
+	srv.SetResponseDelay(300 * time.Millisecond)
+	if _, err := wac.RestoreWithSession(Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	if _, err := srv.WaitForMessage(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _ = wac.Close(ctx)
+
+	select {
+	case result := <-recorder.results:
+		t.Fatalf("unexpected outbox result %+v", result)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if ids := pendingIds(t, outbox); len(ids) != 3 {
+		t.Errorf("expected all 3 messages to stay in the outbox, got %v", ids)
+	}
+}
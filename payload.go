@@ -0,0 +1,238 @@
+package whatsapp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+/*
+The JSON payloads of the server are decoded into the structs below. Fields the server may leave out are pointers or
+keep their zero value, payloads with an unexpected shape are returned as ErrInvalidResponse, which carries the raw
+payload, instead of panicking.
+*/
+
+// initResponse is the response to ["admin","init",...].
+type initResponse struct {
+	Ref  string `json:"ref"`
+	TTL  int64  `json:"ttl"`
+	Curr string `json:"curr"`
+}
+
+// decodeInitResponse decodes the response to an admin init. ref and ttl are only required for a login.
+func decodeInitResponse(requestType, r string, login bool) (initResponse, error) {
+	var resp initResponse
+	if err := decodeResponse(requestType, r, &resp); err != nil {
+		return resp, err
+	}
+	switch {
+	case login && resp.Ref == "":
+		return resp, invalidResponse(requestType, r, errors.New("missing ref"))
+	case login && resp.TTL <= 0:
+		return resp, invalidResponse(requestType, r, errors.New("missing ttl"))
+	case !login && resp.Curr == "":
+		return resp, invalidResponse(requestType, r, errors.New("missing curr"))
+	}
+	return resp, nil
+}
+
+/*
+decodeServerFrame splits a ["Cmd",{...}] style frame into its command and the payloads following it.
+*/
+func decodeServerFrame(requestType, r string) (string, []json.RawMessage, error) {
+	var frame []json.RawMessage
+	if err := json.Unmarshal([]byte(r), &frame); err != nil {
+		return "", nil, invalidResponse(requestType, r, err)
+	}
+	if len(frame) < 2 {
+		return "", nil, invalidResponse(requestType, r, errors.New("frame too short"))
+	}
+	var cmd string
+	if err := json.Unmarshal(frame[0], &cmd); err != nil {
+		return "", nil, invalidResponse(requestType, r, err)
+	}
+	return cmd, frame[1:], nil
+}
+
+// serverCmd is the payload of a ["Cmd",{...}] frame.
+type serverCmd struct {
+	Type      string `json:"type"`
+	Kind      string `json:"kind"`
+	Challenge string `json:"challenge"`
+}
+
+func decodeCmd(requestType string, payload json.RawMessage) (serverCmd, error) {
+	var cmd serverCmd
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return cmd, invalidResponse(requestType, string(payload), err)
+	}
+	if cmd.Type == "challenge" && cmd.Challenge == "" {
+		return cmd, invalidResponse(requestType, string(payload), errors.New("missing challenge"))
+	}
+	return cmd, nil
+}
+
+/*
+connInfo is the payload of a ["Conn",{...}] frame. The server only sends the fields that changed, e.g. the battery or
+the tokens, while logged in, and all of them when logging in.
+*/
+type connInfo struct {
+	Battery     *int       `json:"battery"`
+	Plugged     *bool      `json:"plugged"`
+	Connected   *bool      `json:"connected"`
+	Platform    *string    `json:"platform"`
+	Pushname    *string    `json:"pushname"`
+	Wid         *string    `json:"wid"`
+	Lc          *string    `json:"lc"`
+	Lg          *string    `json:"lg"`
+	Tos         *int       `json:"tos"`
+	Is24h       *bool      `json:"is24h"`
+	Phone       *phoneInfo `json:"phone"`
+	ClientToken string     `json:"clientToken"`
+	ServerToken string     `json:"serverToken"`
+	// Secret is only sent once the qr code was scanned, it carries the keys of the session.
+	Secret string `json:"secret"`
+}
+
+type phoneInfo struct {
+	Mcc                string `json:"mcc"`
+	Mnc                string `json:"mnc"`
+	OsVersion          string `json:"os_version"`
+	DeviceManufacturer string `json:"device_manufacturer"`
+	DeviceModel        string `json:"device_model"`
+	OsBuildNumber      string `json:"os_build_number"`
+	WaVersion          string `json:"wa_version"`
+}
+
+func decodeConnInfo(requestType string, payload json.RawMessage) (connInfo, error) {
+	var c connInfo
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return c, invalidResponse(requestType, string(payload), err)
+	}
+	return c, nil
+}
+
+/*
+decodeLoginInfo decodes the ["Conn",{...}] frame that completes a login or restore. It has to carry the phone, the
+tokens and the wid, and the secret if withSecret is set.
+*/
+func decodeLoginInfo(requestType, r string, withSecret bool) (connInfo, error) {
+	cmd, args, err := decodeServerFrame(requestType, r)
+	if err != nil {
+		return connInfo{}, err
+	}
+	if cmd != "Conn" {
+		return connInfo{}, invalidResponse(requestType, r, fmt.Errorf("unexpected %q frame", cmd))
+	}
+	var c connInfo
+	if err := json.Unmarshal(args[0], &c); err != nil {
+		return c, invalidResponse(requestType, r, err)
+	}
+	switch {
+	case c.Phone == nil:
+		return c, invalidResponse(requestType, r, errors.New("not a valid phone version"))
+	case c.ClientToken == "" || c.ServerToken == "":
+		return c, invalidResponse(requestType, r, errors.New("missing tokens"))
+	case c.Wid == nil || *c.Wid == "":
+		return c, invalidResponse(requestType, r, errors.New("missing wid"))
+	case withSecret && c.Secret == "":
+		return c, invalidResponse(requestType, r, errors.New("missing secret"))
+	}
+	return c, nil
+}
+
+// decodeSecret decodes the secret of a login, the public key of the phone followed by a hmac and the encrypted keys.
+func (c *connInfo) decodeSecret() ([]byte, error) {
+	secret, err := base64.StdEncoding.DecodeString(c.Secret)
+	if err != nil {
+		return nil, invalidResponse("login", c.Secret, err)
+	}
+	if len(secret) != 144 {
+		return nil, invalidResponse("login", c.Secret, fmt.Errorf("secret of %d bytes", len(secret)))
+	}
+	return secret, nil
+}
+
+// info returns the Info described by c.
+func (c *connInfo) info() *Info {
+	info := &Info{}
+	c.apply(info)
+	return info
+}
+
+// apply takes over the fields of c into info.
+func (c *connInfo) apply(info *Info) {
+	if c.Battery != nil {
+		info.Battery = *c.Battery
+	}
+	if c.Plugged != nil {
+		info.Plugged = *c.Plugged
+	}
+	if c.Connected != nil {
+		info.Connected = *c.Connected
+	}
+	if c.Platform != nil {
+		info.Platform = *c.Platform
+	}
+	if c.Pushname != nil {
+		info.Pushname = *c.Pushname
+	}
+	if c.Wid != nil {
+		info.Wid = *c.Wid
+	}
+	if c.Lc != nil {
+		info.Lc = *c.Lc
+	}
+	if c.Lg != nil {
+		info.Lg = *c.Lg
+	}
+	if c.Tos != nil {
+		info.Tos = *c.Tos
+	}
+	if c.Is24h != nil {
+		info.Is24h = *c.Is24h
+	}
+	if c.Phone != nil {
+		phone := PhoneInfo(*c.Phone)
+		info.Phone = &phone
+	}
+}
+
+// decodeStream decodes the arguments of a ["Stream","update",false,"2.2142.12"] frame.
+func decodeStream(requestType string, args []json.RawMessage) (stream, version string, err error) {
+	if err := json.Unmarshal(args[0], &stream); err != nil {
+		return "", "", invalidResponse(requestType, string(args[0]), err)
+	}
+	if len(args) > 2 {
+		if err := json.Unmarshal(args[2], &version); err != nil {
+			return "", "", invalidResponse(requestType, string(args[2]), err)
+		}
+	}
+	return stream, version, nil
+}
+
+// decodeBlocklist decodes the payload of a ["Blocklist",{"id":1,"blocklist":[...]}] frame.
+func decodeBlocklist(requestType string, payload json.RawMessage) ([]string, error) {
+	var blocklist struct {
+		Blocklist []string `json:"blocklist"`
+	}
+	if err := json.Unmarshal(payload, &blocklist); err != nil {
+		return nil, invalidResponse(requestType, string(payload), err)
+	}
+	return blocklist.Blocklist, nil
+}
+
+// decodePong decodes the ["Pong",true] response to an admin test.
+func decodePong(r string) error {
+	var pong []json.RawMessage
+	if err := json.Unmarshal([]byte(r), &pong); err != nil {
+		return invalidResponse("admin test", r, err)
+	}
+	var cmd string
+	var ok bool
+	if len(pong) != 2 || json.Unmarshal(pong[0], &cmd) != nil || json.Unmarshal(pong[1], &ok) != nil || cmd != "Pong" || !ok {
+		return invalidResponse("admin test", r, errors.New("unexpected ping response"))
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package whatsapp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+const testLoginInfo = `["Conn",{"battery":80,"plugged":true,"connected":true,"platform":"android","pushname":"test",` +
+	`"wid":"4915100000000@c.us","lc":"DE","lg":"de","tos":0,"is24h":true,"clientToken":"c","serverToken":"s",` +
+	`"phone":{"mcc":"262","mnc":"001","os_version":"11","device_manufacturer":"m","device_model":"d",` +
+	`"os_build_number":"1","wa_version":"2.21.1"}}]`
+
+// checkDecodeError fails unless err is nil or one of the errors a decoder may return.
+func checkDecodeError(t *testing.T, payload string, err error) {
+	t.Helper()
+	var status StatusResponse
+	if err != nil && !errors.Is(err, ErrInvalidServerResponse) && !errors.As(err, &status) {
+		t.Fatalf("unexpected error %v for %q", err, payload)
+	}
+	var invalid *ErrInvalidResponse
+	if errors.As(err, &invalid) && invalid.Payload == "" && payload != "" {
+		t.Fatalf("missing payload in %v for %q", err, payload)
+	}
+}
+
+func TestDecodeLoginInfo(t *testing.T) {
+	info, err := decodeLoginInfo("restore", testLoginInfo, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := info.info()
+	if i.Battery != 80 || !i.Plugged || !i.Connected || i.Wid != "4915100000000@c.us" || !i.Is24h || i.Phone == nil || i.Phone.OsVersion != "11" {
+		t.Errorf("unexpected info %+v", i)
+	}
+
+	invalid := []string{
+		``,
+		`{}`,
+		`["Conn"]`,
+		`[1,{}]`,
+		`["Cmd",{"type":"challenge","challenge":"abc"}]`,
+		`["Conn",{"battery":"full"}]`,
+		strings.Replace(testLoginInfo, `"phone":`, `"device":`, 1),
+		strings.Replace(testLoginInfo, `"wid":"4915100000000@c.us"`, `"wid":null`, 1),
+		strings.Replace(testLoginInfo, `"clientToken":"c"`, `"clientToken":""`, 1),
+		strings.Replace(testLoginInfo, `"tos":0`, `"tos":"0"`, 1),
+	}
+	for _, payload := range invalid {
+		_, err := decodeLoginInfo("restore", payload, false)
+		var e *ErrInvalidResponse
+		if !errors.Is(err, ErrInvalidServerResponse) || !errors.As(err, &e) || e.Payload != payload {
+			t.Errorf("expected ErrInvalidResponse with the payload for %q, got %v", payload, err)
+		}
+	}
+	if _, err := decodeLoginInfo("login", testLoginInfo, true); !errors.Is(err, ErrInvalidServerResponse) {
+		t.Errorf("accepted a login without secret: %v", err)
+	}
+}
+
+func TestDecodeSecret(t *testing.T) {
+	for _, tt := range []struct {
+		secret string
+		valid  bool
+	}{
+		{base64.StdEncoding.EncodeToString(make([]byte, 144)), true},
+		{base64.StdEncoding.EncodeToString(make([]byte, 32)), false},
+		{"not base64", false},
+	} {
+		c := connInfo{Secret: tt.secret}
+		_, err := c.decodeSecret()
+		if tt.valid != (err == nil) {
+			t.Errorf("%q: unexpected error %v", tt.secret, err)
+		}
+		checkDecodeError(t, tt.secret, err)
+	}
+}
+
+func TestDecodeInitResponse(t *testing.T) {
+	resp, err := decodeInitResponse("admin init", `{"status":200,"ref":"1@abc","ttl":20000,"curr":"2.2142.12"}`, true)
+	if err != nil || resp.Ref != "1@abc" || resp.TTL != 20000 || resp.Curr != "2.2142.12" {
+		t.Errorf("unexpected response %+v, %v", resp, err)
+	}
+	var status StatusResponse
+	if _, err := decodeInitResponse("admin init", `{"status":429}`, true); !errors.As(err, &status) || status.Status != 429 {
+		t.Errorf("expected StatusResponse, got %v", err)
+	}
+	for _, payload := range []string{`{"status":200}`, `{"ref":"1@abc","ttl":"20s"}`, `{"ref":1,"ttl":20000}`, `[]`} {
+		if _, err := decodeInitResponse("admin init", payload, true); !errors.Is(err, ErrInvalidServerResponse) {
+			t.Errorf("expected ErrInvalidServerResponse for %q, got %v", payload, err)
+		}
+	}
+	if _, err := decodeInitResponse("admin init", `{"ref":"1@abc","ttl":20000}`, false); !errors.Is(err, ErrInvalidServerResponse) {
+		t.Errorf("accepted a version check without curr: %v", err)
+	}
+}
+
+func TestDecodePong(t *testing.T) {
+	if err := decodePong(`["Pong",true]`); err != nil {
+		t.Error(err)
+	}
+	for _, payload := range []string{`["Pong",false]`, `["Pong"]`, `[true,"Pong"]`, `{"status":200}`, `["Pong",1]`} {
+		if err := decodePong(payload); !errors.Is(err, ErrInvalidServerResponse) {
+			t.Errorf("expected ErrInvalidServerResponse for %q, got %v", payload, err)
+		}
+	}
+}
+
+func TestDecodeServerFrames(t *testing.T) {
+	cmd, args, err := decodeServerFrame("server", `["Stream","update",false,"2.2142.12"]`)
+	if err != nil || cmd != "Stream" {
+		t.Fatalf("unexpected frame %q, %v", cmd, err)
+	}
+	if stream, version, err := decodeStream("Stream", args); err != nil || stream != "update" || version != "2.2142.12" {
+		t.Errorf("unexpected stream %q %q, %v", stream, version, err)
+	}
+	if _, _, err := decodeStream("Stream", []json.RawMessage{json.RawMessage(`1`)}); !errors.Is(err, ErrInvalidServerResponse) {
+		t.Errorf("expected ErrInvalidServerResponse, got %v", err)
+	}
+
+	if _, err := decodeCmd("Cmd", json.RawMessage(`{"type":"challenge"}`)); !errors.Is(err, ErrInvalidServerResponse) {
+		t.Errorf("accepted a challenge without challenge: %v", err)
+	}
+	if blocklist, err := decodeBlocklist("Blocklist", json.RawMessage(`{"id":1,"blocklist":["a@c.us"]}`)); err != nil || len(blocklist) != 1 {
+		t.Errorf("unexpected blocklist %v, %v", blocklist, err)
+	}
+	if _, err := decodeBlocklist("Blocklist", json.RawMessage(`{"blocklist":[1]}`)); !errors.Is(err, ErrInvalidServerResponse) {
+		t.Errorf("expected ErrInvalidServerResponse, got %v", err)
+	}
+}
+
+func FuzzDecodeInitResponse(f *testing.F) {
+	f.Add(`{"status":200,"ref":"1@abc","ttl":20000,"curr":"2.2142.12"}`)
+	f.Add(`{"status":429}`)
+	f.Add(`{"status":"200","ttl":1e400}`)
+	f.Fuzz(func(t *testing.T, payload string) {
+		_, err := decodeInitResponse("admin init", payload, true)
+		checkDecodeError(t, payload, err)
+		_, err = decodeInitResponse("admin init", payload, false)
+		checkDecodeError(t, payload, err)
+	})
+}
+
+func FuzzDecodeServerFrame(f *testing.F) {
+	f.Add(testLoginInfo)
+	f.Add(`["Cmd",{"type":"disconnect","kind":"replaced"}]`)
+	f.Add(`["Cmd",{"type":"challenge","challenge":"AAAA"}]`)
+	f.Add(`["Stream","update",false,"2.2142.12"]`)
+	f.Add(`["Blocklist",{"id":1,"blocklist":["a@c.us"]}]`)
+	f.Add(`["Conn",{"battery":null,"phone":{}}]`)
+	f.Fuzz(func(t *testing.T, payload string) {
+		_, args, err := decodeServerFrame("server", payload)
+		checkDecodeError(t, payload, err)
+		if err == nil {
+			c, err := decodeConnInfo("Conn", args[0])
+			checkDecodeError(t, string(args[0]), err)
+			c.info()
+			_, err = decodeCmd("Cmd", args[0])
+			checkDecodeError(t, string(args[0]), err)
+			_, _, err = decodeStream("Stream", args)
+			checkDecodeError(t, payload, err)
+			_, err = decodeBlocklist("Blocklist", args[0])
+			checkDecodeError(t, string(args[0]), err)
+		}
+
+		for _, withSecret := range []bool{false, true} {
+			c, err := decodeLoginInfo("login", payload, withSecret)
+			checkDecodeError(t, payload, err)
+			if err == nil {
+				c.info()
+				_, err = c.decodeSecret()
+				checkDecodeError(t, c.Secret, err)
+			}
+		}
+	})
+}
+
+func FuzzDecodePong(f *testing.F) {
+	f.Add(`["Pong",true]`)
+	f.Add(`["Pong",false]`)
+	f.Fuzz(func(t *testing.T, payload string) {
+		checkDecodeError(t, payload, decodePong(payload))
+	})
+}
+
+func FuzzDecodeResponse(f *testing.F) {
+	f.Add(`{"status":200,"code":"abc"}`)
+	f.Add(`{"status":401}`)
+	f.Add(`{"status":"available","t":1}`)
+	f.Fuzz(func(t *testing.T, payload string) {
+		var v struct {
+			Code string `json:"code"`
+			T    int64  `json:"t"`
+		}
+		checkDecodeError(t, payload, decodeResponse("invite code query", payload, &v))
+	})
+}
+
+func FuzzProcessReadData(f *testing.F) {
+	f.Add("")
+	f.Add(",payload")
+	f.Add("!1234")
+	f.Add("tag,")
+	f.Add(`s1,["Conn",{"battery":80}]`)
+	f.Fuzz(func(t *testing.T, frame string) {
+		wac := newConn(DefaultConfig())
+		err := wac.processReadData(websocket.TextMessage, []byte(frame))
+		if err != nil && !errors.Is(err, ErrInvalidWsData) {
+			t.Fatalf("unexpected error %v for %q", err, frame)
+		}
+	})
+}
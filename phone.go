@@ -0,0 +1,334 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+PhoneMonitorConfig configures the phone monitor enabled with WithPhoneMonitor. WhatsAppWeb only relays through the
+phone, so messages sent while it is unreachable are kept by the server until the phone comes back. Unset fields but
+LowBattery are replaced by the defaults of DefaultPhoneMonitorConfig.
+*/
+type PhoneMonitorConfig struct {
+	// Interval is the time between two admin tests.
+	Interval time.Duration
+	// Timeout is the time the phone has to answer an admin test.
+	Timeout time.Duration
+	// Failures is the number of consecutive unanswered admin tests after which the phone is considered offline.
+	Failures int
+	// LowBattery is the percentage at or below which LowBattery is emitted while the phone is not plugged in. 0
+	// disables the event, start from DefaultPhoneMonitorConfig to keep the default threshold.
+	LowBattery int
+	// FailFast makes Send fail with ErrPhoneOffline while the phone is offline, instead of relaying the message.
+	FailFast bool
+}
+
+// DefaultPhoneMonitorConfig returns the configuration used for unset PhoneMonitorConfig fields.
+func DefaultPhoneMonitorConfig() PhoneMonitorConfig {
+	return PhoneMonitorConfig{
+		Interval:   30 * time.Second,
+		Timeout:    10 * time.Second,
+		Failures:   2,
+		LowBattery: 15,
+	}
+}
+
+func (cfg PhoneMonitorConfig) withDefaults() PhoneMonitorConfig {
+	def := DefaultPhoneMonitorConfig()
+	if cfg.Interval <= 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = def.Timeout
+	}
+	if cfg.Failures <= 0 {
+		cfg.Failures = def.Failures
+	}
+	return cfg
+}
+
+type PhoneEventType int
+
+const (
+	// PhoneOnline is emitted when the phone answers again after it was offline, or for the first time.
+	PhoneOnline PhoneEventType = iota
+	// PhoneOffline is emitted when the phone stopped answering admin tests or the server reported it disconnected.
+	PhoneOffline
+	// LowBattery is emitted once when the battery drops to PhoneMonitorConfig.LowBattery while not plugged in.
+	LowBattery
+)
+
+func (t PhoneEventType) String() string {
+	switch t {
+	case PhoneOnline:
+		return "phone online"
+	case PhoneOffline:
+		return "phone offline"
+	case LowBattery:
+		return "low battery"
+	}
+	return "unknown"
+}
+
+/*
+PhoneEvent describes a change of the phone observed by the phone monitor. It is dispatched to every handler
+implementing PhoneEventHandler. Err holds the error of the admin test that made the phone go offline, if any.
+*/
+type PhoneEvent struct {
+	Type    PhoneEventType
+	Battery int
+	Plugged bool
+	Err     error
+}
+
+// PhoneStatus is the state of the phone as last seen by the Conn.
+type PhoneStatus struct {
+	// Known is false until the phone answered an admin test or the server reported its state.
+	Known   bool
+	Online  bool
+	Since   time.Time // time of the last change of Online
+	Battery int
+	Plugged bool
+}
+
+/*
+ErrPhoneOffline is returned by Send while the phone is offline, if the phone monitor was configured with FailFast.
+*/
+type ErrPhoneOffline struct {
+	Since time.Time
+	Err   error
+}
+
+func (e *ErrPhoneOffline) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("phone offline since %s: %v", e.Since.Format(time.RFC3339), e.Err)
+	}
+	return fmt.Sprintf("phone offline since %s", e.Since.Format(time.RFC3339))
+}
+
+func (e *ErrPhoneOffline) Unwrap() error {
+	return e.Err
+}
+
+/*
+phoneMonitor tracks the phone. Battery frames and the server's connection info are always consumed, the admin tests
+only run if the monitor was enabled.
+*/
+type phoneMonitor struct {
+	cfg     PhoneMonitorConfig
+	enabled bool
+
+	mu         sync.Mutex
+	status     PhoneStatus
+	failures   int
+	lastErr    error
+	lowBattery bool // LowBattery was emitted and the battery was not charged since
+}
+
+func newPhoneMonitor(cfg *PhoneMonitorConfig) *phoneMonitor {
+	if cfg == nil {
+		return &phoneMonitor{cfg: PhoneMonitorConfig{}.withDefaults()}
+	}
+	return &phoneMonitor{cfg: cfg.withDefaults(), enabled: true}
+}
+
+/*
+PhoneStatus returns the state of the phone. Without a phone monitor only battery frames and the server's connection
+info keep it current.
+*/
+func (wac *Conn) PhoneStatus() PhoneStatus {
+	m := wac.phone
+	if m == nil {
+		return PhoneStatus{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+/*
+phoneOffline returns the error Send fails with while the phone is offline, nil if the phone is reachable, its state is
+unknown or FailFast is disabled.
+*/
+func (wac *Conn) phoneOffline() error {
+	m := wac.phone
+	if m == nil || !m.cfg.FailFast {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.status.Known || m.status.Online {
+		return nil
+	}
+	return &ErrPhoneOffline{Since: m.status.Since, Err: m.lastErr}
+}
+
+// setPhoneOnline records whether the phone is reachable and emits an event if that changed.
+func (wac *Conn) setPhoneOnline(online bool, err error) {
+	m := wac.phone
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	if online {
+		m.failures = 0
+		m.lastErr = nil
+	} else {
+		m.lastErr = err
+	}
+	if m.status.Known && m.status.Online == online {
+		m.mu.Unlock()
+		return
+	}
+	m.status.Known = true
+	m.status.Online = online
+	m.status.Since = time.Now()
+	event := PhoneEvent{Type: PhoneOffline, Battery: m.status.Battery, Plugged: m.status.Plugged, Err: err}
+	if online {
+		event.Type = PhoneOnline
+	}
+	m.mu.Unlock()
+
+	wac.logger().Info(event.Type.String(), "battery", event.Battery, "plugged", event.Plugged, "err", err)
+	wac.handle(event)
+}
+
+// updatePhoneInfo takes over the state of the phone received with a login or restore.
+func (wac *Conn) updatePhoneInfo(info *Info) {
+	wac.updateBattery(info.Battery, info.Plugged)
+	wac.setPhoneOnline(info.Connected, nil)
+}
+
+// adminTestFailed counts an unanswered admin test, the phone is offline after PhoneMonitorConfig.Failures of them.
+func (wac *Conn) adminTestFailed(err error) {
+	m := wac.phone
+	m.mu.Lock()
+	m.failures++
+	offline := m.failures >= m.cfg.Failures
+	m.mu.Unlock()
+	wac.logger().Warn("admin test failed", "err", err)
+	if offline {
+		wac.setPhoneOnline(false, err)
+	}
+}
+
+// updateBattery keeps Info and the phone status current and emits LowBattery.
+func (wac *Conn) updateBattery(battery int, plugged bool) {
+	wac.infoLock.Lock()
+	if wac.Info != nil {
+		// Info is handed out by GetInfo, so it is replaced instead of modified
+		info := *wac.Info
+		info.Battery = battery
+		info.Plugged = plugged
+		wac.Info = &info
+	}
+	wac.infoLock.Unlock()
+
+	m := wac.phone
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.status.Battery = battery
+	m.status.Plugged = plugged
+	low := !plugged && battery > 0 && battery <= m.cfg.LowBattery // never true if LowBattery is disabled
+	emit := low && !m.lowBattery
+	m.lowBattery = low
+	m.mu.Unlock()
+
+	if emit {
+		wac.logger().Warn("low battery", "battery", battery)
+		wac.handle(PhoneEvent{Type: LowBattery, Battery: battery, Plugged: plugged})
+	}
+}
+
+/*
+handleConnInfo consumes the payload of a ["Conn",{...}] frame the server sends whenever the phone changes, e.g. its
+battery or its connection, and whenever it rotates the tokens of the session. The changes are taken over by Info and
+dispatched as ConnInfoUpdated.
+*/
+func (wac *Conn) handleConnInfo(payload json.RawMessage) error {
+	c, err := decodeConnInfo("Conn", payload)
+	if err != nil {
+		return err
+	}
+
+	wac.infoLock.Lock()
+	// Info is handed out by GetInfo, so it is replaced instead of modified
+	var info Info
+	if wac.Info != nil {
+		info = *wac.Info
+	}
+	c.apply(&info)
+	wac.Info = &info
+	wac.infoLock.Unlock()
+
+	if c.Battery != nil || c.Plugged != nil {
+		// only the changed fields are sent, the merged info holds the other one
+		wac.updateBattery(info.Battery, info.Plugged)
+	}
+	if c.Connected != nil {
+		wac.setPhoneOnline(*c.Connected, nil)
+	}
+	if c.ClientToken != "" && c.ServerToken != "" {
+		wac.updateTokens(c.ClientToken, c.ServerToken)
+	}
+	wac.handle(ServerEvent{Type: ConnInfoUpdated, Info: wac.GetInfo()})
+	return nil
+}
+
+// updateTokens takes over tokens rotated by the server while logged in and saves the session.
+func (wac *Conn) updateTokens(clientToken, serverToken string) {
+	session := wac.getSession()
+	if session == nil || !wac.loggedIn.Load() {
+		return
+	}
+	if session.ClientToken == clientToken && session.ServerToken == serverToken {
+		return
+	}
+	updated := *session
+	updated.ClientToken = clientToken
+	updated.ServerToken = serverToken
+	wac.setSession(&updated)
+	wac.logger().Debug("tokens rotated", "jid", updated.Wid)
+	wac.saveSession()
+}
+
+/*
+monitorPhone runs an admin test every PhoneMonitorConfig.Interval while the Conn is logged in on ws. It stops when ws
+is closed.
+*/
+func (wac *Conn) monitorPhone(ws *websocketWrapper) {
+	defer ws.wg.Done()
+
+	cfg := wac.phone.cfg
+	timer := time.NewTimer(cfg.Interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-ws.close:
+			return
+		}
+		if wac.loggedIn.Load() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+			err := wac.AdminTestContext(ctx)
+			cancel()
+			switch {
+			case err == nil:
+				wac.setPhoneOnline(true, nil)
+			case errors.Is(err, ErrConnectionTimeout) || errors.Is(err, context.DeadlineExceeded):
+				wac.adminTestFailed(err)
+			default:
+				// the connection itself failed, which is up to the keep-alive and the reconnect supervisor
+				wac.logger().Debug("admin test aborted", "err", err)
+			}
+		}
+		timer.Reset(cfg.Interval)
+	}
+}
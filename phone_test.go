@@ -0,0 +1,142 @@
+package whatsapp_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+func TestPhoneMonitor(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithPhoneMonitor(whatsapp.PhoneMonitorConfig{
+			Interval:   20 * time.Millisecond,
+			Timeout:    50 * time.Millisecond,
+			Failures:   2,
+			LowBattery: 20,
+			FailFast:   true,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+	events := newRecorder(wac)
+
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	if event := events.nextPhoneEvent(t, whatsapp.PhoneOnline); event.Battery != 80 || !event.Plugged {
+		t.Errorf("unexpected battery in %+v", event)
+	}
+
+	if err := srv.PushBattery(15, false); err != nil {
+		t.Fatal(err)
+	}
+	events.nextPhoneEvent(t, whatsapp.LowBattery)
+	if info := wac.GetInfo(); info.Battery != 15 || info.Plugged {
+		t.Errorf("info not updated: %+v", info)
+	}
+	if err := srv.PushBattery(14, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.SetPhoneConnected(false, false); err != nil {
+		t.Fatal(err)
+	}
+	if event := events.nextPhoneEvent(t, whatsapp.PhoneOffline); event.Err == nil || event.Battery != 14 {
+		t.Errorf("unexpected offline event %+v", event)
+	}
+	_, err = wac.Send(whatsapp.TextMessage{Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: "hello"})
+	var offline *whatsapp.ErrPhoneOffline
+	if !errors.As(err, &offline) || offline.Since.IsZero() {
+		t.Errorf("expected ErrPhoneOffline, got %v", err)
+	}
+	if status := wac.PhoneStatus(); !status.Known || status.Online {
+		t.Errorf("unexpected phone status %+v", status)
+	}
+
+	if err := srv.SetPhoneConnected(true, true); err != nil {
+		t.Fatal(err)
+	}
+	events.nextPhoneEvent(t, whatsapp.PhoneOnline)
+	if _, err := wac.Send(whatsapp.TextMessage{Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: "hello"}); err != nil {
+		t.Errorf("error sending after the phone came back: %v", err)
+	}
+}
+
+func TestPhoneMonitorBatteryOnlyConnInfo(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithPhoneMonitor(whatsapp.PhoneMonitorConfig{Interval: time.Minute, LowBattery: 20}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+	events := newRecorder(wac)
+
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	if event := events.nextPhoneEvent(t, whatsapp.PhoneOnline); !event.Plugged {
+		t.Fatalf("phone not plugged in: %+v", event)
+	}
+
+	// the server only sends the fields that changed, the phone is still charging
+	if err := srv.PushJSON([]interface{}{"Conn", map[string]interface{}{"battery": 10}}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for wac.PhoneStatus().Battery != 10 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if status := wac.PhoneStatus(); status.Battery != 10 || !status.Plugged {
+		t.Errorf("unexpected phone status %+v", status)
+	}
+	if info := wac.GetInfo(); info.Battery != 10 || !info.Plugged {
+		t.Errorf("unexpected info %+v", info)
+	}
+	events.expectNone(t, whatsapp.PhoneEvent{}, 50*time.Millisecond)
+}
+
+func TestPhoneMonitorLowBatteryDisabled(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithPhoneMonitor(whatsapp.PhoneMonitorConfig{Interval: time.Minute}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+	events := newRecorder(wac)
+
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	events.nextPhoneEvent(t, whatsapp.PhoneOnline)
+
+	if err := srv.PushBattery(5, false); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for wac.PhoneStatus().Battery != 5 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	events.expectNone(t, whatsapp.PhoneEvent{}, 50*time.Millisecond)
+}
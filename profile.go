@@ -1,6 +1,7 @@
 package whatsapp
 
 import (
+	"context"
 	"fmt"
 	"github.com/cristalinojr/go-whatsapp/binary"
 	"strconv"
@@ -8,20 +9,32 @@ import (
 )
 
 // Pictures must be JPG 640x640 and 96x96, respectively
+//
+// Deprecated: use UploadProfilePicContext, which waits for the response.
 func (wac *Conn) UploadProfilePic(image, preview []byte) (<-chan string, error) {
-	tag := fmt.Sprintf("%d.--%d", time.Now().Unix(), wac.msgCount*19)
-	n := binary.Node{
+	tag := fmt.Sprintf("%d.--%d", time.Now().Unix(), wac.messageCount()*19)
+	return wac.writeBinary(wac.profilePicNode(image, preview, tag), profile, 136, tag)
+}
+
+// UploadProfilePicContext sets the own profile picture. Pictures must be JPG 640x640 and 96x96, respectively.
+func (wac *Conn) UploadProfilePicContext(ctx context.Context, image, preview []byte) error {
+	tag := fmt.Sprintf("%d.--%d", time.Now().Unix(), wac.messageCount()*19)
+	return wac.binaryRequest(ctx, "profile picture", wac.profilePicNode(image, preview, tag), profile, 136, tag, nil)
+}
+
+func (wac *Conn) profilePicNode(image, preview []byte, tag string) binary.Node {
+	return binary.Node{
 		Description: "action",
 		Attributes: map[string]string{
 			"type":  "set",
-			"epoch": strconv.Itoa(wac.msgCount),
+			"epoch": strconv.Itoa(wac.messageCount()),
 		},
 		Content: []interface{}{
 			binary.Node{
 				Description: "picture",
 				Attributes: map[string]string{
 					"id":   tag,
-					"jid":  wac.Info.Wid,
+					"jid":  wac.GetInfo().Wid,
 					"type": "set",
 				},
 				Content: []binary.Node{
@@ -39,5 +52,4 @@ func (wac *Conn) UploadProfilePic(image, preview []byte) (<-chan string, error)
 			},
 		},
 	}
-	return wac.writeBinary(n, profile, 136, tag)
 }
@@ -0,0 +1,496 @@
+/*
+Package qrcode encodes QR codes, e.g. the one the phone scans to log in, without any dependencies. Data is always
+encoded in byte mode, the smallest version fitting the data at the requested error correction level is used.
+*/
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrTooLong = errors.New("data too long for a qr code")
+
+// Level is the error correction level, the share of the code that can be damaged without losing data.
+type Level int
+
+const (
+	Low      Level = iota // about 7%
+	Medium                // about 15%
+	Quartile              // about 25%
+	High                  // about 30%
+)
+
+// formatBits are the error correction bits of the format information, which do not follow the order of the levels.
+var formatBits = [4]int{Low: 1, Medium: 0, Quartile: 3, High: 2}
+
+// eccPerBlock and numBlocks describe the error correction of every version, indexed by level and version.
+var eccPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var numBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// Code is an encoded QR code. The modules are addressed by x and y, starting at the top left corner.
+type Code struct {
+	Version int
+	Level   Level
+	Size    int // width and height in modules, without the quiet zone
+
+	modules    []bool // dark modules
+	isFunction []bool // modules of the function patterns, which are not masked
+}
+
+// Encode encodes data at the given error correction level.
+func Encode(data []byte, level Level) (*Code, error) {
+	return encode(data, level, -1)
+}
+
+// EncodeString works like Encode for a string.
+func EncodeString(s string, level Level) (*Code, error) {
+	return Encode([]byte(s), level)
+}
+
+// encode encodes data with the given mask, or the mask with the lowest penalty if mask is negative.
+func encode(data []byte, level Level, mask int) (*Code, error) {
+	if level < Low || level > High {
+		return nil, fmt.Errorf("unknown error correction level %d", level)
+	}
+	version := 0
+	for v := 1; v <= 40; v++ {
+		if 4+charCountBits(v)+len(data)*8 <= numDataCodewords(v, level)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLong, len(data))
+	}
+
+	// mode indicator, character count, data, terminator and padding
+	var bits bitBuffer
+	bits.append(0x4, 4)
+	bits.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := numDataCodewords(version, level) * 8
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	c := &Code{Version: version, Level: level, Size: version*4 + 17}
+	c.modules = make([]bool, c.Size*c.Size)
+	c.isFunction = make([]bool, c.Size*c.Size)
+	c.drawFunctionPatterns()
+	c.drawCodewords(c.addErrorCorrection(bits.bytes()))
+
+	if mask < 0 {
+		best := -1
+		for m := 0; m < 8; m++ {
+			c.applyMask(m)
+			c.drawFormatBits(m)
+			if p := c.penalty(); best < 0 || p < best {
+				best, mask = p, m
+			}
+			c.applyMask(m) // masks are undone by applying them again
+		}
+	}
+	c.applyMask(mask)
+	c.drawFormatBits(mask)
+	return c, nil
+}
+
+// Black reports whether the module at x, y is dark. Modules outside the code belong to the light quiet zone.
+func (c *Code) Black(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y*c.Size+x]
+}
+
+func (c *Code) set(x, y int, dark, function bool) {
+	c.modules[y*c.Size+x] = dark
+	if function {
+		c.isFunction[y*c.Size+x] = true
+	}
+}
+
+func charCountBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// numRawDataModules returns the number of modules available for data and error correction in version.
+func numRawDataModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 - eccPerBlock[level][version]*numBlocks[level][version]
+}
+
+// alignmentPositions returns the centers of the alignment patterns in both directions.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*8 + n*3 + 5) / (n*4 - 4) * 2
+	positions := make([]int, n)
+	positions[0] = 6
+	for i, pos := n-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+func (c *Code) drawFunctionPatterns() {
+	// timing patterns
+	for i := 0; i < c.Size; i++ {
+		c.set(6, i, i%2 == 0, true)
+		c.set(i, 6, i%2 == 0, true)
+	}
+
+	// finder patterns with their separators
+	for _, corner := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				c.set(x, y, dist != 2 && dist != 4, true)
+			}
+		}
+	}
+
+	// alignment patterns, except where they would overlap the finder patterns
+	positions := alignmentPositions(c.Version)
+	last := len(positions) - 1
+	for i, cx := range positions {
+		for j, cy := range positions {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1, true)
+				}
+			}
+		}
+	}
+
+	// reserve the format information, it is drawn once the mask is chosen
+	c.drawFormatBits(0)
+
+	if c.Version >= 7 {
+		rem := c.Version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := c.Version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 == 1
+			a, b := c.Size-11+i%3, i/3
+			c.set(a, b, dark, true)
+			c.set(b, a, dark, true)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the format information for mask and the dark module.
+func (c *Code) drawFormatBits(mask int) {
+	data := formatBits[c.Level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	// around the top left finder pattern
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i), true)
+	}
+	c.set(8, 7, bit(6), true)
+	c.set(8, 8, bit(7), true)
+	c.set(7, 8, bit(8), true)
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i), true)
+	}
+
+	// split between the other finder patterns
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(i), true)
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(i), true)
+	}
+	c.set(8, c.Size-8, true, true)
+}
+
+/*
+addErrorCorrection splits data into blocks, appends the Reed-Solomon error correction to every block and interleaves
+the blocks.
+*/
+func (c *Code) addErrorCorrection(data []byte) []byte {
+	blocks := numBlocks[c.Level][c.Version]
+	eccLen := eccPerBlock[c.Level][c.Version]
+	raw := numRawDataModules(c.Version) / 8
+	short := blocks - raw%blocks
+	shortLen := raw / blocks
+
+	divisor := reedSolomonDivisor(eccLen)
+	split := make([][]byte, blocks)
+	for i, k := 0, 0; i < blocks; i++ {
+		n := shortLen - eccLen
+		if i >= short {
+			n++
+		}
+		block := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ecc := reedSolomonRemainder(block, divisor)
+		if i < short {
+			// short blocks get a placeholder, so all blocks can be interleaved at the same index
+			block = append(block, 0)
+		}
+		split[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, raw)
+	for i := 0; i < len(split[0]); i++ {
+		for j, block := range split {
+			if i != shortLen-eccLen || j >= short {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// drawCodewords places data in the zigzag order, two columns at a time from the bottom right corner.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// skip the vertical timing pattern
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.isFunction[y*c.Size+x] || i >= len(data)*8 {
+					continue
+				}
+				c.modules[y*c.Size+x] = data[i>>3]>>(7-i&7)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.isFunction[y*c.Size+x] {
+				c.modules[y*c.Size+x] = !c.modules[y*c.Size+x]
+			}
+		}
+	}
+}
+
+// penalty rates how hard the code is to read, the mask with the lowest penalty is used.
+func (c *Code) penalty() int {
+	p := 0
+	line := make([]bool, c.Size)
+	for _, vertical := range []bool{false, true} {
+		for a := 0; a < c.Size; a++ {
+			for b := range line {
+				if vertical {
+					line[b] = c.Black(a, b)
+				} else {
+					line[b] = c.Black(b, a)
+				}
+			}
+			p += linePenalty(line)
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Black(x, y) {
+				dark++
+			}
+			if x < c.Size-1 && y < c.Size-1 {
+				v := c.Black(x, y)
+				if v == c.Black(x+1, y) && v == c.Black(x, y+1) && v == c.Black(x+1, y+1) {
+					p += 3
+				}
+			}
+		}
+	}
+	total := c.Size * c.Size
+	p += (abs(dark*20-total*10)+total-1)/total*10 - 10
+	return p
+}
+
+// finderLike is a pattern looking like a finder pattern, preceded or followed by four light modules.
+var finderLike = []bool{true, false, true, true, true, false, true}
+
+// linePenalty rates runs of the same color and patterns looking like a finder pattern in a single row or column.
+func linePenalty(line []bool) int {
+	p := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			p += run - 2
+		}
+		run = 1
+	}
+
+	light := func(from, to int) bool {
+		for i := from; i < to; i++ {
+			if i >= 0 && i < len(line) && line[i] {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i+len(finderLike) <= len(line); i++ {
+		match := true
+		for j, dark := range finderLike {
+			if line[i+j] != dark {
+				match = false
+				break
+			}
+		}
+		if match && (light(i-4, i) || light(i+7, i+11)) {
+			p += 40
+		}
+	}
+	return p
+}
+
+// reedSolomonDivisor returns the generator polynomial of degree n, without its leading coefficient.
+func reedSolomonDivisor(n int) []byte {
+	result := make([]byte, n)
+	result[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < n {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, v>>i&1 == 1)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	result := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			result[i>>3] |= 0x80 >> (i & 7)
+		}
+	}
+	return result
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,182 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// reference is "1@go-whatsapp,qr" at level Medium with mask 1, as encoded by another QR code library.
+var reference = []string{
+	"#######.###.####..#######",
+	"#.....#....#...#..#.....#",
+	"#.###.#.#.##.#....#.###.#",
+	"#.###.#..#.##..#..#.###.#",
+	"#.###.#..##.#..##.#.###.#",
+	"#.....#.##...##...#.....#",
+	"#######.#.#.#.#.#.#######",
+	"..........##.#.#.........",
+	"#.#...##.#####.#...#..#.#",
+	"..###..#...##..#####.#..#",
+	".###.###.#.#.###.####...#",
+	"..###..#.####.#..###.#...",
+	".#.##.#.#.##.###..#..#.##",
+	".##.##..##...####.##.##..",
+	"##..#.##..##...####.#...#",
+	"..####.......#..##.....#.",
+	"####.###..####..######.##",
+	"........##..#...#...#####",
+	"#######.##..###.#.#.#.###",
+	"#.....#..#.##.#.#...##...",
+	"#.###.#..#...########..##",
+	"#.###.#......##..#..##.#.",
+	"#.###.#.#..#...##...#..##",
+	"#.....#...#..#.##....#...",
+	"#######.##.###..####.#..#",
+}
+
+func TestEncodeReference(t *testing.T) {
+	c, err := encode([]byte("1@go-whatsapp,qr"), Medium, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != 2 || c.Size != len(reference) {
+		t.Fatalf("unexpected version %d with size %d", c.Version, c.Size)
+	}
+	for y, row := range reference {
+		for x, m := range row {
+			if c.Black(x, y) != (m == '#') {
+				t.Fatalf("module %d,%d differs from the reference", x, y)
+			}
+		}
+	}
+}
+
+func TestEncodeVersions(t *testing.T) {
+	tests := []struct {
+		length  int
+		level   Level
+		version int
+	}{
+		{17, Low, 1},
+		{18, Low, 2},
+		{7, High, 1},
+		{8, High, 2},
+		{213, Medium, 10},
+		{214, Medium, 11},
+		{2953, Low, 40},
+		{1273, High, 40},
+	}
+	for _, tt := range tests {
+		c, err := Encode(bytes.Repeat([]byte{'a'}, tt.length), tt.level)
+		if err != nil {
+			t.Errorf("%d bytes at level %d: %v", tt.length, tt.level, err)
+			continue
+		}
+		if c.Version != tt.version || c.Size != tt.version*4+17 {
+			t.Errorf("%d bytes at level %d: got version %d, want %d", tt.length, tt.level, c.Version, tt.version)
+		}
+	}
+	if _, err := Encode(make([]byte, 2954), Low); !errors.Is(err, ErrTooLong) {
+		t.Errorf("expected ErrTooLong, got %v", err)
+	}
+	if _, err := Encode(nil, High+1); err == nil {
+		t.Error("encoded with an unknown level")
+	}
+}
+
+func TestEncodeFormatInformation(t *testing.T) {
+	// the ref,publicKey,clientId string scanned to log in
+	qr := "1@" + strings.Repeat("A", 90) + "," + strings.Repeat("B", 44) + "," + strings.Repeat("C", 24)
+	c, err := EncodeString(qr, Low)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both copies of the format information are the same
+	var first, second int
+	for i := 0; i < 15; i++ {
+		var a, b bool
+		switch {
+		case i < 6:
+			a = c.Black(8, i)
+		case i < 8:
+			a = c.Black(8, i+1)
+		case i == 8:
+			a = c.Black(7, 8)
+		default:
+			a = c.Black(14-i, 8)
+		}
+		if i < 8 {
+			b = c.Black(c.Size-1-i, 8)
+		} else {
+			b = c.Black(8, c.Size-15+i)
+		}
+		if a {
+			first |= 1 << i
+		}
+		if b {
+			second |= 1 << i
+		}
+	}
+	if first != second {
+		t.Errorf("format information differs: %015b, %015b", first, second)
+	}
+	if level := (first ^ 0x5412) >> 13; level != formatBits[Low] {
+		t.Errorf("format information encodes level bits %b", level)
+	}
+	if !c.Black(8, c.Size-8) {
+		t.Error("dark module missing")
+	}
+}
+
+func TestRender(t *testing.T) {
+	c, err := EncodeString("1@go-whatsapp,qr", Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := c.Size + 2*QuietZone
+
+	data, err := c.PNG(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid png: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != full*3 || b.Dy() != full*3 {
+		t.Errorf("unexpected png size %v", b)
+	}
+	dark := func(x, y int) bool {
+		r, _, _, _ := img.At(x, y).RGBA()
+		return r == 0
+	}
+	if dark(0, 0) || !dark(QuietZone*3, QuietZone*3) || !dark(QuietZone*3+2, QuietZone*3+2) {
+		t.Error("png does not show the quiet zone and the finder pattern")
+	}
+
+	svg := string(c.SVG())
+	if !strings.HasPrefix(svg, "<svg ") || !strings.Contains(svg, `viewBox="0 0 33 33"`) || !strings.HasSuffix(svg, "</svg>") {
+		t.Errorf("unexpected svg %q", svg)
+	}
+	// the top row of the finder patterns is a single run
+	if !strings.Contains(svg, "M4 4h7v1h-7z") {
+		t.Error("svg does not merge runs of dark modules")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(c.ASCII(), "\n"), "\n")
+	if len(lines) != full || len(lines[0]) != full*2 || !strings.HasPrefix(lines[QuietZone], strings.Repeat(" ", QuietZone*2)+"##############") {
+		t.Errorf("unexpected ascii rendering:\n%s", c.ASCII())
+	}
+
+	lines = strings.Split(strings.TrimSuffix(c.HalfBlock(), "\n"), "\n")
+	if len(lines) != (full+1)/2 || len([]rune(lines[0])) != full {
+		t.Errorf("unexpected half block rendering:\n%s", c.HalfBlock())
+	}
+	// the quiet zone is drawn light, the finder pattern dark with a light ring
+	if lines[0] != strings.Repeat("█", full) || !strings.HasPrefix(lines[2], "████ ▄▄▄▄▄ █") {
+		t.Errorf("unexpected half block rendering:\n%s", c.HalfBlock())
+	}
+}
@@ -0,0 +1,107 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// QuietZone is the width of the light border around the code, in modules, that every rendering includes.
+const QuietZone = 4
+
+// Image returns the code as an image with scale pixels per module.
+func (c *Code) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+	size := (c.Size + 2*QuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c.Black(x/scale-QuietZone, y/scale-QuietZone) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// PNG returns the code as a PNG image with scale pixels per module.
+func (c *Code) PNG(scale int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.Image(scale)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG returns the code as an SVG image, one unit per module. It scales to the size it is displayed with.
+func (c *Code) SVG() []byte {
+	size := c.Size + 2*QuietZone
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	buf.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; {
+			if !c.Black(x, y) {
+				x++
+				continue
+			}
+			// a single rectangle for every horizontal run of dark modules
+			run := 1
+			for c.Black(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", x+QuietZone, y+QuietZone, run, run)
+			x += run
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
+
+// ASCII returns the code as text, two characters per module: "##" for dark modules and spaces for light ones.
+func (c *Code) ASCII() string {
+	var b strings.Builder
+	for y := -QuietZone; y < c.Size+QuietZone; y++ {
+		for x := -QuietZone; x < c.Size+QuietZone; x++ {
+			if c.Black(x, y) {
+				b.WriteString("##")
+			} else {
+				b.WriteString("  ")
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+/*
+HalfBlock returns the code for terminals, using the Unicode half blocks to put two rows of modules into a single line.
+Light modules are drawn as blocks, so the code has the right colors on terminals with a light text on a dark
+background, which is what most terminals use.
+*/
+func (c *Code) HalfBlock() string {
+	var b strings.Builder
+	for y := -QuietZone; y < c.Size+QuietZone; y += 2 {
+		for x := -QuietZone; x < c.Size+QuietZone; x++ {
+			top := !c.Black(x, y)
+			bottom := !c.Black(x, y+1) && y+1 < c.Size+QuietZone
+			switch {
+			case top && bottom:
+				b.WriteRune('█')
+			case top:
+				b.WriteRune('▀')
+			case bottom:
+				b.WriteRune('▄')
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,164 @@
+package whatsapp
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// qualityWindow is the number of keep-alives the connection quality is computed from.
+const qualityWindow = 64
+
+/*
+ConnQuality describes the connection as measured by the keep-alives. Only the last keep-alives are taken into account,
+so the values follow changes of the network.
+*/
+type ConnQuality struct {
+	// KeepAlives is the number of answered keep-alives, Samples the number of them the values below are computed from.
+	KeepAlives uint64
+	Samples    int
+
+	// RTT is the round trip time of the last keep-alive, the others are computed over the samples.
+	RTT       time.Duration
+	MinRTT    time.Duration
+	MeanRTT   time.Duration
+	MedianRTT time.Duration
+	P90RTT    time.Duration
+	// RTTHistogram counts the samples, see Histogram.
+	RTTHistogram Histogram
+
+	// ClockSkew is the estimated difference between the server clock and the local clock. It is positive if the
+	// server clock is ahead. ServerTime applies it.
+	ClockSkew time.Duration
+
+	// LastFrame is the time the last frame was received, Stalled is set while no frame arrived in time.
+	LastFrame time.Time
+	Stalled   bool
+}
+
+type rttSample struct {
+	rtt  time.Duration
+	skew time.Duration
+}
+
+type qualityMonitor struct {
+	lastFrame int64 // unix nanoseconds, accessed atomically
+	stalled   atomicBool
+
+	mu         sync.Mutex
+	samples    [qualityWindow]rttSample
+	n          int // number of valid samples
+	next       int // index the next sample is written to
+	keepAlives uint64
+	last       time.Duration
+	skew       time.Duration
+}
+
+/*
+keepAliveAnswered records the answer to a keep-alive sent at sent, carrying the server time serverTime. The clock skew
+is taken from the sample with the shortest round trip time, whose server time is the least distorted by the network.
+*/
+func (q *qualityMonitor) keepAliveAnswered(sent time.Time, rtt time.Duration, serverTime time.Time) {
+	// the server answered half way through the round trip
+	skew := serverTime.Sub(sent.Add(rtt / 2))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.samples[q.next] = rttSample{rtt: rtt, skew: skew}
+	q.next = (q.next + 1) % qualityWindow
+	if q.n < qualityWindow {
+		q.n++
+	}
+	q.keepAlives++
+	q.last = rtt
+
+	best := q.samples[0]
+	for _, s := range q.samples[1:q.n] {
+		if s.rtt < best.rtt {
+			best = s
+		}
+	}
+	q.skew = best.skew
+}
+
+func (q *qualityMonitor) clockSkew() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.skew
+}
+
+// frameReceived is called for every received frame, it ends a stall.
+func (q *qualityMonitor) frameReceived(now time.Time) (recovered bool) {
+	atomic.StoreInt64(&q.lastFrame, now.UnixNano())
+	return q.stalled.CompareAndSwap(true, false)
+}
+
+// checkStall reports whether no frame arrived for threshold. It only reports the start of a stall once.
+func (q *qualityMonitor) checkStall(now time.Time, threshold time.Duration) (since time.Time, stalled bool) {
+	since = time.Unix(0, atomic.LoadInt64(&q.lastFrame))
+	if threshold <= 0 || now.Sub(since) < threshold {
+		return since, false
+	}
+	return since, q.stalled.CompareAndSwap(false, true)
+}
+
+func (q *qualityMonitor) snapshot() ConnQuality {
+	q.mu.Lock()
+	rtts := make([]time.Duration, q.n)
+	for i := range rtts {
+		rtts[i] = q.samples[i].rtt
+	}
+	c := ConnQuality{KeepAlives: q.keepAlives, Samples: q.n, RTT: q.last, ClockSkew: q.skew}
+	q.mu.Unlock()
+
+	if last := atomic.LoadInt64(&q.lastFrame); last != 0 {
+		c.LastFrame = time.Unix(0, last)
+	}
+	c.Stalled = q.stalled.Load()
+	c.RTTHistogram = newHistogram()
+	if len(rtts) == 0 {
+		return c
+	}
+
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	var sum time.Duration
+	for _, rtt := range rtts {
+		sum += rtt
+		c.RTTHistogram.observe(rtt)
+	}
+	c.MinRTT = rtts[0]
+	c.MeanRTT = sum / time.Duration(len(rtts))
+	c.MedianRTT = rtts[len(rtts)/2]
+	c.P90RTT = rtts[len(rtts)*9/10]
+	return c
+}
+
+// Quality returns the connection quality measured by the keep-alives of the current and previous connections.
+func (wac *Conn) Quality() ConnQuality {
+	return wac.quality.snapshot()
+}
+
+/*
+ServerTime returns the current time of the WhatsApp servers, estimated from the keep-alives. It is the local time
+until the first keep-alive was answered.
+*/
+func (wac *Conn) ServerTime() time.Time {
+	return time.Now().Add(wac.quality.clockSkew())
+}
+
+/*
+checkStall emits a Stalled ConnectionEvent if no frame was received for stallIntervals keep-alive intervals. The
+keep-alive loop calls it before every keep-alive.
+*/
+func (wac *Conn) checkStall(interval time.Duration) {
+	if wac.stallIntervals <= 0 {
+		return
+	}
+	since, stalled := wac.quality.checkStall(time.Now(), time.Duration(wac.stallIntervals)*interval)
+	if !stalled {
+		return
+	}
+	wac.logger().Warn("connection stalled", "last_frame", since)
+	wac.handle(ConnectionEvent{Type: Stalled, Since: since})
+}
@@ -0,0 +1,77 @@
+package whatsapp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQualityClockSkewFromFastestSample(t *testing.T) {
+	var q qualityMonitor
+	sent := time.Now()
+	// a slow sample whose answer was delayed on the way back distorts the skew, the fast one does not
+	q.keepAliveAnswered(sent, 800*time.Millisecond, sent.Add(time.Hour+100*time.Millisecond))
+	q.keepAliveAnswered(sent, 20*time.Millisecond, sent.Add(time.Hour+10*time.Millisecond))
+	q.keepAliveAnswered(sent, 300*time.Millisecond, sent.Add(time.Hour))
+
+	if skew := q.clockSkew(); skew != time.Hour {
+		t.Errorf("expected a skew of 1h, got %v", skew)
+	}
+
+	c := q.snapshot()
+	if c.KeepAlives != 3 || c.Samples != 3 || c.RTT != 300*time.Millisecond {
+		t.Errorf("unexpected counts %+v", c)
+	}
+	if c.MinRTT != 20*time.Millisecond || c.MedianRTT != 300*time.Millisecond || c.P90RTT != 800*time.Millisecond {
+		t.Errorf("unexpected percentiles %+v", c)
+	}
+	if c.MeanRTT != 1120*time.Millisecond/3 || c.RTTHistogram.Count != 3 {
+		t.Errorf("unexpected mean or histogram %+v", c)
+	}
+}
+
+func TestQualityWindow(t *testing.T) {
+	var q qualityMonitor
+	sent := time.Now()
+	q.keepAliveAnswered(sent, time.Millisecond, sent.Add(time.Minute))
+	for i := 0; i < qualityWindow; i++ {
+		q.keepAliveAnswered(sent, 50*time.Millisecond, sent.Add(25*time.Millisecond))
+	}
+	if c := q.snapshot(); c.Samples != qualityWindow || c.MinRTT != 50*time.Millisecond || c.ClockSkew != 0 {
+		t.Errorf("the oldest sample was not dropped: %+v", c)
+	}
+}
+
+func TestQualityStall(t *testing.T) {
+	var q qualityMonitor
+	now := time.Now()
+	q.frameReceived(now)
+
+	if _, stalled := q.checkStall(now.Add(time.Second), 3*time.Second); stalled {
+		t.Error("stalled too early")
+	}
+	since, stalled := q.checkStall(now.Add(3*time.Second), 3*time.Second)
+	if !stalled || !since.Equal(time.Unix(0, now.UnixNano())) {
+		t.Errorf("expected a stall since %v, got %v %v", now, stalled, since)
+	}
+	if _, stalled := q.checkStall(now.Add(4*time.Second), 3*time.Second); stalled {
+		t.Error("stall reported twice")
+	}
+	if !q.frameReceived(now.Add(5 * time.Second)) {
+		t.Error("recovery not reported")
+	}
+	if _, stalled := q.checkStall(now.Add(6*time.Second), 0); stalled {
+		t.Error("stall detected although disabled")
+	}
+}
+
+func TestInfoProtoUsesGivenTime(t *testing.T) {
+	now := time.Now().Add(time.Hour)
+	p := getInfoProto(&MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, now)
+	if p.GetMessageTimestamp() != uint64(now.Unix()) {
+		t.Errorf("expected timestamp %d, got %d", now.Unix(), p.GetMessageTimestamp())
+	}
+	p = getInfoProto(&MessageInfo{Timestamp: 42}, now)
+	if p.GetMessageTimestamp() != 42 {
+		t.Errorf("given timestamp replaced: %d", p.GetMessageTimestamp())
+	}
+}
@@ -0,0 +1,292 @@
+package whatsapp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Priority classifies outgoing messages for the send queue.
+type Priority int
+
+const (
+	// PriorityInteractive is the default. Interactive messages are always sent before waiting bulk messages.
+	PriorityInteractive Priority = iota
+	// PriorityBulk is meant for broadcasts and other mass sends that must not delay interactive replies.
+	PriorityBulk
+)
+
+type priorityKey struct{}
+
+/*
+WithPriority returns a context that makes SendContext queue the message with the given priority.
+*/
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p == PriorityBulk {
+		return PriorityBulk
+	}
+	return PriorityInteractive
+}
+
+// QueuePolicy decides what happens to a message that can not be sent right away because of the rate limit.
+type QueuePolicy int
+
+const (
+	// QueueBlock waits for the rate limit until the context of the send is done.
+	QueueBlock QueuePolicy = iota
+	// QueueFailFast returns ErrRateLimited instead of waiting.
+	QueueFailFast
+)
+
+/*
+RateLimit configures the outbound queue all relayed messages pass through. Rates are messages per second, a rate of 0
+disables the respective limit.
+*/
+type RateLimit struct {
+	// Rate and Burst limit the messages sent over the connection.
+	Rate  float64
+	Burst int
+	// PerChatRate and PerChatBurst limit the messages sent to a single jid.
+	PerChatRate  float64
+	PerChatBurst int
+	// MaxQueued limits the number of messages waiting for the rate limit, further sends fail with ErrQueueFull.
+	// 0 means unlimited.
+	MaxQueued int
+	Policy    QueuePolicy
+}
+
+// QueueStats is a snapshot of the outbound queue.
+type QueueStats struct {
+	// Interactive and Bulk are the numbers of messages currently waiting.
+	Interactive int
+	Bulk        int
+	// Sent and Rejected count the messages that passed or were refused by the queue.
+	Sent     uint64
+	Rejected uint64
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	used   time.Time // last time a sender asked for the bucket
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now, used: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// delay returns how long to wait for the next token. It has to be called after refill.
+func (b *tokenBucket) delay() time.Duration {
+	if b == nil || b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take() {
+	if b != nil {
+		b.tokens--
+	}
+}
+
+func (b *tokenBucket) full() bool {
+	return b.tokens >= b.burst
+}
+
+/*
+maxChatBuckets is the number of per-chat buckets kept. Beyond it full buckets are dropped first, then the least
+recently used one.
+*/
+const maxChatBuckets = 1024
+
+type outboundQueue struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	global *tokenBucket
+	chats  map[string]*tokenBucket
+	// waiting counts the senders per priority, globalWaiting the interactive ones held back by the global bucket
+	waiting       [2]int
+	globalWaiting int
+	wake          chan struct{}
+	sent          uint64
+	rejected      uint64
+}
+
+func newOutboundQueue(limit RateLimit) *outboundQueue {
+	q := &outboundQueue{
+		limit: limit,
+		chats: make(map[string]*tokenBucket),
+		wake:  make(chan struct{}),
+	}
+	if limit.Rate > 0 {
+		q.global = newTokenBucket(limit.Rate, limit.Burst, time.Now())
+	}
+	return q
+}
+
+// broadcast wakes up all waiting senders. q.mu must be held.
+func (q *outboundQueue) broadcast() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// chatBucket returns the bucket of the given jid. q.mu must be held.
+func (q *outboundQueue) chatBucket(jid string, now time.Time) *tokenBucket {
+	if q.limit.PerChatRate <= 0 || jid == "" {
+		return nil
+	}
+	b, ok := q.chats[jid]
+	if !ok {
+		if len(q.chats) >= maxChatBuckets {
+			q.evictChatBuckets(now)
+		}
+		b = newTokenBucket(q.limit.PerChatRate, q.limit.PerChatBurst, now)
+		q.chats[jid] = b
+	}
+	b.used = now
+	return b
+}
+
+// evictChatBuckets makes room for a new per-chat bucket. q.mu must be held.
+func (q *outboundQueue) evictChatBuckets(now time.Time) {
+	var oldest string
+	for k, v := range q.chats {
+		if v.refill(now); v.full() {
+			delete(q.chats, k)
+		} else if oldest == "" || v.used.Before(q.chats[oldest].used) {
+			oldest = k
+		}
+	}
+	if len(q.chats) >= maxChatBuckets {
+		delete(q.chats, oldest)
+	}
+}
+
+/*
+acquire blocks until a message to jid may be sent according to the rate limits and priorities, or fails according to
+the queue policy. Bulk messages are only held back by interactive ones waiting for the global bucket, an interactive
+message waiting for its own chat does not stall other chats.
+*/
+func (q *outboundQueue) acquire(ctx context.Context, jid string, p Priority) error {
+	q.mu.Lock()
+	if q.limit.MaxQueued > 0 && q.waiting[PriorityInteractive]+q.waiting[PriorityBulk] >= q.limit.MaxQueued {
+		q.rejected++
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+	q.waiting[p]++
+	globalWaiting := false // whether this sender is counted in q.globalWaiting
+	done := func() {
+		q.waiting[p]--
+		if globalWaiting {
+			q.globalWaiting--
+		}
+		q.broadcast()
+	}
+
+	for {
+		now := time.Now()
+		chat := q.chatBucket(jid, now)
+		q.global.refill(now)
+		chat.refill(now)
+
+		wait := time.Duration(-1) // wait until woken up
+		if p == PriorityInteractive || q.globalWaiting == 0 {
+			wait = q.global.delay()
+			if d := chat.delay(); d > wait {
+				wait = d
+			}
+		}
+
+		if wait == 0 {
+			q.global.take()
+			chat.take()
+			q.sent++
+			done()
+			q.mu.Unlock()
+			return nil
+		}
+
+		if q.limit.Policy == QueueFailFast {
+			q.rejected++
+			done()
+			q.mu.Unlock()
+			return ErrRateLimited
+		}
+
+		if p == PriorityInteractive && globalWaiting != (q.global.delay() > 0) {
+			globalWaiting = !globalWaiting
+			if globalWaiting {
+				q.globalWaiting++
+			} else {
+				q.globalWaiting--
+				q.broadcast() // bulk senders may go on
+			}
+		}
+
+		wake := q.wake
+		q.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-timeout:
+		case <-wake:
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			q.mu.Lock()
+			done()
+			q.mu.Unlock()
+			return ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		q.mu.Lock()
+	}
+}
+
+func (q *outboundQueue) stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QueueStats{
+		Interactive: q.waiting[PriorityInteractive],
+		Bulk:        q.waiting[PriorityBulk],
+		Sent:        q.sent,
+		Rejected:    q.rejected,
+	}
+}
+
+// QueueStats returns a snapshot of the outbound queue. It is empty if no rate limit is configured.
+func (wac *Conn) QueueStats() QueueStats {
+	if wac.outbound == nil {
+		return QueueStats{}
+	}
+	return wac.outbound.stats()
+}
@@ -0,0 +1,162 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOutboundQueueBurstAndRate(t *testing.T) {
+	q := newOutboundQueue(RateLimit{Rate: 20, Burst: 3})
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := q.acquire(context.Background(), "a@s.whatsapp.net", PriorityInteractive); err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+	}
+	// 3 messages fit into the burst, the remaining 2 need 50ms each
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("rate limit not applied, 5 messages took %v", elapsed)
+	}
+	if s := q.stats(); s.Sent != 5 || s.Interactive != 0 || s.Bulk != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestOutboundQueuePerChat(t *testing.T) {
+	q := newOutboundQueue(RateLimit{PerChatRate: 1, PerChatBurst: 1, Policy: QueueFailFast})
+
+	if err := q.acquire(context.Background(), "a@s.whatsapp.net", PriorityInteractive); err != nil {
+		t.Fatalf("first message to a: %v", err)
+	}
+	if err := q.acquire(context.Background(), "b@s.whatsapp.net", PriorityInteractive); err != nil {
+		t.Fatalf("first message to b: %v", err)
+	}
+	if err := q.acquire(context.Background(), "a@s.whatsapp.net", PriorityInteractive); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited for second message to a, got %v", err)
+	}
+	if s := q.stats(); s.Sent != 2 || s.Rejected != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestOutboundQueueInteractiveFirst(t *testing.T) {
+	q := newOutboundQueue(RateLimit{Rate: 10, Burst: 1})
+	if err := q.acquire(context.Background(), "", PriorityBulk); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []Priority
+	var wg sync.WaitGroup
+	send := func(p Priority) {
+		defer wg.Done()
+		if err := q.acquire(context.Background(), "", p); err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		order = append(order, p)
+		mu.Unlock()
+	}
+
+	wg.Add(1)
+	go send(PriorityBulk)
+	waitFor(t, func() bool { return q.stats().Bulk == 1 })
+	wg.Add(2)
+	go send(PriorityInteractive)
+	go send(PriorityInteractive)
+	wg.Wait()
+
+	if len(order) != 3 || order[0] != PriorityInteractive || order[1] != PriorityInteractive || order[2] != PriorityBulk {
+		t.Errorf("bulk message overtook interactive ones: %v", order)
+	}
+}
+
+func TestOutboundQueueMaxQueued(t *testing.T) {
+	q := newOutboundQueue(RateLimit{Rate: 1, Burst: 1, MaxQueued: 1})
+	if err := q.acquire(context.Background(), "", PriorityInteractive); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- q.acquire(ctx, "", PriorityInteractive) }()
+	waitFor(t, func() bool { return q.stats().Interactive == 1 })
+
+	if err := q.acquire(context.Background(), "", PriorityInteractive); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if s := q.stats(); s.Interactive != 0 || s.Rejected != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestPriorityFromContext(t *testing.T) {
+	if p := priorityFromContext(context.Background()); p != PriorityInteractive {
+		t.Errorf("expected interactive default, got %v", p)
+	}
+	if p := priorityFromContext(WithPriority(context.Background(), PriorityBulk)); p != PriorityBulk {
+		t.Errorf("expected bulk, got %v", p)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOutboundQueueBulkNotStalledByChatLimit(t *testing.T) {
+	q := newOutboundQueue(RateLimit{PerChatRate: 1, PerChatBurst: 1})
+	if err := q.acquire(context.Background(), "a@s.whatsapp.net", PriorityInteractive); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.acquire(ctx, "a@s.whatsapp.net", PriorityInteractive)
+	waitFor(t, func() bool { return q.stats().Interactive == 1 })
+
+	bulkCtx, bulkCancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer bulkCancel()
+	if err := q.acquire(bulkCtx, "b@s.whatsapp.net", PriorityBulk); err != nil {
+		t.Errorf("bulk message to another chat was held back: %v", err)
+	}
+
+	failFast := newOutboundQueue(RateLimit{PerChatRate: 1, PerChatBurst: 1, Policy: QueueFailFast})
+	failFast.acquire(context.Background(), "a@s.whatsapp.net", PriorityInteractive)
+	failFast.waiting[PriorityInteractive]++ // an interactive message waits for chat a only
+	if err := failFast.acquire(context.Background(), "b@s.whatsapp.net", PriorityBulk); err != nil {
+		t.Errorf("bulk message rejected although tokens are available: %v", err)
+	}
+}
+
+func TestOutboundQueueEvictsChatBuckets(t *testing.T) {
+	q := newOutboundQueue(RateLimit{PerChatRate: 0.001, PerChatBurst: 1})
+	for i := 0; i < maxChatBuckets+10; i++ {
+		if err := q.acquire(context.Background(), fmt.Sprintf("%d@s.whatsapp.net", i), PriorityBulk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(q.chats) > maxChatBuckets {
+		t.Errorf("%d per-chat buckets kept", len(q.chats))
+	}
+	if _, ok := q.chats["0@s.whatsapp.net"]; ok {
+		t.Error("least recently used bucket was kept")
+	}
+}
@@ -4,10 +4,10 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 
@@ -15,47 +15,58 @@ import (
 	"github.com/cristalinojr/go-whatsapp/crypto/cbc"
 )
 
-func (wac *Conn) readPump() {
+func (wac *Conn) readPump(ws *websocketWrapper) {
+	var lostErr error
 	defer func() {
-		wac.wg.Done()
-		_, _ = wac.Disconnect()
+		ws.wg.Done()
+		if _, err := wac.disconnectWebsocket(ws); err == nil && lostErr != nil {
+			wac.connectionLost(lostErr)
+		}
 	}()
 
 	var readErr error
 	var msgType int
-	var reader io.Reader
+	var msg []byte
 
 	for {
 		readerFound := make(chan struct{})
 		go func() {
-			if wac.ws != nil {
-				msgType, reader, readErr = wac.ws.conn.NextReader()
-			}
+			msgType, msg, readErr = ws.conn.ReadFrame()
 			close(readerFound)
 		}()
 		select {
 		case <-readerFound:
 			if readErr != nil {
+				var closed *ErrConnectionClosed
+				if errors.As(readErr, &closed) {
+					wac.handle(closed)
+				}
+				wac.logger().Warn("connection lost", "err", readErr)
 				wac.handle(&ErrConnectionFailed{Err: readErr})
+				lostErr = readErr
 				return
 			}
-			msg, err := ioutil.ReadAll(reader)
-			if err != nil {
-				wac.handle(fmt.Errorf("error reading message from Reader: %w", err))
-				continue
-			}
-			err = wac.processReadData(msgType, msg)
+			err := wac.processReadData(msgType, msg)
 			if err != nil {
+				wac.logger().Warn("error processing frame", "err", err)
 				wac.handle(fmt.Errorf("error processing data: %w", err))
 			}
-		case <-wac.ws.close:
+		case <-ws.close:
 			return
 		}
 	}
 }
 
 func (wac *Conn) processReadData(msgType int, msg []byte) error {
+	wac.observer().FrameReceived(msgType == websocket.BinaryMessage, len(msg))
+	if wac.quality.frameReceived(time.Now()) {
+		wac.logger().Info("connection recovered from stall")
+	}
 	data := strings.SplitN(string(msg), ",", 2)
+	if len(data[0]) == 0 {
+		wac.observer().DecodeError(ErrInvalidWsData)
+		return ErrInvalidWsData
+	}
 
 	if data[0][0] == '!' { //Keep-Alive Timestamp
 		data = append(data, data[0][1:]) //data[1]
@@ -63,46 +74,41 @@ func (wac *Conn) processReadData(msgType int, msg []byte) error {
 	}
 
 	if len(data) != 2 || len(data[1]) == 0 {
+		wac.observer().DecodeError(ErrInvalidWsData)
 		return ErrInvalidWsData
 	}
 
-	wac.listener.RLock()
-	listener, hasListener := wac.listener.m[data[0]]
-	wac.listener.RUnlock()
-
+	listener, hasListener := wac.takeListener(data[0])
+	wac.logger().Debug("received frame", "tag", data[0], "binary", msgType == websocket.BinaryMessage, "response", hasListener)
 	if hasListener {
-		// listener only exists for TextMessages query messages out of contact.go
-		// If these binary query messages can be handled another way,
-		// then the TextMessages, which are all JSON encoded, can directly
-		// be unmarshalled. The listener chan could then be changed from type
-		// chan string to something like chan map[string]interface{}. The unmarshalling
-		// in several places, especially in session.go, would then be gone.
+		// listeners only receive the raw payload, it is decoded by the request layer in request.go
 		listener <- data[1]
-
-		wac.listener.Lock()
-		delete(wac.listener.m, data[0])
-		wac.listener.Unlock()
 	} else if msgType == websocket.BinaryMessage {
-		wac.loginSessionLock.RLock()
-		sess := wac.session
-		wac.loginSessionLock.RUnlock()
+		sess := wac.getSession()
 		if sess == nil || sess.MacKey == nil || sess.EncKey == nil {
 			return ErrInvalidWsState
 		}
 		message, err := wac.decryptBinaryMessage([]byte(data[1]))
 		if err != nil {
+			wac.observer().DecodeError(err)
 			return fmt.Errorf("error decoding binary: %w", err)
 		}
 		wac.dispatch(message)
 	} else { //RAW json status updates
+		wac.handleServerFrame(data[1])
 		wac.handle(string(data[1]))
 	}
 	return nil
 }
 
 func (wac *Conn) decryptBinaryMessage(msg []byte) (*binary.Node, error) {
+	sess := wac.getSession()
+	if sess == nil {
+		return nil, ErrInvalidSession
+	}
+
 	//message validation
-	h2 := hmac.New(sha256.New, wac.session.MacKey)
+	h2 := hmac.New(sha256.New, sess.MacKey)
 	if len(msg) < 33 {
 		var response struct {
 			Status int `json:"status"`
@@ -124,7 +130,7 @@ func (wac *Conn) decryptBinaryMessage(msg []byte) (*binary.Node, error) {
 	}
 
 	// message decrypt
-	d, err := cbc.Decrypt(wac.session.EncKey, nil, msg[32:])
+	d, err := cbc.Decrypt(sess.EncKey, nil, msg[32:])
 	if err != nil {
 		return nil, fmt.Errorf("decrypting message with AES-CBC failed: %w", err)
 	}
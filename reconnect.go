@@ -0,0 +1,256 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+/*
+ReconnectConfig configures the reconnect supervisor enabled with SetAutoReconnect. Unset durations, multiplier and
+keep-alive failures are replaced by the defaults of DefaultReconnectConfig. A Jitter of 0 disables jitter.
+*/
+type ReconnectConfig struct {
+	// MinBackoff is the delay before the first reconnect attempt.
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay between two reconnect attempts.
+	MaxBackoff time.Duration
+	// Multiplier is applied to the delay after every failed attempt.
+	Multiplier float64
+	// Jitter is the fraction (0 to 1) by which every delay is randomly shortened.
+	Jitter float64
+	// MaxAttempts limits the number of attempts per connection loss. 0 means unlimited.
+	MaxAttempts int
+	// KeepAliveFailures is the number of consecutive failed keep-alives after which the socket is considered dead.
+	KeepAliveFailures int
+}
+
+// DefaultReconnectConfig returns the configuration used for unset ReconnectConfig fields.
+func DefaultReconnectConfig() ReconnectConfig {
+	return ReconnectConfig{
+		MinBackoff:        time.Second,
+		MaxBackoff:        2 * time.Minute,
+		Multiplier:        2,
+		Jitter:            0.2,
+		KeepAliveFailures: 3,
+	}
+}
+
+func (cfg ReconnectConfig) withDefaults() ReconnectConfig {
+	def := DefaultReconnectConfig()
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = def.MinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = def.MaxBackoff
+		if cfg.MaxBackoff < cfg.MinBackoff {
+			cfg.MaxBackoff = cfg.MinBackoff
+		}
+	}
+	if cfg.Multiplier < 1 {
+		cfg.Multiplier = def.Multiplier
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 1 {
+		cfg.Jitter = def.Jitter
+	}
+	if cfg.KeepAliveFailures <= 0 {
+		cfg.KeepAliveFailures = def.KeepAliveFailures
+	}
+	return cfg
+}
+
+// backoff returns the delay before the given attempt (starting at 1), including jitter.
+func (cfg ReconnectConfig) backoff(attempt int) time.Duration {
+	d := float64(cfg.MinBackoff)
+	for i := 1; i < attempt && d < float64(cfg.MaxBackoff); i++ {
+		d *= cfg.Multiplier
+	}
+	if d > float64(cfg.MaxBackoff) {
+		d = float64(cfg.MaxBackoff)
+	}
+	d -= d * cfg.Jitter * rand.Float64()
+	return time.Duration(d)
+}
+
+type ConnectionEventType int
+
+const (
+	// ConnectionLost is emitted when the socket died without Disconnect being called.
+	ConnectionLost ConnectionEventType = iota
+	// Reconnecting is emitted before every reconnect attempt, after which the supervisor waits Delay.
+	Reconnecting
+	// Reconnected is emitted after the session was restored successfully.
+	Reconnected
+	// ReconnectFailed is emitted when the supervisor gives up. Err holds the reason.
+	ReconnectFailed
+	// Stalled is emitted when no frame was received for the configured number of keep-alive intervals, see
+	// WithStallDetection. Since is the time the last frame arrived.
+	Stalled
+)
+
+func (t ConnectionEventType) String() string {
+	switch t {
+	case ConnectionLost:
+		return "connection lost"
+	case Reconnecting:
+		return "reconnecting"
+	case Reconnected:
+		return "reconnected"
+	case ReconnectFailed:
+		return "reconnect failed"
+	case Stalled:
+		return "stalled"
+	}
+	return "unknown"
+}
+
+/*
+ConnectionEvent describes a change in the connection lifecycle observed by the reconnect supervisor or the keep-alive.
+It is dispatched to every handler implementing ConnectionEventHandler.
+*/
+type ConnectionEvent struct {
+	Type    ConnectionEventType
+	Attempt int
+	Delay   time.Duration
+	Err     error
+	Since   time.Time
+}
+
+/*
+SetAutoReconnect enables the reconnect supervisor. When the websocket dies or keep-alives fail repeatedly, the
+supervisor reconnects with jittered exponential backoff, restores the session (resolving a challenge if needed) and
+subscribes to the presence of all jids previously passed to SubscribePresence. It stops permanently if the session was
+unpaired or replaced, and does not start at all after the server disconnected a replaced or remotely logged out
+session. Passing nil disables the supervisor.
+*/
+func (wac *Conn) SetAutoReconnect(cfg *ReconnectConfig) {
+	wac.reconnectLock.Lock()
+	defer wac.reconnectLock.Unlock()
+
+	if cfg == nil {
+		wac.reconnect = nil
+		return
+	}
+	c := cfg.withDefaults()
+	wac.reconnect = &c
+	if wac.reconnectStop == nil {
+		wac.reconnectStop = make(chan struct{})
+	}
+}
+
+// stopReconnect aborts a running supervisor, e.g. because the user disconnected on purpose.
+func (wac *Conn) stopReconnect() {
+	wac.reconnectLock.Lock()
+	defer wac.reconnectLock.Unlock()
+
+	if wac.reconnectStop != nil {
+		close(wac.reconnectStop)
+		wac.reconnectStop = make(chan struct{})
+	}
+}
+
+func (wac *Conn) keepAliveFailureLimit() int {
+	wac.reconnectLock.Lock()
+	defer wac.reconnectLock.Unlock()
+
+	if wac.reconnect == nil {
+		return 0
+	}
+	return wac.reconnect.KeepAliveFailures
+}
+
+// connectionLost is called by readPump after the socket died and the connection was torn down.
+func (wac *Conn) connectionLost(err error) {
+	if s := wac.State(); s == StateReplaced || s == StateLoggedOut {
+		// the server closed a session that can't be restored
+		return
+	}
+	session := wac.getSession()
+	wac.reconnectLock.Lock()
+	if wac.reconnect == nil || wac.reconnecting || session == nil || session.EncKey == nil {
+		wac.reconnectLock.Unlock()
+		return
+	}
+	cfg := *wac.reconnect
+	stop := wac.reconnectStop
+	wac.reconnecting = true
+	wac.reconnectLock.Unlock()
+
+	go wac.superviseReconnect(cfg, stop, err)
+}
+
+func (wac *Conn) superviseReconnect(cfg ReconnectConfig, stop <-chan struct{}, cause error) {
+	defer func() {
+		wac.reconnectLock.Lock()
+		wac.reconnecting = false
+		wac.reconnectLock.Unlock()
+	}()
+
+	wac.handle(ConnectionEvent{Type: ConnectionLost, Err: cause})
+
+	lastErr := cause
+	for attempt := 1; cfg.MaxAttempts <= 0 || attempt <= cfg.MaxAttempts; attempt++ {
+		delay := cfg.backoff(attempt)
+		wac.logger().Info("reconnecting", "attempt", attempt, "delay", delay, "err", lastErr)
+		wac.setState(StateReconnecting, lastErr)
+		wac.handle(ConnectionEvent{Type: Reconnecting, Attempt: attempt, Delay: delay, Err: lastErr})
+
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return
+		}
+
+		err := wac.Restore()
+		if err == nil || err == ErrAlreadyLoggedIn {
+			select {
+			case <-stop:
+				// Disconnect was called while restoring
+				_, _ = wac.disconnect()
+				return
+			default:
+			}
+			wac.logger().Info("reconnected", "attempt", attempt)
+			wac.resubscribePresence()
+			wac.handle(ConnectionEvent{Type: Reconnected, Attempt: attempt})
+			return
+		}
+		lastErr = err
+
+		if errors.Is(err, ErrUnpaired) || errors.Is(err, ErrReplaced) {
+			break
+		}
+		if wac.connected.Load() {
+			_, _ = wac.disconnect()
+		}
+	}
+
+	wac.logger().Error("reconnect failed", "err", lastErr)
+	wac.handle(ConnectionEvent{Type: ReconnectFailed, Err: lastErr})
+}
+
+func (wac *Conn) trackPresenceSubscription(jid string) {
+	wac.reconnectLock.Lock()
+	defer wac.reconnectLock.Unlock()
+
+	if wac.presenceSubs == nil {
+		wac.presenceSubs = make(map[string]struct{})
+	}
+	wac.presenceSubs[jid] = struct{}{}
+}
+
+func (wac *Conn) resubscribePresence() {
+	wac.reconnectLock.Lock()
+	jids := make([]string, 0, len(wac.presenceSubs))
+	for jid := range wac.presenceSubs {
+		jids = append(jids, jid)
+	}
+	wac.reconnectLock.Unlock()
+
+	for _, jid := range jids {
+		if err := wac.SubscribePresenceContext(context.Background(), jid); err != nil {
+			wac.handle(err)
+		}
+	}
+}
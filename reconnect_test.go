@@ -0,0 +1,103 @@
+package whatsapp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	cfg := ReconnectConfig{
+		MinBackoff: time.Second,
+		MaxBackoff: 10 * time.Second,
+		Multiplier: 2,
+	}.withDefaults()
+	cfg.Jitter = 0
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := cfg.backoff(i + 1); got != w {
+			t.Errorf("attempt %d: got %v, want %v", i+1, got, w)
+		}
+	}
+
+	cfg.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := cfg.backoff(3); got < 2*time.Second || got > 4*time.Second {
+			t.Fatalf("jittered backoff out of range: %v", got)
+		}
+	}
+}
+
+func TestReconnectConfigDefaults(t *testing.T) {
+	want := DefaultReconnectConfig()
+	want.Jitter = 0
+	if cfg := (ReconnectConfig{}).withDefaults(); cfg != want {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+}
+
+type reconnectRecorder struct {
+	events chan ConnectionEvent
+}
+
+func (r *reconnectRecorder) ShouldCallSynchronously() bool { return true }
+func (r *reconnectRecorder) HandleError(err error)         {}
+func (r *reconnectRecorder) HandleConnectionEvent(event ConnectionEvent) {
+	r.events <- event
+}
+
+func TestReconnectSupervisor(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac, err := NewConnWithOptions(WithTransport(&WebsocketTransport{URL: srv.URL}), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+	wac.SetAutoReconnect(&ReconnectConfig{MinBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond})
+	recorder := &reconnectRecorder{events: make(chan ConnectionEvent, 16)}
+	wac.AddHandler(recorder)
+
+	if _, err := wac.RestoreWithSession(Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	const jid = "4915100000000@s.whatsapp.net"
+	if err := wac.SubscribePresenceContext(context.Background(), jid); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.DropConnections()
+	for _, want := range []ConnectionEventType{ConnectionLost, Reconnecting, Reconnected} {
+		select {
+		case event := <-recorder.events:
+			if event.Type != want {
+				t.Fatalf("expected %v, got %+v", want, event)
+			}
+			if want == Reconnecting && (event.Attempt != 1 || event.Err == nil) {
+				t.Errorf("unexpected %+v", event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %v event", want)
+		}
+	}
+
+	// the session was restored with the tokens the server rotated on the restore
+	creds := srv.Credentials()
+	if session := wac.getSession(); !wac.GetLoggedIn() || session == nil || session.ClientToken != creds.ClientToken || session.ServerToken != creds.ServerToken {
+		t.Fatalf("session not restored: %+v", session)
+	}
+
+	// the subscription was sent again on the new connection
+	subscriptions := 0
+	if _, err := srv.WaitForFrame(2*time.Second, func(f whatsapptest.Frame) bool {
+		if len(f.JSON) == 4 && f.JSON[0] == "action" && f.JSON[1] == "presence" && f.JSON[2] == "subscribe" && f.JSON[3] == jid {
+			subscriptions++
+		}
+		return subscriptions == 2
+	}); err != nil {
+		t.Errorf("presence subscription not renewed: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp/binary"
+)
+
+/*
+The request layer correlates a request with its response by the message tag. The listener of a request is removed as
+soon as the response arrived or the caller stopped waiting, listeners nobody waits for anymore are dropped by the
+keep alive loop after abandonedListenerAge. Responses are decoded into typed values, status codes other than 200 are
+returned as StatusResponse errors.
+*/
+
+// newMessageTag returns a tag for a request that is sent with writeBinary.
+func (wac *Conn) newMessageTag() string {
+	return fmt.Sprintf("%d.--%d", time.Now().Unix(), wac.messageCount())
+}
+
+/*
+awaitRequest waits for the response to the request sent with messageTag and wraps the context error, so callers can
+still check for context.DeadlineExceeded or context.Canceled with errors.Is.
+*/
+func (wac *Conn) awaitRequest(ctx context.Context, requestType, messageTag string, ch <-chan string) (string, error) {
+	r, err := wac.awaitResponse(ctx, messageTag, ch)
+	if err == context.DeadlineExceeded {
+		wac.logger().Warn("request timed out", "request", requestType, "tag", messageTag)
+		return "", fmt.Errorf("%s timed out: %w", requestType, err)
+	} else if err != nil {
+		return "", fmt.Errorf("%s aborted: %w", requestType, err)
+	}
+	return r, nil
+}
+
+/*
+jsonRequest sends a JSON request and decodes the JSON response into v, which may be nil if only the status matters.
+*/
+func (wac *Conn) jsonRequest(ctx context.Context, requestType string, data []interface{}, v interface{}) error {
+	ctx, cancel := wac.requestContext(ctx)
+	defer cancel()
+
+	tag, ch, err := wac.writeJsonRequest(data)
+	if err != nil {
+		return err
+	}
+	r, err := wac.awaitRequest(ctx, requestType, tag, ch)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(requestType, r, v)
+}
+
+/*
+binaryRequest sends a binary action and decodes the JSON response into v, which may be nil if only the status matters.
+*/
+func (wac *Conn) binaryRequest(ctx context.Context, requestType string, node binary.Node, metric metric, flag flag, messageTag string, v interface{}) error {
+	ctx, cancel := wac.requestContext(ctx)
+	defer cancel()
+
+	ch, err := wac.writeBinary(node, metric, flag, messageTag)
+	if err != nil {
+		return err
+	}
+	r, err := wac.awaitRequest(ctx, requestType, messageTag, ch)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(requestType, r, v)
+}
+
+/*
+nodeRequest sends a binary query and returns the decrypted response node.
+*/
+func (wac *Conn) nodeRequest(ctx context.Context, requestType string, node binary.Node, metric metric, flag flag, messageTag string) (*binary.Node, error) {
+	ctx, cancel := wac.requestContext(ctx)
+	defer cancel()
+
+	ch, err := wac.writeBinary(node, metric, flag, messageTag)
+	if err != nil {
+		return nil, err
+	}
+	r, err := wac.awaitRequest(ctx, requestType, messageTag, ch)
+	if err != nil {
+		return nil, err
+	}
+	return wac.decryptBinaryMessage([]byte(r))
+}
+
+/*
+decodeResponse decodes the JSON response of a request into v. If the response carries a numeric status other than
+200, a StatusResponse is returned as error instead, responses that can't be decoded as ErrInvalidResponse. Some responses use the status field for other values, e.g. the
+status text of a contact, those are left to v.
+*/
+func decodeResponse(requestType, r string, v interface{}) error {
+	var probe struct {
+		Status json.RawMessage `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(r), &probe); err != nil {
+		return invalidResponse(requestType, r, err)
+	}
+
+	var status int
+	if len(probe.Status) > 0 && json.Unmarshal(probe.Status, &status) == nil && status != 200 {
+		resp := StatusResponse{RequestType: requestType}
+		if err := json.Unmarshal([]byte(r), &resp); err != nil {
+			return invalidResponse(requestType, r, err)
+		}
+		return resp
+	}
+
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(r), v); err != nil {
+		return invalidResponse(requestType, r, err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	var thumb ProfilePicThumb
+	if err := decodeResponse("thumb", `{"eurl":"https://example.org/pic","tag":"1"}`, &thumb); err != nil {
+		t.Fatal(err)
+	}
+	if thumb.URL != "https://example.org/pic" || thumb.Tag != "1" {
+		t.Errorf("unexpected thumb %+v", thumb)
+	}
+
+	err := decodeResponse("thumb", `{"status":404}`, &thumb)
+	var resp StatusResponse
+	if !errors.As(err, &resp) || resp.Status != 404 || resp.RequestType != "thumb" {
+		t.Errorf("expected status 404, got %v", err)
+	}
+
+	// the status query uses the status field for the text
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := decodeResponse("status", `{"status":"busy"}`, &status); err != nil || status.Status != "busy" {
+		t.Errorf("unexpected status %q: %v", status.Status, err)
+	}
+
+	if err := decodeResponse("status", `not json`, nil); err == nil {
+		t.Error("expected decoding error")
+	}
+}
+
+func TestGroupResultUnmarshal(t *testing.T) {
+	var result GroupResult
+	err := decodeResponse("group create", `{"status":200,"gid":"123-456@g.us","participants":[{"a@c.us":200},{"b@c.us":409}]}`, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.JID != "123-456@g.us" || result.Participants["a@c.us"] != 200 || result.Participants["b@c.us"] != 409 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestListenerExpiry(t *testing.T) {
+	wac := &Conn{
+		listener: &listenerWrapper{m: make(map[string]chan string), expires: make(map[string]time.Time)},
+	}
+	wac.addListener("s1", make(chan string, 1))
+	wac.addRequestListener("request", make(chan string, 1))
+
+	wac.expireListeners(time.Now())
+	if len(wac.listener.m) != 2 {
+		t.Fatalf("listeners expired too early: %v", wac.listener.m)
+	}
+	wac.expireListeners(time.Now().Add(abandonedListenerAge + time.Second))
+	if _, ok := wac.listener.m["request"]; ok {
+		t.Error("abandoned request listener was not expired")
+	}
+	if _, ok := wac.listener.m["s1"]; !ok {
+		t.Error("listener without expiry was removed")
+	}
+
+	if _, ok := wac.takeListener("s1"); !ok {
+		t.Error("listener not found")
+	}
+	if _, ok := wac.takeListener("s1"); ok {
+		t.Error("listener was delivered twice")
+	}
+}
+
+func TestDrainAndFailRequests(t *testing.T) {
+	wac := &Conn{
+		listener: &listenerWrapper{m: make(map[string]chan string), expires: make(map[string]time.Time)},
+		closed:   make(chan struct{}),
+	}
+	wac.addListener("s1", make(chan string, 1))
+	wac.addRequestListener("!", make(chan string, 1))
+	wac.addRequestListener("answered", make(chan string, 1))
+	abandoned := make(chan string, 1)
+	wac.addRequestListener("abandoned", abandoned)
+
+	drained := make(chan int, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		pending, _ := wac.drainRequests(ctx)
+		drained <- pending
+	}()
+	wac.takeListener("answered")
+	if pending := <-drained; pending != 1 {
+		t.Errorf("expected 1 pending request without the keep-alive, got %d", pending)
+	}
+
+	wac.failRequests()
+	if _, ok := <-abandoned; ok {
+		t.Error("channel of a pending request was not closed")
+	}
+	if _, ok := wac.listener.m["s1"]; !ok || len(wac.listener.m) != 1 {
+		t.Errorf("unexpected listeners after failing requests: %v", wac.listener.m)
+	}
+	if _, err := wac.awaitResponse(context.Background(), "late", make(chan string)); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestJsonRequestTimeoutRemovesListener(t *testing.T) {
+	pipe := newPipeConn()
+	wac, err := NewConnWithTransport(time.Second, &pipeTransport{conn: pipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+	go func() {
+		for range pipe.out {
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = wac.jsonRequest(ctx, "exist query", []interface{}{"query", "exist", "a@c.us"}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	wac.listener.RLock()
+	defer wac.listener.RUnlock()
+	// only the keep alive may still wait for its response
+	for tag := range wac.listener.m {
+		if tag != "!" {
+			t.Errorf("listener %s left behind", tag)
+		}
+	}
+	for tag := range wac.listener.expires {
+		if tag != "!" {
+			t.Errorf("expiry of %s left behind", tag)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package whatsapp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+/*
+A sealed session is a versioned envelope:
+
+	magic "WASS" | version | logN | r | p | salt (16) | nonce (12) | AES-256-GCM ciphertext of the JSON session
+
+The key is derived from a passphrase with scrypt(N=2^logN, r, p) and the salt. The whole header is authenticated as
+additional data, so changing any byte of the envelope, including the scrypt parameters, is detected.
+*/
+const (
+	sealMagic      = "WASS"
+	sealVersion1   = 1
+	sealSaltSize   = 16
+	sealNonceSize  = 12
+	sealHeaderSize = len(sealMagic) + 4 + sealSaltSize + sealNonceSize
+
+	// the default scrypt parameters, recommended for interactive use
+	sealLogN = 15
+	sealR    = 8
+	sealP    = 1
+
+	// limits of the parameters accepted when opening, just above the defaults: scrypt needs about 128*r*2^logN bytes
+	// for every passphrase tried, 64 MiB at the limits, so a crafted envelope can't exhaust the memory
+	sealMaxLogN = 16
+	sealMaxR    = 8
+	sealMaxP    = 4
+)
+
+/*
+SessionSealer encrypts sessions at rest. A serialized Session grants full access to the account, so it should never be
+stored in plain text. The sealed form can be stored anywhere, FileSessionStore uses it if created with
+NewSealedFileSessionStore.
+
+Keys are rotated by passing the previous passphrases to NewSessionSealer: sessions sealed with one of them are still
+opened, Reseal seals them with the current passphrase.
+*/
+type SessionSealer struct {
+	passphrases [][]byte // the current one first
+	logN, r, p  uint8
+}
+
+// NewSessionSealer creates a sealer sealing with passphrase and opening with passphrase or any of previous.
+func NewSessionSealer(passphrase []byte, previous ...[]byte) (*SessionSealer, error) {
+	s := &SessionSealer{logN: sealLogN, r: sealR, p: sealP}
+	for _, pass := range append([][]byte{passphrase}, previous...) {
+		if len(pass) == 0 {
+			return nil, ErrMissingPassphrase
+		}
+		s.passphrases = append(s.passphrases, append([]byte(nil), pass...))
+	}
+	return s, nil
+}
+
+// Seal encrypts session with the current passphrase.
+func (s *SessionSealer) Seal(session Session) ([]byte, error) {
+	plain, err := json.Marshal(session)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding session: %w", err)
+	}
+
+	header := make([]byte, sealHeaderSize)
+	copy(header, sealMagic)
+	header[4], header[5], header[6], header[7] = sealVersion1, s.logN, s.r, s.p
+	salt := header[8 : 8+sealSaltSize]
+	nonce := header[8+sealSaltSize:]
+	if _, err := rand.Read(header[8:]); err != nil {
+		return nil, fmt.Errorf("error creating salt and nonce: %w", err)
+	}
+
+	aead, err := sealCipher(s.passphrases[0], salt, s.logN, s.r, s.p)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, plain, header), nil
+}
+
+// Open decrypts a session sealed with the current or a previous passphrase.
+func (s *SessionSealer) Open(sealed []byte) (Session, error) {
+	session, _, err := s.open(sealed)
+	return session, err
+}
+
+/*
+Reseal opens sealed and seals it again if it was sealed with a previous passphrase or other scrypt parameters. The
+envelope is returned unchanged otherwise, changed reports whether it has to be stored again.
+*/
+func (s *SessionSealer) Reseal(sealed []byte) (resealed []byte, changed bool, err error) {
+	session, stale, err := s.open(sealed)
+	if err != nil {
+		return nil, false, err
+	}
+	if !stale {
+		return sealed, false, nil
+	}
+	resealed, err = s.Seal(session)
+	return resealed, err == nil, err
+}
+
+// open decrypts sealed, stale is set if it was not sealed with the current passphrase and parameters.
+func (s *SessionSealer) open(sealed []byte) (session Session, stale bool, err error) {
+	if len(sealed) < sealHeaderSize || !bytes.Equal(sealed[:4], []byte(sealMagic)) {
+		return Session{}, false, ErrSealedSession
+	}
+	if sealed[4] != sealVersion1 {
+		return Session{}, false, fmt.Errorf("%w: %d", ErrSealVersion, sealed[4])
+	}
+	logN, r, p := sealed[5], sealed[6], sealed[7]
+	if logN == 0 || logN > sealMaxLogN || r == 0 || r > sealMaxR || p == 0 || p > sealMaxP {
+		return Session{}, false, ErrSealedSession
+	}
+	header := sealed[:sealHeaderSize]
+	salt := header[8 : 8+sealSaltSize]
+	nonce := header[8+sealSaltSize:]
+
+	for i, pass := range s.passphrases {
+		aead, err := sealCipher(pass, salt, logN, r, p)
+		if err != nil {
+			return Session{}, false, err
+		}
+		plain, err := aead.Open(nil, nonce, sealed[sealHeaderSize:], header)
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(plain, &session); err != nil {
+			return Session{}, false, fmt.Errorf("error decoding sealed session: %w", err)
+		}
+		stale = i > 0 || logN != s.logN || r != s.r || p != s.p
+		return session, stale, nil
+	}
+	return Session{}, false, ErrSealedSession
+}
+
+// sealKey derives the key of an envelope, it is replaced by tests.
+var sealKey = scrypt.Key
+
+func sealCipher(passphrase, salt []byte, logN, r, p uint8) (cipher.AEAD, error) {
+	key, err := sealKey(passphrase, salt, 1<<logN, int(r), int(p), 32)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
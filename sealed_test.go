@@ -0,0 +1,159 @@
+package whatsapp
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testSealer returns a sealer with cheap scrypt parameters.
+func testSealer(t *testing.T, passphrase string, previous ...string) *SessionSealer {
+	t.Helper()
+	prev := make([][]byte, len(previous))
+	for i, p := range previous {
+		prev[i] = []byte(p)
+	}
+	s, err := NewSessionSealer([]byte(passphrase), prev...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.logN = 10
+	return s
+}
+
+func TestSessionSealer(t *testing.T) {
+	if _, err := NewSessionSealer(nil); !errors.Is(err, ErrMissingPassphrase) {
+		t.Errorf("expected ErrMissingPassphrase, got %v", err)
+	}
+
+	s := testSealer(t, "secret")
+	session := testSession("4915100000001@c.us")
+	sealed, err := s.Seal(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte(session.ServerToken)) || bytes.Contains(sealed, session.EncKey) {
+		t.Error("sealed session contains plain text")
+	}
+	opened, err := s.Open(sealed)
+	if err != nil {
+		t.Fatalf("error opening session: %v", err)
+	}
+	if !reflect.DeepEqual(opened, session) {
+		t.Errorf("opened session differs:\n%+v\n%+v", opened, session)
+	}
+	if again, _ := s.Seal(session); bytes.Equal(again, sealed) {
+		t.Error("sealing twice produced the same envelope")
+	}
+
+	if _, err := testSealer(t, "wrong").Open(sealed); !errors.Is(err, ErrSealedSession) {
+		t.Errorf("expected ErrSealedSession for a wrong passphrase, got %v", err)
+	}
+	if _, err := s.Open(sealed[:sealHeaderSize]); !errors.Is(err, ErrSealedSession) {
+		t.Errorf("expected ErrSealedSession for a truncated envelope, got %v", err)
+	}
+	for _, i := range []int{0, 6, 10, sealHeaderSize - 1, sealHeaderSize, len(sealed) - 1} {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 0x01
+		if _, err := s.Open(tampered); !errors.Is(err, ErrSealedSession) {
+			t.Errorf("byte %d: expected ErrSealedSession, got %v", i, err)
+		}
+	}
+	future := append([]byte(nil), sealed...)
+	future[4] = 2
+	if _, err := s.Open(future); !errors.Is(err, ErrSealVersion) {
+		t.Errorf("expected ErrSealVersion, got %v", err)
+	}
+}
+
+func TestSessionSealerRotation(t *testing.T) {
+	session := testSession("4915100000001@c.us")
+	old := testSealer(t, "old")
+	sealed, err := old.Seal(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := testSealer(t, "new", "old")
+	if opened, err := rotated.Open(sealed); err != nil || !reflect.DeepEqual(opened, session) {
+		t.Fatalf("session sealed with the previous passphrase not opened: %v", err)
+	}
+	resealed, changed, err := rotated.Reseal(sealed)
+	if err != nil || !changed {
+		t.Fatalf("session not resealed: changed %v, err %v", changed, err)
+	}
+	if _, err := old.Open(resealed); !errors.Is(err, ErrSealedSession) {
+		t.Errorf("resealed session still opens with the previous passphrase: %v", err)
+	}
+	if _, changed, _ := rotated.Reseal(resealed); changed {
+		t.Error("current session resealed again")
+	}
+
+	// stronger parameters make older envelopes stale as well
+	stronger := testSealer(t, "new")
+	stronger.logN = 11
+	if _, changed, err := stronger.Reseal(resealed); err != nil || !changed {
+		t.Errorf("session with old parameters not resealed: changed %v, err %v", changed, err)
+	}
+}
+
+func TestSealedFileSessionStore(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewSealedFileSessionStore(dir, nil); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig without sealer, got %v", err)
+	}
+	store, err := NewSealedFileSessionStore(dir, testSealer(t, "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSessionStore(t, store)
+
+	wid := "4915100000002@c.us"
+	data, err := os.ReadFile(store.path(wid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("serverToken")) {
+		t.Error("session file is not sealed")
+	}
+
+	// the key is rotated when the session is loaded
+	rotated, err := NewSealedFileSessionStore(dir, testSealer(t, "new", "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Load(wid); err != nil {
+		t.Fatalf("error loading session with the previous passphrase: %v", err)
+	}
+	if _, err := store.Load(wid); !errors.Is(err, ErrSealedSession) {
+		t.Errorf("session file not resealed with the new passphrase: %v", err)
+	}
+}
+
+func TestSessionSealerParameterLimits(t *testing.T) {
+	s := testSealer(t, "secret")
+	sealed, err := s.Seal(testSession("4915100000001@c.us"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	derived := 0
+	defer func(key func(password, salt []byte, N, r, p, keyLen int) ([]byte, error)) { sealKey = key }(sealKey)
+	sealKey = func(password, salt []byte, N, r, p, keyLen int) ([]byte, error) {
+		derived++
+		return make([]byte, keyLen), nil
+	}
+
+	for _, params := range [][3]byte{{sealMaxLogN + 1, sealR, sealP}, {sealLogN, sealMaxR + 1, sealP}, {sealLogN, sealR, sealMaxP + 1}, {20, 32, 16}} {
+		oversized := append([]byte(nil), sealed...)
+		oversized[5], oversized[6], oversized[7] = params[0], params[1], params[2]
+		if _, err := s.Open(oversized); !errors.Is(err, ErrSealedSession) {
+			t.Errorf("%v: expected ErrSealedSession, got %v", params, err)
+		}
+	}
+	if derived != 0 {
+		t.Errorf("scrypt ran %d times for oversized parameters", derived)
+	}
+}
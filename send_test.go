@@ -0,0 +1,174 @@
+package whatsapp_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/binary/proto"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+func TestSendTextMessage(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := restoredTestConn(t, srv)
+
+	id, err := wac.Send(whatsapp.TextMessage{
+		Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"},
+		Text: "hello",
+	})
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+
+	msg, err := srv.WaitForMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetKey().GetID() != id || msg.GetMessage().GetConversation() != "hello" {
+		t.Errorf("unexpected message relayed: %v", msg)
+	}
+}
+
+func TestSendRateLimited(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithRateLimit(whatsapp.RateLimit{PerChatRate: 1, PerChatBurst: 1, Policy: whatsapp.QueueFailFast}),
+	)
+	if err != nil {
+		t.Fatalf("error creating connection: %v", err)
+	}
+	defer wac.Disconnect()
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+
+	msg := whatsapp.TextMessage{
+		Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"},
+		Text: "hello",
+	}
+	if _, err := wac.Send(msg); err != nil {
+		t.Fatalf("error sending first message: %v", err)
+	}
+	if _, err := wac.Send(msg); !errors.Is(err, whatsapp.ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if stats := wac.QueueStats(); stats.Sent != 1 || stats.Rejected != 1 {
+		t.Errorf("unexpected queue stats %+v", stats)
+	}
+}
+
+func TestOutboxReplayedAfterRestore(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	outbox, err := whatsapp.NewFileOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithOutbox(outbox),
+	)
+	if err != nil {
+		t.Fatalf("error creating connection: %v", err)
+	}
+	defer wac.Disconnect()
+	events := newRecorder(wac)
+
+	var ids []string
+	for _, text := range []string{"first", "second"} {
+		id, err := wac.Send(whatsapp.TextMessage{
+			Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"},
+			Text: text,
+		})
+		if !errors.Is(err, whatsapp.ErrMessageQueued) || id == "" {
+			t.Fatalf("expected message to be queued, got %q, %v", id, err)
+		}
+		ids = append(ids, id)
+	}
+	if pending, _ := wac.OutboxPending(); len(pending) != 2 {
+		t.Fatalf("expected 2 queued messages, got %d", len(pending))
+	}
+
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	for i, text := range []string{"first", "second"} {
+		msg, err := srv.WaitForMessage(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.GetKey().GetID() != ids[i] || msg.GetMessage().GetConversation() != text {
+			t.Errorf("unexpected message replayed: %v", msg)
+		}
+		if result := events.nextOutboxResult(t); result.ID != ids[i] || result.Err != nil {
+			t.Errorf("unexpected outbox result %+v", result)
+		}
+	}
+	if pending, _ := wac.OutboxPending(); len(pending) != 0 {
+		t.Errorf("outbox not empty after replay: %d messages", len(pending))
+	}
+}
+
+func TestSendRejectedMessage(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := restoredTestConn(t, srv)
+	srv.SetAckStatus(500)
+
+	_, err := wac.Send(whatsapp.TextMessage{Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: "hello"})
+	var resp whatsapp.StatusResponse
+	if !errors.As(err, &resp) || resp.Status != 500 {
+		t.Fatalf("expected status 500, got %v", err)
+	}
+}
+
+func TestSendContextCancelled(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := restoredTestConn(t, srv)
+	srv.SetResponseDelay(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := wac.SendContext(ctx, whatsapp.TextMessage{Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: "hello"})
+	if err == nil {
+		t.Fatal("expected send to be aborted")
+	}
+}
+
+func TestSendRawTimesOut(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := newTestConn(t, srv, 50*time.Millisecond)
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	srv.SetResponseDelay(time.Second)
+
+	id := "3EB0SENDRAW"
+	fromMe := true
+	jid := "4915100000000@s.whatsapp.net"
+	text := "hello"
+	output := make(chan error, 1)
+	go wac.SendRaw(&proto.WebMessageInfo{
+		Key:     &proto.MessageKey{RemoteJID: &jid, FromMe: &fromMe, ID: &id},
+		Message: &proto.Message{Conversation: &text},
+	}, output)
+	select {
+	case err := <-output:
+		if err == nil {
+			t.Fatal("expected SendRaw to time out")
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("SendRaw ignored the timeout of the Conn")
+	}
+}
@@ -0,0 +1,167 @@
+package whatsapp
+
+import (
+	"context"
+	"encoding/json"
+)
+
+type ServerEventType int
+
+const (
+	// SessionReplaced is emitted when the session was taken over by another client, e.g. a second WhatsAppWeb tab.
+	SessionReplaced ServerEventType = iota
+	// LoggedOutRemotely is emitted when the session was logged out from the phone. It can not be restored anymore.
+	LoggedOutRemotely
+	// ChallengeRequired is emitted after the server challenged the session and the challenge was answered.
+	ChallengeRequired
+	// ConnInfoUpdated is emitted whenever the server sent new information about the phone, which is taken over by Info.
+	ConnInfoUpdated
+	// StreamUpdate is emitted when the server reports a change of the stream, e.g. that the client is outdated.
+	StreamUpdate
+	// BlocklistUpdated is emitted with the complete list of blocked contacts whenever it changes.
+	BlocklistUpdated
+	// SessionDisconnected is emitted when the server disconnected the session for another reason than the ones above.
+	// The session is kept, so the reconnect supervisor tries to restore it.
+	SessionDisconnected
+)
+
+func (t ServerEventType) String() string {
+	switch t {
+	case SessionReplaced:
+		return "session replaced"
+	case LoggedOutRemotely:
+		return "logged out remotely"
+	case ChallengeRequired:
+		return "challenge required"
+	case ConnInfoUpdated:
+		return "conn info updated"
+	case StreamUpdate:
+		return "stream update"
+	case BlocklistUpdated:
+		return "blocklist updated"
+	case SessionDisconnected:
+		return "session disconnected"
+	}
+	return "unknown"
+}
+
+/*
+ServerEvent is a JSON frame the server sent on its own, e.g. ["Cmd",{"type":"disconnect","kind":"replaced"}], decoded
+into the fields that belong to its Type. It is dispatched to every handler implementing ServerEventHandler, after the
+Conn reacted to it: replaced and remotely logged out sessions change the state and are not reconnected, challenges
+are answered and Info is updated. The frames are dispatched to JsonMessageHandlers as before.
+*/
+type ServerEvent struct {
+	Type ServerEventType
+
+	// Kind is the kind of a disconnect, "replaced" for SessionReplaced and empty for LoggedOutRemotely.
+	Kind string
+	// Info is the updated Info for ConnInfoUpdated.
+	Info *Info
+	// Stream is the kind of a StreamUpdate, e.g. "update" or "asleep". Version is the version the server asks to
+	// update to, if any.
+	Stream  string
+	Version string
+	// Blocklist holds the jids of all blocked contacts for BlocklistUpdated.
+	Blocklist []string
+	// Err is set for ChallengeRequired if the challenge could not be answered.
+	Err error
+}
+
+/*
+handleServerFrame consumes the unsolicited JSON frames the Conn reacts to. Other frames are ignored, known frames with
+an unexpected payload are reported to the handlers.
+*/
+func (wac *Conn) handleServerFrame(payload string) {
+	cmd, args, err := decodeServerFrame("server", payload)
+	if err != nil {
+		return
+	}
+	switch cmd {
+	case "Conn":
+		err = wac.handleConnInfo(args[0])
+	case "Cmd":
+		err = wac.handleCmd(args[0])
+	case "Stream":
+		err = wac.handleStream(args)
+	case "Blocklist":
+		err = wac.handleBlocklist(args[0])
+	}
+	if err != nil {
+		wac.logger().Warn("error decoding server frame", "cmd", cmd, "err", err)
+		wac.handle(err)
+	}
+}
+
+// handleCmd reacts to a ["Cmd",{...}] frame, which disconnects or challenges the session.
+func (wac *Conn) handleCmd(payload json.RawMessage) error {
+	cmd, err := decodeCmd("Cmd", payload)
+	if err != nil {
+		return err
+	}
+	switch cmd.Type {
+	case "disconnect":
+		// the server closes the connection right after, replaced and logged out sessions must not be reconnected. The
+		// state only changes once logging in started, before the Conn is just disconnected
+		wac.loggedIn.Store(false)
+		switch cmd.Kind {
+		case "replaced":
+			wac.logger().Warn("session replaced")
+			if wac.State().CanTransition(StateReplaced) {
+				wac.setState(StateReplaced, ErrReplaced)
+			}
+			wac.handle(ServerEvent{Type: SessionReplaced, Kind: cmd.Kind})
+		case "":
+			wac.logger().Warn("logged out remotely")
+			wac.deleteSession()
+			if wac.State().CanTransition(StateLoggedOut) {
+				wac.setState(StateLoggedOut, ErrUnpaired)
+			}
+			wac.handle(ServerEvent{Type: LoggedOutRemotely})
+		default:
+			// unknown kinds must not destroy the session, restoring it tells whether it is still valid
+			wac.logger().Warn("disconnected by the server", "kind", cmd.Kind)
+			wac.handle(ServerEvent{Type: SessionDisconnected, Kind: cmd.Kind})
+		}
+	case "challenge":
+		// the answer is read by readPump, which is running this
+		go wac.answerChallenge(cmd.Challenge)
+	}
+	return nil
+}
+
+// answerChallenge resolves a challenge the server sent while logged in.
+func (wac *Conn) answerChallenge(challenge string) {
+	session := wac.getSession()
+	err := ErrInvalidSession
+	if session != nil && session.MacKey != nil {
+		err = wac.resolveChallenge(context.Background(), session, challenge)
+	}
+	if err != nil {
+		wac.logger().Warn("error resolving challenge", "err", err)
+	} else {
+		wac.logger().Debug("challenge resolved")
+	}
+	wac.handle(ServerEvent{Type: ChallengeRequired, Err: err})
+}
+
+// handleStream consumes a ["Stream","update",false,"2.2142.12"] frame.
+func (wac *Conn) handleStream(args []json.RawMessage) error {
+	stream, version, err := decodeStream("Stream", args)
+	if err != nil {
+		return err
+	}
+	wac.logger().Info("stream update", "stream", stream, "version", version)
+	wac.handle(ServerEvent{Type: StreamUpdate, Stream: stream, Version: version})
+	return nil
+}
+
+// handleBlocklist consumes a ["Blocklist",{"id":1,"blocklist":[...]}] frame.
+func (wac *Conn) handleBlocklist(payload json.RawMessage) error {
+	blocklist, err := decodeBlocklist("Blocklist", payload)
+	if err != nil {
+		return err
+	}
+	wac.handle(ServerEvent{Type: BlocklistUpdated, Blocklist: blocklist})
+	return nil
+}
@@ -0,0 +1,170 @@
+package whatsapp_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+func serverEventTestConn(t *testing.T, srv *whatsapptest.Server) (*whatsapp.Conn, *recorder) {
+	t.Helper()
+	wac := restoredTestConn(t, srv)
+	wac.SetAutoReconnect(&whatsapp.ReconnectConfig{MinBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond})
+	return wac, newRecorder(wac)
+}
+
+// expectClosedForGood waits until wac is disconnected in state and checks that it is not reconnected.
+func expectClosedForGood(t *testing.T, wac *whatsapp.Conn, state whatsapp.ConnState, events *recorder) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for wac.GetConnected() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if wac.GetConnected() || wac.GetLoggedIn() {
+		t.Fatal("still connected")
+	}
+	if s := wac.State(); s != state {
+		t.Errorf("expected state %v, got %v", state, s)
+	}
+	events.expectNone(t, whatsapp.ConnectionEvent{}, 100*time.Millisecond)
+}
+
+func TestServerEventSessionReplaced(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac, events := serverEventTestConn(t, srv)
+	defer wac.Disconnect()
+
+	if err := srv.Replace(); err != nil {
+		t.Fatal(err)
+	}
+	if event := events.nextServerEvent(t, whatsapp.SessionReplaced); event.Kind != "replaced" {
+		t.Errorf("unexpected kind in %+v", event)
+	}
+	expectClosedForGood(t, wac, whatsapp.StateReplaced, events)
+}
+
+func TestServerEventLoggedOutRemotely(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	store := whatsapp.NewMemorySessionStore()
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithSessionStore(store),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+	session, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair()))
+	if err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	wac.SetAutoReconnect(&whatsapp.ReconnectConfig{MinBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond})
+	events := newRecorder(wac)
+
+	if err := srv.LogoutRemotely(); err != nil {
+		t.Fatal(err)
+	}
+	events.nextServerEvent(t, whatsapp.LoggedOutRemotely)
+	expectClosedForGood(t, wac, whatsapp.StateLoggedOut, events)
+	if _, err := store.Load(session.Wid); !errors.Is(err, whatsapp.ErrSessionNotFound) {
+		t.Errorf("session not deleted from the store: %v", err)
+	}
+}
+
+func TestServerEventUnknownDisconnectKeepsSession(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	store := whatsapp.NewMemorySessionStore()
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithSessionStore(store),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+	session, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair()))
+	if err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	events := newRecorder(wac)
+
+	if err := srv.PushJSON([]interface{}{"Cmd", map[string]interface{}{"type": "disconnect", "kind": "maintenance"}}); err != nil {
+		t.Fatal(err)
+	}
+	if event := events.nextServerEvent(t, whatsapp.SessionDisconnected); event.Kind != "maintenance" {
+		t.Errorf("unexpected kind in %+v", event)
+	}
+	if _, err := store.Load(session.Wid); err != nil {
+		t.Errorf("session deleted from the store: %v", err)
+	}
+	if s := wac.State(); s == whatsapp.StateLoggedOut || s == whatsapp.StateReplaced {
+		t.Errorf("unexpected state %v", s)
+	}
+}
+
+func TestServerEventChallenge(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac, events := serverEventTestConn(t, srv)
+	defer wac.Disconnect()
+
+	if err := srv.Challenge(); err != nil {
+		t.Fatal(err)
+	}
+	if event := events.nextServerEvent(t, whatsapp.ChallengeRequired); event.Err != nil {
+		t.Fatalf("error resolving challenge: %v", event.Err)
+	}
+	if !wac.GetLoggedIn() {
+		t.Error("logged out by the challenge")
+	}
+}
+
+func TestServerEventConnInfo(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac, events := serverEventTestConn(t, srv)
+	defer wac.Disconnect()
+
+	if err := srv.PushJSON([]interface{}{"Conn", map[string]interface{}{"pushname": "renamed", "battery": 42, "plugged": true}}); err != nil {
+		t.Fatal(err)
+	}
+	event := events.nextServerEvent(t, whatsapp.ConnInfoUpdated)
+	if event.Info == nil || event.Info.Pushname != "renamed" || event.Info.Battery != 42 || !event.Info.Plugged {
+		t.Fatalf("unexpected info in %+v", event)
+	}
+	// the other fields are kept
+	if info := wac.GetInfo(); info != event.Info || info.Phone == nil || info.Wid == "" {
+		t.Errorf("info not taken over: %+v", info)
+	}
+}
+
+func TestServerEventStreamAndBlocklist(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac, events := serverEventTestConn(t, srv)
+	defer wac.Disconnect()
+
+	if err := srv.PushJSON([]interface{}{"Stream", "update", false, "2.2200.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if event := events.nextServerEvent(t, whatsapp.StreamUpdate); event.Stream != "update" || event.Version != "2.2200.1" {
+		t.Errorf("unexpected stream update %+v", event)
+	}
+
+	blocked := []string{"4915100000000@c.us", "4915100000001@c.us"}
+	if err := srv.PushJSON([]interface{}{"Blocklist", map[string]interface{}{"id": 1, "blocklist": blocked}}); err != nil {
+		t.Fatal(err)
+	}
+	if event := events.nextServerEvent(t, whatsapp.BlocklistUpdated); !reflect.DeepEqual(event.Blocklist, blocked) {
+		t.Errorf("unexpected blocklist %+v", event)
+	}
+}
@@ -1,12 +1,12 @@
 package whatsapp
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -60,37 +60,6 @@ type PhoneInfo struct {
 	WaVersion          string
 }
 
-func newInfoFromReq(info map[string]interface{}) *Info {
-	phoneInfo := info["phone"].(map[string]interface{})
-
-	ret := &Info{
-		Battery:   int(info["battery"].(float64)),
-		Platform:  info["platform"].(string),
-		Connected: info["connected"].(bool),
-		Pushname:  info["pushname"].(string),
-		Wid:       info["wid"].(string),
-		Lc:        info["lc"].(string),
-		Phone: &PhoneInfo{
-			phoneInfo["mcc"].(string),
-			phoneInfo["mnc"].(string),
-			phoneInfo["os_version"].(string),
-			phoneInfo["device_manufacturer"].(string),
-			phoneInfo["device_model"].(string),
-			phoneInfo["os_build_number"].(string),
-			phoneInfo["wa_version"].(string),
-		},
-		Plugged: info["plugged"].(bool),
-		Lg:      info["lg"].(string),
-		Tos:     int(info["tos"].(float64)),
-	}
-
-	if is24h, ok := info["is24h"]; ok {
-		ret.Is24h = is24h.(bool)
-	}
-
-	return ret
-}
-
 /*
 CheckCurrentServerVersion is based on the login method logic in order to establish the websocket connection and get
 the current version from the server with the `admin init` command. This can be very useful for automations in which
@@ -122,14 +91,13 @@ func CheckCurrentServerVersion() ([]int, error) {
 		return nil, fmt.Errorf("login connection timed out")
 	}
 
-	var resp map[string]interface{}
-	if err = json.Unmarshal([]byte(r), &resp); err != nil {
-		return nil, fmt.Errorf("error decoding login: %s", err.Error())
+	resp, err := decodeInitResponse("admin init", r, false)
+	if err != nil {
+		return nil, err
 	}
 
 	// Take the curr property as X.Y.Z and split it into as int slice
-	curr := resp["curr"].(string)
-	currArray := strings.Split(curr, ".")
+	currArray := strings.Split(resp.Curr, ".")
 	version := make([]int, len(currArray))
 	for i := range version {
 		version[i], _ = strconv.Atoi(currArray[i])
@@ -143,6 +111,8 @@ SetClientName sets the long and short client names that are sent to WhatsApp whe
 WhatsApp Web device list. As the values are only sent when logging in, changing them after logging in is not possible.
 */
 func (wac *Conn) SetClientName(long, short, version string) error {
+	wac.loginSessionLock.Lock()
+	defer wac.loginSessionLock.Unlock()
 	if wac.session != nil && (wac.session.EncKey != nil || wac.session.MacKey != nil) {
 		return fmt.Errorf("cannot change client name after logging in")
 	}
@@ -150,34 +120,43 @@ func (wac *Conn) SetClientName(long, short, version string) error {
 	return nil
 }
 
+func (wac *Conn) clientNames() []string {
+	wac.loginSessionLock.RLock()
+	defer wac.loginSessionLock.RUnlock()
+	return []string{wac.longClientName, wac.shortClientName, wac.clientVersion}
+}
+
 /*
 SetClientVersion sets WhatsApp client version
 Default value is 0.4.2080
+It applies to all connections and must not be called while a Login or Restore is running.
 */
 func (wac *Conn) SetClientVersion(major int, minor int, patch int) {
 	waVersion = []int{major, minor, patch}
 }
 
-func (wac *Conn) adminInitRequest(clientId string) (string, time.Duration, error) {
-	login := []interface{}{"admin", "init", waVersion, []string{wac.longClientName, wac.shortClientName, wac.clientVersion}, clientId, true}
-	loginChan, err := wac.writeJson(login)
+func (wac *Conn) adminInitRequest(ctx context.Context, clientId string) (string, time.Duration, error) {
+	ctx, cancel := wac.requestContext(ctx)
+	defer cancel()
+
+	login := []interface{}{"admin", "init", waVersion, wac.clientNames(), clientId, true}
+	tag, loginChan, err := wac.writeJsonRequest(login)
 	if err != nil {
 		return "", 0, fmt.Errorf("error writing login: %v\n", err)
 	}
 
-	var r string
-	select {
-	case r = <-loginChan:
-	case <-time.After(wac.msgTimeout):
+	r, err := wac.awaitResponse(ctx, tag, loginChan)
+	if err == context.DeadlineExceeded {
 		return "", 0, fmt.Errorf("login connection timed out")
+	} else if err != nil {
+		return "", 0, err
 	}
 
-	var resp map[string]interface{}
-	if err = json.Unmarshal([]byte(r), &resp); err != nil {
-		return "", 0, fmt.Errorf("error decoding login resp: %v\n", err)
+	resp, err := decodeInitResponse("admin init", r, true)
+	if err != nil {
+		return "", 0, err
 	}
-
-	return resp["ref"].(string), time.Duration(resp["ttl"].(float64)) * time.Millisecond, nil
+	return resp.Ref, time.Duration(resp.TTL) * time.Millisecond, nil
 }
 
 // GetClientVersion returns WhatsApp client version
@@ -189,8 +168,7 @@ func (wac *Conn) GetClientVersion() []int {
 Login is the function that creates a new whatsapp session and logs you in. If you do not want to scan the qr code
 every time, you should save the returned session and use RestoreWithSession the next time. Login takes a writable channel
 as an parameter. This channel is used to push the data represented by the qr code back to the user. The received data
-should be displayed as an qr code in a way you prefer. To print a qr code to console you can use:
-github.com/Baozisoftware/qrcode-terminal-go Example login procedure:
+should be displayed as an qr code in a way you prefer. To print a qr code to console you can use the qrcode package:
 	wac, err := whatsapp.NewConn(5 * time.Second)
 	if err != nil {
 		panic(err)
@@ -198,8 +176,8 @@ github.com/Baozisoftware/qrcode-terminal-go Example login procedure:
 
 	qr := make(chan string)
 	go func() {
-		terminal := qrcodeTerminal.New()
-		terminal.Get(<-qr).Print()
+		code, _ := qrcode.EncodeString(<-qr, qrcode.Medium)
+		fmt.Print(code.HalfBlock())
 	}()
 
 	session, err := wac.Login(qr)
@@ -207,34 +185,94 @@ github.com/Baozisoftware/qrcode-terminal-go Example login procedure:
 		fmt.Fprintf(os.Stderr, "error during login: %v\n", err)
 	}
 	fmt.Printf("login successful, session: %v\n", session)
+
+LoginWithEvents reports the expiry of every qr code and the scan as well.
 */
 func (wac *Conn) Login(qrChan chan<- string) (Session, error) {
 	return wac.LoginWithRetry(qrChan, 0)
 }
 
 func (wac *Conn) LoginWithRetry(qrChan chan<- string, maxRetries int) (Session, error) {
-	session := Session{}
+	return wac.LoginWithRetryContext(context.Background(), qrChan, maxRetries)
+}
+
+/*
+LoginWithRetryContext works like LoginWithRetry, but aborts the login as soon as ctx is done. A qr code that is not
+read from qrChan before it expires is dropped, so a caller that stops reading does not block the login.
+*/
+func (wac *Conn) LoginWithRetryContext(ctx context.Context, qrChan chan<- string, maxRetries int) (Session, error) {
+	return wac.login(ctx, maxRetries, func(event LoginEvent) {
+		if event.Type != QRIssued && event.Type != QRRefreshed {
+			return
+		}
+		timer := time.NewTimer(time.Until(event.Expires))
+		defer timer.Stop()
+		select {
+		case qrChan <- event.QR:
+		case <-timer.C:
+			wac.logger().Warn("qr code expired before it was read", "attempt", event.Attempt)
+		case <-ctx.Done():
+		}
+	})
+}
+
+/*
+LoginWithEvents logs in like LoginWithRetryContext, but reports the progress to onEvent instead of pushing the qr
+codes into a channel: every qr code with its expiry, the scan, and finally the success with the Session and Info or the
+failure. onEvent is called synchronously and in order, it may be nil if the events are consumed by a
+LoginEventHandler.
+
+The login is aborted as soon as ctx is done. An aborted login disconnects, so no response to the qr code is left
+pending.
+*/
+func (wac *Conn) LoginWithEvents(ctx context.Context, maxRetries int, onEvent func(LoginEvent)) (Session, error) {
+	return wac.login(ctx, maxRetries, onEvent)
+}
+
+// login implements the Login functions, emitting the progress to onEvent and the handlers.
+func (wac *Conn) login(ctx context.Context, maxRetries int, onEvent func(LoginEvent)) (session Session, err error) {
+	ctx, span := wac.startSpan(ctx, "whatsapp.Login")
+	defer func() { endSpan(span, err) }()
+
 	//Makes sure that only a single Login or Restore can happen at the same time
 	if !atomic.CompareAndSwapUint32(&wac.sessionLock, 0, 1) {
 		return session, ErrLoginInProgress
 	}
 	defer atomic.StoreUint32(&wac.sessionLock, 0)
 
-	if wac.loggedIn {
+	if wac.loggedIn.Load() {
 		return session, ErrAlreadyLoggedIn
 	}
 
 	if err := wac.connect(); err != nil && err != ErrAlreadyConnected {
 		return session, err
 	}
+	ws := wac.websocket()
+	emit := func(event LoginEvent) {
+		if onEvent != nil {
+			onEvent(event)
+		}
+		wac.handle(event)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			// nobody waits for the login anymore, the phone must not be able to complete it
+			_, _ = wac.Disconnect()
+		}
+		wac.loginFailed(err)
+		emit(LoginEvent{Type: LoginFailed, Err: err})
+	}()
 
 	//logged in?!?
-	if wac.session != nil && (wac.session.EncKey != nil || wac.session.MacKey != nil) {
+	if s := wac.getSession(); s != nil && (s.EncKey != nil || s.MacKey != nil) {
 		return session, fmt.Errorf("already logged in")
 	}
 
 	clientId := make([]byte, 16)
-	_, err := rand.Read(clientId)
+	_, err = rand.Read(clientId)
 	if err != nil {
 		return session, fmt.Errorf("error creating random ClientId: %v", err)
 	}
@@ -248,55 +286,76 @@ func (wac *Conn) LoginWithRetry(qrChan chan<- string, maxRetries int) (Session,
 
 	//listener for Login response
 	s1 := make(chan string, 1)
-	wac.listener.Lock()
-	wac.listener.m["s1"] = s1
-	wac.listener.Unlock()
+	wac.addListener("s1", s1)
+	defer func() {
+		if err != nil {
+			wac.removeListener("s1")
+		}
+	}()
 
-	ref, ttl, err := wac.adminInitRequest(session.ClientId)
+	// issueQR emits the qr code for ref and returns its expiry
+	issueQR := func(ref string, ttl time.Duration, attempt int) time.Time {
+		event := LoginEvent{
+			Type:    QRIssued,
+			QR:      fmt.Sprintf("%v,%v,%v", ref, base64.StdEncoding.EncodeToString(pub[:]), session.ClientId),
+			TTL:     ttl,
+			Expires: time.Now().Add(ttl),
+			Attempt: attempt,
+		}
+		if attempt > 0 {
+			event.Type = QRRefreshed
+		}
+		emit(event)
+		return event.Expires
+	}
+
+	ref, ttl, err := wac.adminInitRequest(ctx, session.ClientId)
 	if err != nil {
 		return session, err
 	}
-	qrChan <- fmt.Sprintf("%v,%v,%v", ref, base64.StdEncoding.EncodeToString(pub[:]), session.ClientId)
+	wac.setState(StateAwaitingQR, nil)
+	attempt := 0
+	expires := issueQR(ref, ttl, attempt)
+	expired := time.NewTimer(time.Until(expires))
+	defer expired.Stop()
 
-	wac.loginSessionLock.Lock()
-	defer wac.loginSessionLock.Unlock()
-	var resp2 []interface{}
+	var info connInfo
 For:
 	for {
 		select {
 		case r1 := <-s1:
-			if err := json.Unmarshal([]byte(r1), &resp2); err != nil {
-				return session, fmt.Errorf("error decoding qr code resp: %v", err)
+			if info, err = decodeLoginInfo("login", r1, true); err != nil {
+				return session, err
 			}
 			break For
-		case <-time.After(ttl):
+		case <-expired.C:
 			maxRetries--
 			if maxRetries < 0 {
 				_, _ = wac.Disconnect()
 				return session, ErrLoginTimedOut
 			}
-			ref, ttl, err = wac.adminInitRequest(session.ClientId)
+			ref, ttl, err = wac.adminInitRequest(ctx, session.ClientId)
 			if err != nil {
 				return session, err
 			}
-			qrChan <- fmt.Sprintf("%v,%v,%v", ref, base64.StdEncoding.EncodeToString(pub[:]), session.ClientId)
+			attempt++
+			expires = issueQR(ref, ttl, attempt)
+			expired.Reset(time.Until(expires))
+		case <-ctx.Done():
+			return session, ctx.Err()
 		}
 	}
 
-	info := resp2[1].(map[string]interface{})
-	if _, ok := info["phone"]; ok {
-		wac.Info = newInfoFromReq(info)
-	} else {
-		return session, errors.New("not a valid phone version")
-	}
+	emit(LoginEvent{Type: QRScanned})
+	wac.setState(StateLoggingIn, nil)
 
-	session.ClientToken = info["clientToken"].(string)
-	session.ServerToken = info["serverToken"].(string)
-	session.Wid = info["wid"].(string)
-	s := info["secret"].(string)
-	decodedSecret, err := base64.StdEncoding.DecodeString(s)
+	wac.setInfo(info.info())
+	session.ClientToken = info.ClientToken
+	session.ServerToken = info.ServerToken
+	session.Wid = *info.Wid
+	decodedSecret, err := info.decodeSecret()
 	if err != nil {
-		return session, fmt.Errorf("error decoding secret: %v", err)
+		return session, err
 	}
 
 	var pubKey [32]byte
@@ -336,8 +395,18 @@ For:
 
 	session.EncKey = keyDecrypted[:32]
 	session.MacKey = keyDecrypted[32:64]
-	wac.session = &session
-	wac.loggedIn = true
+	wac.setSession(&session)
+	if err = wac.markLoggedIn(ws); err != nil {
+		return session, err
+	}
+	wac.logger().Info("logged in", "jid", session.Wid)
+	wac.setState(StateLoggedIn, nil)
+	wac.saveSession()
+	phoneInfo := wac.GetInfo()
+	if phoneInfo != nil {
+		wac.updatePhoneInfo(phoneInfo)
+	}
+	emit(LoginEvent{Type: LoginSucceeded, Session: session, Info: phoneInfo})
 
 	return session, nil
 }
@@ -347,22 +416,28 @@ For:
 Basically the old RestoreSession functionality
 */
 func (wac *Conn) RestoreWithSession(session Session) (_ Session, err error) {
-	if wac.loggedIn {
+	return wac.RestoreWithSessionContext(context.Background(), session)
+}
+
+// RestoreWithSessionContext works like RestoreWithSession, but aborts restoring as soon as ctx is done.
+func (wac *Conn) RestoreWithSessionContext(ctx context.Context, session Session) (_ Session, err error) {
+	//Makes sure that only a single Login or Restore can happen at the same time
+	if !atomic.CompareAndSwapUint32(&wac.sessionLock, 0, 1) {
+		return Session{}, ErrLoginInProgress
+	}
+	defer atomic.StoreUint32(&wac.sessionLock, 0)
+
+	if wac.loggedIn.Load() {
 		return Session{}, ErrAlreadyLoggedIn
 	}
-	old := wac.session
-	defer func() {
-		if err != nil {
-			wac.session = old
-		}
-	}()
-	wac.session = &session
+	old := wac.getSession()
+	wac.setSession(&session)
 
-	if err = wac.Restore(); err != nil {
-		wac.session = nil
+	if err = wac.restore(ctx); err != nil {
+		wac.setSession(old)
 		return Session{}, err
 	}
-	return *wac.session, nil
+	return *wac.getSession(), nil
 }
 
 /*//TODO: GoDoc
@@ -372,74 +447,110 @@ saved because the Client and Server-Token will change after every login. Logging
 suggested. If so, a challenge has to be resolved which is just another possible point of failure.
 */
 func (wac *Conn) Restore() error {
+	return wac.RestoreContext(context.Background())
+}
+
+/*
+RestoreContext works like Restore, but aborts restoring as soon as ctx is done. Every single step of the handshake is
+still limited by the timeout of the Conn.
+*/
+func (wac *Conn) RestoreContext(ctx context.Context) (err error) {
 	//Makes sure that only a single Login or Restore can happen at the same time
 	if !atomic.CompareAndSwapUint32(&wac.sessionLock, 0, 1) {
 		return ErrLoginInProgress
 	}
 	defer atomic.StoreUint32(&wac.sessionLock, 0)
 
-	if wac.session == nil {
+	return wac.restore(ctx)
+}
+
+// restore implements RestoreContext, the caller must hold sessionLock.
+func (wac *Conn) restore(ctx context.Context) (err error) {
+	ctx, span := wac.startSpan(ctx, "whatsapp.Restore")
+	defer func() { endSpan(span, err) }()
+
+	session := wac.getSession()
+	if session == nil {
 		return ErrInvalidSession
 	}
 
 	if err := wac.connect(); err != nil && err != ErrAlreadyConnected {
 		return err
 	}
+	ws := wac.websocket()
 
-	if wac.loggedIn {
+	if wac.loggedIn.Load() {
 		return ErrAlreadyLoggedIn
 	}
+	wac.setState(StateLoggingIn, nil)
+	defer func() {
+		if err != nil {
+			wac.loginFailed(err)
+		}
+	}()
 
 	//listener for Conn or challenge; s1 is not allowed to drop
 	s1 := make(chan string, 1)
-	wac.listener.Lock()
-	wac.listener.m["s1"] = s1
-	wac.listener.Unlock()
+	wac.addListener("s1", s1)
+
+	var initTag, loginTag string
+	defer func() {
+		if err != nil {
+			wac.resetTimeTag()
+			for _, tag := range []string{"s1", "s2", initTag, loginTag} {
+				wac.removeListener(tag)
+			}
+		}
+	}()
 
 	//admin init
-	init := []interface{}{"admin", "init", waVersion, []string{wac.longClientName, wac.shortClientName, wac.clientVersion}, wac.session.ClientId, true}
-	initChan, err := wac.writeJson(init)
+	init := []interface{}{"admin", "init", waVersion, wac.clientNames(), session.ClientId, true}
+	initTag, initChan, err := wac.writeJsonRequest(init)
 	if err != nil {
 		return fmt.Errorf("error writing admin init: %v\n", err)
 	}
 
 	//admin login with takeover
-	login := []interface{}{"admin", "login", wac.session.ClientToken, wac.session.ServerToken, wac.session.ClientId, "takeover"}
-	loginChan, err := wac.writeJson(login)
+	login := []interface{}{"admin", "login", session.ClientToken, session.ServerToken, session.ClientId, "takeover"}
+	loginTag, loginChan, err := wac.writeJsonRequest(login)
 	if err != nil {
 		return fmt.Errorf("error writing admin login: %v\n", err)
 	}
 
+	// every step waits with the deadline of ctx, or the timeout of the Conn if it has none
+	initCtx, cancel := wac.requestContext(ctx)
+	defer cancel()
 	select {
 	case r := <-initChan:
 		resp := StatusResponse{RequestType: "init"}
 		if err = json.Unmarshal([]byte(r), &resp); err != nil {
-			return fmt.Errorf("error decoding login connResp: %v\n", err)
+			return invalidResponse("init", r, err)
 		} else if resp.Status != 200 {
-			wac.timeTag = ""
 			return resp
 		}
-	case <-time.After(wac.msgTimeout):
-		wac.timeTag = ""
+	case <-initCtx.Done():
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return fmt.Errorf("restore session init timed out")
 	}
 
 	//wait for s1
-	var connResp []interface{}
+	var connResp string
+	connCtx, cancel := wac.requestContext(ctx)
+	defer cancel()
 	select {
-	case r1 := <-s1:
-		if err := json.Unmarshal([]byte(r1), &connResp); err != nil {
-			wac.timeTag = ""
-			return fmt.Errorf("error decoding s1 message: %v\n", err)
+	case connResp = <-s1:
+	case <-connCtx.Done():
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-	case <-time.After(wac.msgTimeout):
-		wac.timeTag = ""
 		//check for an error message
 		select {
 		case r := <-loginChan:
 			resp := StatusResponse{RequestType: "admin login"}
 			if err = json.Unmarshal([]byte(r), &resp); err != nil {
-				return fmt.Errorf("error decoding login connResp: %v\n", err)
+				return invalidResponse("admin login", r, err)
 			} else if resp.Status != 200 {
 				return fmt.Errorf("admin login errored: %w", wac.getAdminLoginResponseError(resp))
 			}
@@ -450,58 +561,82 @@ func (wac *Conn) Restore() error {
 	}
 
 	//check if challenge is present
-	if len(connResp) == 2 && connResp[0] == "Cmd" && connResp[1].(map[string]interface{})["type"] == "challenge" {
+	cmd, args, err := decodeServerFrame("restore", connResp)
+	if err != nil {
+		return err
+	}
+	if cmd == "Cmd" {
+		challenge, err := decodeCmd("restore", args[0])
+		if err != nil {
+			return err
+		} else if challenge.Type != "challenge" {
+			return invalidResponse("restore", connResp, fmt.Errorf("unexpected %q command", challenge.Type))
+		}
+
 		s2 := make(chan string, 1)
-		wac.listener.Lock()
-		wac.listener.m["s2"] = s2
-		wac.listener.Unlock()
+		wac.addListener("s2", s2)
 
-		if err := wac.resolveChallenge(connResp[1].(map[string]interface{})["challenge"].(string)); err != nil {
-			wac.timeTag = ""
+		if err := wac.resolveChallenge(ctx, session, challenge.Challenge); err != nil {
 			return fmt.Errorf("error resolving challenge: %v\n", err)
 		}
 
+		challengeCtx, cancel := wac.requestContext(ctx)
+		defer cancel()
 		select {
-		case r := <-s2:
-			if err := json.Unmarshal([]byte(r), &connResp); err != nil {
-				wac.timeTag = ""
-				return fmt.Errorf("error decoding s2 message: %v\n", err)
+		case connResp = <-s2:
+		case <-challengeCtx.Done():
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-		case <-time.After(wac.msgTimeout):
-			wac.timeTag = ""
 			return fmt.Errorf("restore session challenge timed out")
 		}
 	}
 
 	//check for login 200 --> login success
+	loginCtx, cancel := wac.requestContext(ctx)
+	defer cancel()
 	select {
 	case r := <-loginChan:
 		resp := StatusResponse{RequestType: "admin login"}
 		if err = json.Unmarshal([]byte(r), &resp); err != nil {
-			wac.timeTag = ""
-			return fmt.Errorf("error decoding login connResp: %v\n", err)
+			return invalidResponse("admin login", r, err)
 		} else if resp.Status != 200 {
-			wac.timeTag = ""
 			return fmt.Errorf("admin login errored: %w", wac.getAdminLoginResponseError(resp))
 		}
-	case <-time.After(wac.msgTimeout):
-		wac.timeTag = ""
+	case <-loginCtx.Done():
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return fmt.Errorf("restore session login timed out")
 	}
 
-	info := connResp[1].(map[string]interface{})
-	if _, ok := info["phone"]; ok {
-		wac.Info = newInfoFromReq(info)
-	} else {
-		return errors.New("not a valid phone version")
+	info, err := decodeLoginInfo("restore", connResp, false)
+	if err != nil {
+		return err
 	}
+	wac.setInfo(info.info())
 
 	//set new tokens
-	wac.session.ClientToken = info["clientToken"].(string)
-	wac.session.ServerToken = info["serverToken"].(string)
-	wac.session.Wid = info["wid"].(string)
-	wac.loggedIn = true
+	updated := *session
+	updated.ClientToken = info.ClientToken
+	updated.ServerToken = info.ServerToken
+	updated.Wid = *info.Wid
+	wac.setSession(&updated)
+	if err = wac.markLoggedIn(ws); err != nil {
+		return err
+	}
+	wac.logger().Info("session restored", "jid", updated.Wid)
+	wac.setState(StateLoggedIn, nil)
+	wac.saveSession()
+	if info := wac.GetInfo(); info != nil {
+		wac.updatePhoneInfo(info)
+	}
 
+	if wac.outbox != nil {
+		// stored messages of an earlier run go first as well
+		wac.outboxBacklog.Store(true)
+		go wac.flushOutbox()
+	}
 	return nil
 }
 
@@ -521,31 +656,35 @@ func (wac *Conn) getAdminLoginResponseError(resp StatusResponse) error {
 	return fmt.Errorf("%d (unknown error)", status)
 }
 
-func (wac *Conn) resolveChallenge(challenge string) error {
+func (wac *Conn) resolveChallenge(ctx context.Context, session *Session, challenge string) error {
 	decoded, err := base64.StdEncoding.DecodeString(challenge)
 	if err != nil {
 		return err
 	}
 
-	h2 := hmac.New(sha256.New, wac.session.MacKey)
+	h2 := hmac.New(sha256.New, session.MacKey)
 	h2.Write([]byte(decoded))
 
-	ch := []interface{}{"admin", "challenge", base64.StdEncoding.EncodeToString(h2.Sum(nil)), wac.session.ServerToken, wac.session.ClientId}
-	challengeChan, err := wac.writeJson(ch)
+	ch := []interface{}{"admin", "challenge", base64.StdEncoding.EncodeToString(h2.Sum(nil)), session.ServerToken, session.ClientId}
+	tag, challengeChan, err := wac.writeJsonRequest(ch)
 	if err != nil {
 		return fmt.Errorf("error writing challenge: %v\n", err)
 	}
 
-	select {
-	case r := <-challengeChan:
-		resp := StatusResponse{RequestType: "login challenge"}
-		if err := json.Unmarshal([]byte(r), &resp); err != nil {
-			return fmt.Errorf("error decoding login resp: %v\n", err)
-		} else if resp.Status != 200 {
-			return resp
-		}
-	case <-time.After(wac.msgTimeout):
+	ctx, cancel := wac.requestContext(ctx)
+	defer cancel()
+	r, err := wac.awaitResponse(ctx, tag, challengeChan)
+	if err == context.DeadlineExceeded {
 		return fmt.Errorf("connection timed out")
+	} else if err != nil {
+		return err
+	}
+
+	resp := StatusResponse{RequestType: "login challenge"}
+	if err := json.Unmarshal([]byte(r), &resp); err != nil {
+		return invalidResponse(resp.RequestType, r, err)
+	} else if resp.Status != 200 {
+		return resp
 	}
 
 	return nil
@@ -561,6 +700,11 @@ func (wac *Conn) Logout() error {
 	if err != nil {
 		return fmt.Errorf("error writing logout: %v\n", err)
 	}
+	wac.logger().Info("logged out")
+	wac.deleteSession()
+	if wac.loggedIn.Load() {
+		wac.setState(StateLoggedOut, nil)
+	}
 
 	return nil
 }
@@ -0,0 +1,127 @@
+package whatsapp_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+func TestSessionStorePersistence(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	srv.RequireChallenge(true)
+	store := whatsapp.NewMemorySessionStore()
+
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithSessionStore(store),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+
+	creds := srv.Pair()
+	if _, err := wac.RestoreWithSession(whatsapp.Session(creds)); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+	tokensSaved := func() bool {
+		saved, err := store.Load(creds.Wid)
+		current := srv.Credentials()
+		return err == nil && saved.ClientToken == current.ClientToken && saved.ServerToken == current.ServerToken
+	}
+	if !tokensSaved() {
+		t.Fatal("session not saved after restore with challenge")
+	}
+
+	if err := srv.RotateTokens(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !tokensSaved() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !tokensSaved() {
+		t.Fatal("session not saved after the tokens were rotated")
+	}
+
+	if err := wac.Logout(); err != nil {
+		t.Fatalf("error logging out: %v", err)
+	}
+	if _, err := store.Load(creds.Wid); !errors.Is(err, whatsapp.ErrSessionNotFound) {
+		t.Errorf("session not deleted on logout: %v", err)
+	}
+}
+
+func TestSessionStoreDeletesUnpairedSession(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	store := whatsapp.NewMemorySessionStore()
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(200*time.Millisecond),
+		whatsapp.WithSessionStore(store),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+
+	session := whatsapp.Session(srv.Pair())
+	session.ClientToken = "invalid"
+	_ = store.Save(session)
+	if _, err := wac.RestoreWithSession(session); !errors.Is(err, whatsapp.ErrUnpaired) {
+		t.Fatalf("expected ErrUnpaired, got %v", err)
+	}
+	if _, err := store.Load(session.Wid); !errors.Is(err, whatsapp.ErrSessionNotFound) {
+		t.Errorf("unpaired session not deleted: %v", err)
+	}
+}
+
+func TestLoginSavesSession(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	store := whatsapp.NewMemorySessionStore()
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithSessionStore(store),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+
+	qr := make(chan string)
+	go func() { _, _ = srv.Scan(<-qr) }()
+	session, err := wac.LoginWithRetry(qr, 0)
+	if err != nil {
+		t.Fatalf("error during login: %v", err)
+	}
+	if saved, err := store.Load(session.Wid); err != nil || !reflect.DeepEqual(saved, session) {
+		t.Errorf("session not saved after login: %v", err)
+	}
+}
+
+func TestRestoreImportedWebSession(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := newTestConn(t, srv, time.Second)
+
+	exported, err := whatsapp.ExportWebSession(whatsapp.Session(srv.Pair()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := whatsapp.ImportWebSession(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wac.RestoreWithSession(session); err != nil {
+		t.Fatalf("error restoring imported session: %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package whatsapp
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+SessionStore persists sessions keyed by their Wid. Implementations must be safe for concurrent use. Load returns
+ErrSessionNotFound if no session is stored for wid, Save replaces a stored session with the same Wid and Delete ignores
+unknown wids. List returns the wids of all stored sessions.
+
+A Conn configured with WithSessionStore saves its session whenever the tokens change and deletes it once it is
+logged out, see WithSessionStore.
+*/
+type SessionStore interface {
+	Load(wid string) (Session, error)
+	Save(session Session) error
+	Delete(wid string) error
+	List() ([]string, error)
+}
+
+// MemorySessionStore keeps sessions in memory, e.g. for tests or if the sessions are persisted elsewhere.
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]Session
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]Session)}
+}
+
+func (s *MemorySessionStore) Load(wid string) (Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[wid]
+	if !ok {
+		return Session{}, fmt.Errorf("%w: %s", ErrSessionNotFound, wid)
+	}
+	return copySession(session), nil
+}
+
+func (s *MemorySessionStore) Save(session Session) error {
+	if session.Wid == "" {
+		return fmt.Errorf("%w: missing wid", ErrInvalidSession)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.Wid] = copySession(session)
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(wid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, wid)
+	return nil
+}
+
+func (s *MemorySessionStore) List() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	wids := make([]string, 0, len(s.sessions))
+	for wid := range s.sessions {
+		wids = append(wids, wid)
+	}
+	sort.Strings(wids)
+	return wids, nil
+}
+
+// copySession returns a session that does not share the keys with session.
+func copySession(session Session) Session {
+	session.EncKey = append([]byte(nil), session.EncKey...)
+	session.MacKey = append([]byte(nil), session.MacKey...)
+	return session
+}
+
+/*
+FileSessionStore stores every session as a file inside a directory. The files are only readable by the owner and
+replaced atomically, so a crash while saving never leaves a broken session behind. The sessions are stored as plain
+JSON, unless the store was created with NewSealedFileSessionStore.
+*/
+type FileSessionStore struct {
+	dir    string
+	sealer *SessionSealer
+	mu     sync.Mutex
+}
+
+const sessionFileSuffix = ".session"
+
+// NewFileSessionStore opens the sessions stored in dir. The directory is created if it does not exist.
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating session directory: %w", err)
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+/*
+NewSealedFileSessionStore works like NewFileSessionStore, but seals the sessions with sealer. Sessions sealed with a
+previous passphrase of sealer are sealed with the current one when they are loaded.
+*/
+func NewSealedFileSessionStore(dir string, sealer *SessionSealer) (*FileSessionStore, error) {
+	if sealer == nil {
+		return nil, fmt.Errorf("%w: missing sealer", ErrInvalidConfig)
+	}
+	store, err := NewFileSessionStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	store.sealer = sealer
+	return store, nil
+}
+
+// path returns the file of wid. The wid is hex encoded, it may contain characters that are not allowed in file names.
+func (s *FileSessionStore) path(wid string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(wid))+sessionFileSuffix)
+}
+
+func (s *FileSessionStore) Load(wid string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.path(wid)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Session{}, fmt.Errorf("%w: %s", ErrSessionNotFound, wid)
+	} else if err != nil {
+		return Session{}, fmt.Errorf("error reading session file: %w", err)
+	}
+	if s.sealer != nil {
+		return s.open(path, data)
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return Session{}, fmt.Errorf("error decoding session file of %s: %w", wid, err)
+	}
+	return session, nil
+}
+
+// open opens the sealed session in data and rotates its key if necessary. s.mu must be held.
+func (s *FileSessionStore) open(path string, data []byte) (Session, error) {
+	session, stale, err := s.sealer.open(data)
+	if err != nil {
+		return Session{}, fmt.Errorf("error opening session file: %w", err)
+	}
+	if stale {
+		resealed, err := s.sealer.Seal(session)
+		if err != nil {
+			return Session{}, fmt.Errorf("error resealing session: %w", err)
+		}
+		if err := s.write(path, resealed); err != nil {
+			return Session{}, err
+		}
+	}
+	return session, nil
+}
+
+func (s *FileSessionStore) Save(session Session) error {
+	if session.Wid == "" {
+		return fmt.Errorf("%w: missing wid", ErrInvalidSession)
+	}
+	var data []byte
+	var err error
+	if s.sealer != nil {
+		data, err = s.sealer.Seal(session)
+	} else {
+		data, err = json.Marshal(session)
+	}
+	if err != nil {
+		return fmt.Errorf("error encoding session: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(s.path(session.Wid), data)
+}
+
+// write replaces the file at path with data. s.mu must be held.
+func (s *FileSessionStore) write(path string, data []byte) error {
+	tmp := filepath.Join(s.dir, "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing session file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("error writing session file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSessionStore) Delete(wid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.path(wid)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing session file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSessionStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading session directory: %w", err)
+	}
+	wids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, sessionFileSuffix) || strings.HasPrefix(name, ".") {
+			continue
+		}
+		wid, err := hex.DecodeString(strings.TrimSuffix(name, sessionFileSuffix))
+		if err != nil {
+			continue
+		}
+		wids = append(wids, string(wid))
+	}
+	sort.Strings(wids)
+	return wids, nil
+}
+
+/*
+saveSession saves the current session to the configured SessionStore. A failure does not affect the connection, it is
+logged and passed to the handlers.
+*/
+func (wac *Conn) saveSession() {
+	if wac.sessions == nil {
+		return
+	}
+	session := wac.getSession()
+	if session == nil || session.Wid == "" {
+		return
+	}
+	if err := wac.sessions.Save(copySession(*session)); err != nil {
+		wac.logger().Error("error saving session", "jid", session.Wid, "err", err)
+		wac.handle(fmt.Errorf("error saving session: %w", err))
+	}
+}
+
+// deleteSession deletes the current session from the configured SessionStore, because it can't be restored anymore.
+func (wac *Conn) deleteSession() {
+	if wac.sessions == nil {
+		return
+	}
+	session := wac.getSession()
+	if session == nil || session.Wid == "" {
+		return
+	}
+	if err := wac.sessions.Delete(session.Wid); err != nil {
+		wac.logger().Error("error deleting session", "jid", session.Wid, "err", err)
+		wac.handle(fmt.Errorf("error deleting session: %w", err))
+	}
+}
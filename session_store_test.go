@@ -0,0 +1,110 @@
+package whatsapp
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func testSession(wid string) Session {
+	return Session{
+		ClientId:    "client",
+		ClientToken: "clientToken",
+		ServerToken: "serverToken",
+		EncKey:      []byte{1, 2, 3},
+		MacKey:      []byte{4, 5, 6},
+		Wid:         wid,
+	}
+}
+
+func testSessionStore(t *testing.T, store SessionStore) {
+	t.Helper()
+	if _, err := store.Load("4915100000001@c.us"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected ErrSessionNotFound, got %v", err)
+	}
+	if err := store.Save(Session{}); !errors.Is(err, ErrInvalidSession) {
+		t.Errorf("expected ErrInvalidSession for a session without wid, got %v", err)
+	}
+
+	first, second := testSession("4915100000001@c.us"), testSession("4915100000002@c.us")
+	for _, s := range []Session{second, first} {
+		if err := store.Save(s); err != nil {
+			t.Fatalf("error saving session: %v", err)
+		}
+	}
+	first.ServerToken = "rotated"
+	if err := store.Save(first); err != nil {
+		t.Fatalf("error replacing session: %v", err)
+	}
+	loaded, err := store.Load(first.Wid)
+	if err != nil {
+		t.Fatalf("error loading session: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, first) {
+		t.Errorf("loaded session differs:\n%+v\n%+v", loaded, first)
+	}
+	loaded.EncKey[0] = 0xff
+	if again, _ := store.Load(first.Wid); again.EncKey[0] != 1 {
+		t.Error("loaded session shares its keys with the store")
+	}
+
+	wids, err := store.List()
+	if err != nil {
+		t.Fatalf("error listing sessions: %v", err)
+	}
+	if !reflect.DeepEqual(wids, []string{first.Wid, second.Wid}) {
+		t.Errorf("unexpected wids %v", wids)
+	}
+
+	if err := store.Delete(first.Wid); err != nil {
+		t.Fatalf("error deleting session: %v", err)
+	}
+	if err := store.Delete(first.Wid); err != nil {
+		t.Errorf("deleting an unknown wid failed: %v", err)
+	}
+	if _, err := store.Load(first.Wid); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound after delete, got %v", err)
+	}
+	if wids, _ := store.List(); !reflect.DeepEqual(wids, []string{second.Wid}) {
+		t.Errorf("unexpected wids after delete %v", wids)
+	}
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	testSessionStore(t, NewMemorySessionStore())
+}
+
+func TestFileSessionStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSessionStore(t, store)
+
+	// sessions survive reopening the store, only readable by the owner
+	reopened, err := NewFileSessionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wid := "4915100000002@c.us"
+	if _, err := reopened.Load(wid); err != nil {
+		t.Fatalf("error loading session after reopening: %v", err)
+	}
+	info, err := os.Stat(reopened.path(wid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("session file has permissions %o", perm)
+	}
+
+	// unrelated files are ignored
+	if err := os.WriteFile(dir+"/notes.txt", []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if wids, _ := reopened.List(); !reflect.DeepEqual(wids, []string{wid}) {
+		t.Errorf("unexpected wids %v", wids)
+	}
+}
@@ -0,0 +1,139 @@
+package whatsapp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ConnState is the state of a Conn as reported by State and to StateHandlers.
+type ConnState int
+
+const (
+	// StateDisconnected means there is no connection to the WhatsAppWeb servers.
+	StateDisconnected ConnState = iota
+	// StateConnecting means the connection is being established or is established, but not logged in.
+	StateConnecting
+	// StateAwaitingQR means a login is running and waits for the qr code to be scanned.
+	StateAwaitingQR
+	// StateLoggingIn means the keys are being exchanged after a scan or a session is being restored.
+	StateLoggingIn
+	// StateLoggedIn means the session is usable.
+	StateLoggedIn
+	// StateReconnecting means the reconnect supervisor waits for the next attempt to restore the session.
+	StateReconnecting
+	// StateReplaced means the session was taken over by another client. It is kept until the next connect.
+	StateReplaced
+	// StateLoggedOut means the session was invalidated, either by Logout or from the phone. It is kept until the next
+	// connect.
+	StateLoggedOut
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "Disconnected"
+	case StateConnecting:
+		return "Connecting"
+	case StateAwaitingQR:
+		return "AwaitingQR"
+	case StateLoggingIn:
+		return "LoggingIn"
+	case StateLoggedIn:
+		return "LoggedIn"
+	case StateReconnecting:
+		return "Reconnecting"
+	case StateReplaced:
+		return "Replaced"
+	case StateLoggedOut:
+		return "LoggedOut"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// stateTransitions lists the states that may follow a state.
+var stateTransitions = map[ConnState][]ConnState{
+	StateDisconnected: {StateConnecting, StateReconnecting},
+	StateConnecting:   {StateDisconnected, StateAwaitingQR, StateLoggingIn},
+	StateAwaitingQR:   {StateDisconnected, StateConnecting, StateLoggingIn},
+	StateLoggingIn:    {StateDisconnected, StateConnecting, StateLoggedIn, StateReplaced, StateLoggedOut},
+	StateLoggedIn:     {StateDisconnected, StateReplaced, StateLoggedOut},
+	StateReconnecting: {StateDisconnected, StateConnecting},
+	StateReplaced:     {StateConnecting, StateAwaitingQR, StateLoggingIn},
+	StateLoggedOut:    {StateConnecting, StateAwaitingQR, StateLoggingIn},
+}
+
+// CanTransition reports whether a Conn in state s may change to state to.
+func (s ConnState) CanTransition(to ConnState) bool {
+	for _, next := range stateTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+StateChange is dispatched to StateHandlers whenever the state of the Conn changes. Err is the cause of the change, if
+it was caused by an error.
+*/
+type StateChange struct {
+	From ConnState
+	To   ConnState
+	Err  error
+}
+
+// State returns the current state of the connection.
+func (wac *Conn) State() ConnState {
+	wac.stateLock.Lock()
+	defer wac.stateLock.Unlock()
+	return wac.state
+}
+
+/*
+setState changes the state of the Conn and dispatches a StateChange. Changing to the current state does nothing.
+Transitions that are not allowed are dropped and reported as an error to the handlers, as they indicate a bug.
+*/
+func (wac *Conn) setState(to ConnState, cause error) {
+	wac.stateLock.Lock()
+	from := wac.state
+	if from == to {
+		wac.stateLock.Unlock()
+		return
+	}
+	if !from.CanTransition(to) {
+		wac.stateLock.Unlock()
+		wac.logger().Error("invalid state transition", "from", from.String(), "to", to.String())
+		wac.handle(fmt.Errorf("%w from %v to %v", ErrInvalidStateTransition, from, to))
+		return
+	}
+	wac.state = to
+	wac.stateLock.Unlock()
+
+	if cause != nil {
+		wac.logger().Info("state changed", "from", from.String(), "state", to.String(), "err", cause)
+	} else {
+		wac.logger().Info("state changed", "from", from.String(), "state", to.String())
+	}
+
+	wac.handle(StateChange{From: from, To: to, Err: cause})
+}
+
+/*
+loginFailed sets the state after a failed Login or Restore. Unpaired and replaced sessions are kept as such, otherwise
+the Conn falls back to the state of the underlying connection.
+*/
+func (wac *Conn) loginFailed(err error) {
+	wac.logger().Warn("login failed", "err", err)
+	switch {
+	case errors.Is(err, ErrUnpaired):
+		// the session can't be restored anymore
+		wac.deleteSession()
+		wac.setState(StateLoggedOut, err)
+	case errors.Is(err, ErrReplaced):
+		wac.setState(StateReplaced, err)
+	case wac.connected.Load():
+		wac.setState(StateConnecting, err)
+	default:
+		wac.setState(StateDisconnected, err)
+	}
+}
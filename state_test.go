@@ -0,0 +1,96 @@
+package whatsapp
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestConnStateTransitions(t *testing.T) {
+	allowed := []struct{ from, to ConnState }{
+		{StateDisconnected, StateConnecting},
+		{StateConnecting, StateAwaitingQR},
+		{StateAwaitingQR, StateLoggingIn},
+		{StateLoggingIn, StateLoggedIn},
+		{StateLoggedIn, StateDisconnected},
+		{StateDisconnected, StateReconnecting},
+		{StateReconnecting, StateConnecting},
+		{StateLoggingIn, StateReplaced},
+		{StateLoggedOut, StateConnecting},
+	}
+	for _, tr := range allowed {
+		if !tr.from.CanTransition(tr.to) {
+			t.Errorf("%v -> %v should be allowed", tr.from, tr.to)
+		}
+	}
+
+	forbidden := []struct{ from, to ConnState }{
+		{StateDisconnected, StateLoggedIn},
+		{StateConnecting, StateLoggedIn},
+		{StateAwaitingQR, StateLoggedIn},
+		{StateLoggedIn, StateAwaitingQR},
+		{StateReplaced, StateLoggedIn},
+		{StateReconnecting, StateLoggedIn},
+	}
+	for _, tr := range forbidden {
+		if tr.from.CanTransition(tr.to) {
+			t.Errorf("%v -> %v should not be allowed", tr.from, tr.to)
+		}
+	}
+}
+
+type stateErrorHandler struct {
+	changes []StateChange
+	errs    []error
+}
+
+func (h *stateErrorHandler) ShouldCallSynchronously() bool { return true }
+func (h *stateErrorHandler) HandleError(err error)         { h.errs = append(h.errs, err) }
+func (h *stateErrorHandler) HandleStateChange(change StateChange) {
+	h.changes = append(h.changes, change)
+}
+
+func TestSetStateRejectsInvalidTransition(t *testing.T) {
+	wac := newConn(DefaultConfig())
+	h := &stateErrorHandler{}
+	wac.AddHandler(h)
+
+	wac.setState(StateLoggedIn, nil)
+	if s := wac.State(); s != StateDisconnected {
+		t.Errorf("invalid transition was applied, state is %v", s)
+	}
+	if len(h.errs) != 1 || !errors.Is(h.errs[0], ErrInvalidStateTransition) {
+		t.Errorf("expected ErrInvalidStateTransition, got %v", h.errs)
+	}
+
+	cause := errors.New("dial failed")
+	wac.setState(StateConnecting, nil)
+	wac.setState(StateConnecting, nil)
+	wac.setState(StateDisconnected, cause)
+	expected := []StateChange{
+		{From: StateDisconnected, To: StateConnecting},
+		{From: StateConnecting, To: StateDisconnected, Err: cause},
+	}
+	if len(h.changes) != len(expected) || h.changes[0] != expected[0] || h.changes[1] != expected[1] {
+		t.Errorf("expected changes %v, got %v", expected, h.changes)
+	}
+}
+
+func TestDisconnectCmdBeforeLogin(t *testing.T) {
+	for _, kind := range []string{"replaced", ""} {
+		wac := newConn(DefaultConfig())
+		h := &stateErrorHandler{}
+		wac.AddHandler(h)
+		wac.setState(StateConnecting, nil)
+
+		if err := wac.handleCmd(json.RawMessage(`{"type":"disconnect","kind":"` + kind + `"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if s := wac.State(); s != StateConnecting {
+			t.Errorf("kind %q: unexpected state %v", kind, s)
+		}
+		if len(h.errs) != 0 {
+			t.Errorf("kind %q: unexpected errors %v", kind, h.errs)
+		}
+	}
+}
@@ -1,11 +1,18 @@
 package whatsapp
 
 import (
-	"github.com/cristalinojr/go-whatsapp/binary"
 	"strings"
+	"sync"
+
+	"github.com/cristalinojr/go-whatsapp/binary"
 )
 
+/*
+Store holds the contacts and chats received from the phone. The maps are updated while the connection is in use, read
+them with the methods of Store, which are safe for concurrent use.
+*/
 type Store struct {
+	mu       sync.RWMutex
 	Contacts map[string]Contact
 	Chats    map[string]Chat
 }
@@ -28,9 +35,47 @@ type Chat struct {
 
 func newStore() *Store {
 	return &Store{
-		make(map[string]Contact),
-		make(map[string]Chat),
+		Contacts: make(map[string]Contact),
+		Chats:    make(map[string]Chat),
+	}
+}
+
+// GetContact returns the contact with the given jid.
+func (s *Store) GetContact(jid string) (Contact, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.Contacts[jid]
+	return c, ok
+}
+
+// GetContacts returns a copy of all contacts by jid.
+func (s *Store) GetContacts() map[string]Contact {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	contacts := make(map[string]Contact, len(s.Contacts))
+	for jid, c := range s.Contacts {
+		contacts[jid] = c
+	}
+	return contacts
+}
+
+// GetChat returns the chat with the given jid.
+func (s *Store) GetChat(jid string) (Chat, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.Chats[jid]
+	return c, ok
+}
+
+// GetChats returns a copy of all chats by jid.
+func (s *Store) GetChats() map[string]Chat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	chats := make(map[string]Chat, len(s.Chats))
+	for jid, c := range s.Chats {
+		chats[jid] = c
 	}
+	return chats
 }
 
 func (wac *Conn) updateContacts(contacts interface{}) {
@@ -39,6 +84,8 @@ func (wac *Conn) updateContacts(contacts interface{}) {
 		return
 	}
 
+	wac.Store.mu.Lock()
+	defer wac.Store.mu.Unlock()
 	for _, contact := range c {
 		contactNode, ok := contact.(binary.Node)
 		if !ok {
@@ -61,6 +108,8 @@ func (wac *Conn) updateChats(chats interface{}) {
 		return
 	}
 
+	wac.Store.mu.Lock()
+	defer wac.Store.mu.Unlock()
 	for _, chat := range c {
 		chatNode, ok := chat.(binary.Node)
 		if !ok {
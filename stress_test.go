@@ -0,0 +1,147 @@
+package whatsapp_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+// nopHandler makes the dispatcher walk through the common handler interfaces.
+type nopHandler struct{}
+
+func (nopHandler) HandleError(err error)                            {}
+func (nopHandler) HandleTextMessage(message whatsapp.TextMessage)   {}
+func (nopHandler) HandleJsonMessage(message string)                 {}
+func (nopHandler) HandleContactList(contacts []whatsapp.Contact)    {}
+func (nopHandler) HandleChatList(chats []whatsapp.Chat)             {}
+func (nopHandler) HandleStateChange(change whatsapp.StateChange)    {}
+func (nopHandler) HandleConnectionEvent(e whatsapp.ConnectionEvent) {}
+
+// hammer runs f in n goroutines until stop is closed.
+func hammer(wg *sync.WaitGroup, stop <-chan struct{}, n int, f func(i int)) {
+	for g := 0; g < n; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				f(g*1000000 + i)
+			}
+		}(g)
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := restoredTestConn(t, srv)
+	wac.AddHandler(nopHandler{})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	hammer(&wg, stop, 4, func(i int) {
+		_, _ = wac.Send(whatsapp.TextMessage{
+			Info: whatsapp.MessageInfo{RemoteJid: fmt.Sprintf("49151%08d@s.whatsapp.net", i%10)},
+			Text: "stress",
+		})
+	})
+	hammer(&wg, stop, 1, func(i int) {
+		jid := fmt.Sprintf("49151%08d@s.whatsapp.net", i%50)
+		_ = srv.PushContacts(whatsapptest.Contact{Jid: jid, Name: "contact"})
+		_ = srv.PushChats(whatsapptest.Chat{Jid: jid, Name: "chat"})
+		time.Sleep(time.Millisecond)
+	})
+	hammer(&wg, stop, 2, func(i int) {
+		for jid := range wac.Store.GetContacts() {
+			_, _ = wac.Store.GetContact(jid)
+		}
+		for jid := range wac.Store.GetChats() {
+			_, _ = wac.Store.GetChat(jid)
+		}
+		_ = wac.GetInfo()
+		_ = wac.LastSeen()
+		_ = wac.State()
+		_ = wac.IsConnected()
+		_ = wac.IsLoggedIn()
+		_ = wac.IsLoginInProgress()
+		_ = wac.QueueStats()
+	})
+	hammer(&wg, stop, 1, func(i int) {
+		wac.RemoveHandler(newRecorder(wac))
+	})
+
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	if len(wac.Store.GetContacts()) == 0 || len(wac.Store.GetChats()) == 0 {
+		t.Error("no contacts or chats were stored")
+	}
+	if len(srv.Messages()) == 0 {
+		t.Error("no messages were relayed")
+	}
+}
+
+func TestConcurrentReconnects(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+	wac := newTestConn(t, srv, time.Second)
+	wac.AddHandler(nopHandler{})
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	hammer(&wg, stop, 2, func(i int) {
+		_, _ = wac.Send(whatsapp.TextMessage{
+			Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"},
+			Text: "stress",
+		})
+	})
+	hammer(&wg, stop, 2, func(i int) {
+		_ = wac.GetInfo()
+		_ = wac.State()
+		_ = wac.IsLoggedIn()
+		_ = wac.AdminTest()
+	})
+	// Restore and Disconnect race against each other and against the read loop tearing down dropped connections
+	hammer(&wg, stop, 2, func(i int) {
+		switch i % 3 {
+		case 0:
+			_ = wac.Restore()
+		case 1:
+			_, _ = wac.Disconnect()
+		case 2:
+			srv.DropConnections()
+		}
+		time.Sleep(time.Millisecond)
+	})
+
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	// the Conn has to be usable after the storm. A restore interrupted after the server rotated the tokens leaves the
+	// client with outdated ones, so the current credentials are restored.
+	_, _ = wac.Disconnect()
+	if _, err := wac.RestoreWithSession(whatsapp.Session(*srv.Credentials())); err != nil {
+		t.Fatalf("error restoring session after concurrent use: %v", err)
+	}
+	if _, err := wac.Send(whatsapp.TextMessage{
+		Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"},
+		Text: "still alive",
+	}); err != nil {
+		t.Errorf("error sending after concurrent use: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package whatsapp
+
+import (
+	"context"
+)
+
+/*
+Attribute is a key value pair describing a Span, e.g. the jid a message is sent to.
+*/
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Attr creates an Attribute.
+func Attr(key string, value interface{}) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+/*
+Tracer creates spans around the operations of a Conn. It is shaped like the OpenTelemetry tracing API, so an adapter
+is a few lines, but the package does not depend on it. Start must return a context carrying the new span; spans
+started from that context are its children, and the trace context of the caller's context is the parent of the
+outermost span. Implementations must be safe for concurrent use.
+*/
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+/*
+Span is a single traced operation. End is called exactly once, RecordError before it if the operation failed.
+*/
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(attrs ...Attribute) {}
+func (nopSpan) RecordError(err error)            {}
+func (nopSpan) End()                             {}
+
+/*
+startSpan starts a span with the configured Tracer. Without a Tracer, ctx is returned unchanged with a span that does
+nothing.
+*/
+func (wac *Conn) startSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if wac.tracer == nil {
+		return ctx, nopSpan{}
+	}
+	return wac.tracer.Start(ctx, name, attrs...)
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
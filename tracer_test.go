@@ -0,0 +1,143 @@
+package whatsapp_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cristalinojr/go-whatsapp"
+	"github.com/cristalinojr/go-whatsapp/whatsapptest"
+)
+
+type recordedSpan struct {
+	tracer *recordingTracer
+	name   string
+	parent string
+	attrs  map[string]interface{}
+	err    error
+	ended  bool
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...whatsapp.Attribute) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.err = err
+}
+
+func (s *recordedSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.ended = true
+}
+
+type spanKey struct{}
+
+// recordingTracer keeps every span, the parent is taken from the span stored in the context.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attrs ...whatsapp.Attribute) (context.Context, whatsapp.Span) {
+	span := &recordedSpan{tracer: t, name: name, attrs: make(map[string]interface{})}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	for _, a := range attrs {
+		span.attrs[a.Key] = a.Value
+	}
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *recordingTracer) find(name string) *recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestTracerSpans(t *testing.T) {
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+
+	tracer := &recordingTracer{}
+	wac, err := whatsapp.NewConnWithOptions(
+		whatsapp.WithTransport(&whatsapp.WebsocketTransport{URL: srv.URL}),
+		whatsapp.WithTimeout(time.Second),
+		whatsapp.WithTracer(tracer),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+	if _, err := wac.RestoreWithSession(whatsapp.Session(srv.Pair())); err != nil {
+		t.Fatalf("error restoring session: %v", err)
+	}
+
+	// the caller's span is the parent of the outermost span
+	ctx, caller := tracer.Start(context.Background(), "caller")
+	if _, err := wac.SendContext(ctx, whatsapp.TextMessage{Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Text: "hello"}); err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	caller.End()
+	// the fake server answers the media conn query without hosts, so the upload fails
+	if _, err := wac.SendContext(ctx, whatsapp.ImageMessage{Info: whatsapp.MessageInfo{RemoteJid: "4915100000000@s.whatsapp.net"}, Content: strings.NewReader("image")}); err == nil {
+		t.Fatal("expected image upload to fail")
+	}
+	if _, err := wac.ContactsContext(context.Background()); err != nil {
+		t.Fatalf("error querying contacts: %v", err)
+	}
+
+	for _, want := range []struct{ name, parent string }{
+		{"whatsapp.Restore", ""},
+		{"whatsapp.Send", "caller"},
+		{"whatsapp.relay", "whatsapp.Send"},
+		{"whatsapp.ack", "whatsapp.relay"},
+		{"whatsapp.Upload", "whatsapp.Send"},
+		{"whatsapp.queryMediaConn", "whatsapp.Upload"},
+		{"whatsapp.query", ""},
+	} {
+		span := tracer.find(want.name)
+		if span == nil {
+			t.Errorf("no %s span", want.name)
+			continue
+		}
+		tracer.mu.Lock()
+		if span.parent != want.parent || !span.ended {
+			t.Errorf("span %s: parent %q, ended %v", want.name, span.parent, span.ended)
+		}
+		tracer.mu.Unlock()
+	}
+
+	send := tracer.find("whatsapp.Send")
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if send.attrs["jid"] != "4915100000000@s.whatsapp.net" || send.attrs["message.id"] == "" || send.err != nil {
+		t.Errorf("unexpected send span %+v", send)
+	}
+	if last := tracer.spans[len(tracer.spans)-1]; last.name != "whatsapp.query" || last.attrs["query.type"] != "contacts" {
+		t.Errorf("unexpected last span %+v", last)
+	}
+	for _, s := range tracer.spans {
+		if s.name == "whatsapp.Upload" && s.err == nil {
+			t.Error("failed upload recorded no error")
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package whatsapp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Frame types used by Transport implementations. They match the message types of github.com/gorilla/websocket.
+const (
+	TextFrame   = websocket.TextMessage
+	BinaryFrame = websocket.BinaryMessage
+)
+
+const (
+	// DefaultURL is the websocket endpoint of the WhatsAppWeb servers.
+	DefaultURL = "wss://web.whatsapp.com/ws"
+	// DefaultOrigin is the Origin header sent when dialing the WhatsAppWeb servers.
+	DefaultOrigin = "https://web.whatsapp.com"
+)
+
+/*
+Transport establishes the connection a Conn speaks the WhatsAppWeb protocol over. The default is a WebsocketTransport
+dialing the WhatsAppWeb servers, but any implementation delivering the same frames can be used, e.g. to connect to a
+local server, a relay or to replay a recorded session.
+*/
+type Transport interface {
+	Dial(ctx context.Context) (TransportConn, error)
+}
+
+/*
+TransportConn is a single established connection. ReadFrame is only called from one goroutine at a time, the same
+holds for WriteFrame. Close may be called concurrently to both and must unblock a pending ReadFrame.
+*/
+type TransportConn interface {
+	ReadFrame() (frameType int, data []byte, err error)
+	WriteFrame(frameType int, data []byte) error
+	Close() error
+}
+
+/*
+WebsocketTransport is the default Transport. Unset fields fall back to the values used for the WhatsAppWeb servers.
+*/
+type WebsocketTransport struct {
+	// URL of the websocket endpoint, DefaultURL if empty.
+	URL string
+	// Header is sent with the handshake. If it has no Origin, DefaultOrigin is added.
+	Header http.Header
+	// TLSConfig is used for wss:// URLs.
+	TLSConfig *tls.Config
+	// Proxy returns the proxy to use for a request, no proxy is used if nil.
+	Proxy func(*http.Request) (*url.URL, error)
+	// NetDialContext dials the underlying network connection, net.Dialer is used if nil.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// HandshakeTimeout limits the websocket handshake. The deadline of the dial context applies if zero.
+	HandshakeTimeout time.Duration
+}
+
+func (t *WebsocketTransport) Dial(ctx context.Context) (TransportConn, error) {
+	dialer := &websocket.Dialer{
+		ReadBufferSize:   0,
+		WriteBufferSize:  0,
+		HandshakeTimeout: t.HandshakeTimeout,
+		Proxy:            t.Proxy,
+		TLSClientConfig:  t.TLSConfig,
+		NetDialContext:   t.NetDialContext,
+	}
+
+	u := t.URL
+	if u == "" {
+		u = DefaultURL
+	}
+
+	headers := http.Header{}
+	for k, v := range t.Header {
+		headers[k] = v
+	}
+	if headers.Get("Origin") == "" {
+		headers.Set("Origin", DefaultOrigin)
+	}
+
+	conn, _, err := dialer.DialContext(ctx, u, headers)
+	if err != nil {
+		return nil, err
+	}
+	return &websocketConn{conn: conn}, nil
+}
+
+type websocketConn struct {
+	conn *websocket.Conn
+}
+
+func (c *websocketConn) ReadFrame() (int, []byte, error) {
+	frameType, data, err := c.conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return frameType, data, &ErrConnectionClosed{Code: closeErr.Code, Text: closeErr.Text}
+	}
+	return frameType, data, err
+}
+
+func (c *websocketConn) WriteFrame(frameType int, data []byte) error {
+	return c.conn.WriteMessage(frameType, data)
+}
+
+func (c *websocketConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,82 @@
+package whatsapp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type pipeTransport struct {
+	conn *pipeConn
+}
+
+func (t *pipeTransport) Dial(ctx context.Context) (TransportConn, error) {
+	return t.conn, nil
+}
+
+type pipeConn struct {
+	in     chan []byte
+	out    chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeConn() *pipeConn {
+	return &pipeConn{
+		in:     make(chan []byte, 16),
+		out:    make(chan []byte, 16),
+		closed: make(chan struct{}),
+	}
+}
+
+func (c *pipeConn) ReadFrame() (int, []byte, error) {
+	select {
+	case data := <-c.in:
+		return TextFrame, data, nil
+	case <-c.closed:
+		return 0, nil, errors.New("closed")
+	}
+}
+
+func (c *pipeConn) WriteFrame(frameType int, data []byte) error {
+	select {
+	case c.out <- data:
+		return nil
+	case <-c.closed:
+		return errors.New("closed")
+	}
+}
+
+func (c *pipeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestConnUsesTransport(t *testing.T) {
+	pipe := newPipeConn()
+	wac, err := NewConnWithTransport(time.Second, &pipeTransport{conn: pipe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wac.Disconnect()
+
+	select {
+	case frame := <-pipe.out:
+		if string(frame) != "?,," {
+			t.Fatalf("expected keep-alive frame, got %q", frame)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no keep-alive written to the transport")
+	}
+
+	pipe.in <- []byte("!1600000000123")
+	deadline := time.Now().Add(time.Second)
+	for wac.LastSeen().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want := time.Unix(1600000000, 123*int64(time.Millisecond)); !wac.LastSeen().Equal(want) {
+		t.Errorf("server last seen: got %v, want %v", wac.LastSeen(), want)
+	}
+}
@@ -0,0 +1,158 @@
+package whatsapp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/*
+WhatsAppWeb keeps its session in the localStorage of the browser, and the other legacy WhatsAppWeb clients reuse that
+layout: Baileys imports the WABrowserId, WASecretBundle, WAToken1 and WAToken2 items, whatsapp-web.js stores exactly
+these items as its session and restores whole localStorage dumps. Every item is a JSON encoded string, e.g.
+"\"tokenvalue\"", and WASecretBundle holds a JSON object with the base64 encoded keys.
+*/
+const (
+	webStorageClientId = "WABrowserId"
+	webStorageSecrets  = "WASecretBundle"
+	webStorageClient   = "WAToken1"
+	webStorageServer   = "WAToken2"
+	webStorageWid      = "last-wid"
+)
+
+type webSecretBundle struct {
+	EncKey string `json:"encKey"`
+	MacKey string `json:"macKey"`
+}
+
+/*
+ImportWebSession converts a session in the localStorage layout of WhatsAppWeb into a Session. It accepts the session
+objects of Baileys and whatsapp-web.js as well as complete localStorage dumps, other items are ignored. The Wid is
+taken from the last-wid item if present, it is set by the server on the next restore otherwise.
+*/
+func ImportWebSession(data []byte) (Session, error) {
+	var items map[string]json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return Session{}, fmt.Errorf("%w: %v", ErrInvalidSession, err)
+	}
+
+	var session Session
+	var err error
+	if session.ClientId, err = webStorageString(items, webStorageClientId, true); err != nil {
+		return Session{}, err
+	}
+	if session.ClientToken, err = webStorageString(items, webStorageClient, true); err != nil {
+		return Session{}, err
+	}
+	if session.ServerToken, err = webStorageString(items, webStorageServer, true); err != nil {
+		return Session{}, err
+	}
+	if session.Wid, err = webStorageString(items, webStorageWid, false); err != nil {
+		return Session{}, err
+	}
+
+	bundle, ok := items[webStorageSecrets]
+	if !ok {
+		return Session{}, fmt.Errorf("%w: missing %s", ErrInvalidSession, webStorageSecrets)
+	}
+	// the bundle is a JSON encoded string in localStorage, Baileys also accepts the decoded object
+	if s, err := unquoteWebStorage(bundle); err == nil {
+		bundle = json.RawMessage(s)
+	}
+	var secrets webSecretBundle
+	if err := json.Unmarshal(bundle, &secrets); err != nil {
+		return Session{}, fmt.Errorf("%w: invalid %s: %v", ErrInvalidSession, webStorageSecrets, err)
+	}
+	if session.EncKey, err = base64.StdEncoding.DecodeString(secrets.EncKey); err != nil {
+		return Session{}, fmt.Errorf("%w: invalid encKey: %v", ErrInvalidSession, err)
+	}
+	if session.MacKey, err = base64.StdEncoding.DecodeString(secrets.MacKey); err != nil {
+		return Session{}, fmt.Errorf("%w: invalid macKey: %v", ErrInvalidSession, err)
+	}
+
+	if err := validateSession(session); err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+/*
+ExportWebSession converts session into the localStorage layout of WhatsAppWeb, which Baileys and whatsapp-web.js
+restore. The result can be passed to ImportWebSession again.
+*/
+func ExportWebSession(session Session) ([]byte, error) {
+	if err := validateSession(session); err != nil {
+		return nil, err
+	}
+	bundle, err := json.Marshal(webSecretBundle{
+		EncKey: base64.StdEncoding.EncodeToString(session.EncKey),
+		MacKey: base64.StdEncoding.EncodeToString(session.MacKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := map[string]string{
+		webStorageClientId: quoteWebStorage(session.ClientId),
+		webStorageSecrets:  string(bundle),
+		webStorageClient:   quoteWebStorage(session.ClientToken),
+		webStorageServer:   quoteWebStorage(session.ServerToken),
+	}
+	if session.Wid != "" {
+		items[webStorageWid] = quoteWebStorage(session.Wid)
+	}
+	return json.MarshalIndent(items, "", "  ")
+}
+
+// webStorageString returns the string stored as item key, which may or may not be JSON encoded once more.
+func webStorageString(items map[string]json.RawMessage, key string, required bool) (string, error) {
+	raw, ok := items[key]
+	if !ok || string(raw) == "null" {
+		if required {
+			return "", fmt.Errorf("%w: missing %s", ErrInvalidSession, key)
+		}
+		return "", nil
+	}
+	s, err := unquoteWebStorage(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s is not a string", ErrInvalidSession, key)
+	}
+	if inner, err := unquoteWebStorage(json.RawMessage(s)); err == nil {
+		s = inner
+	}
+	if required && s == "" {
+		return "", fmt.Errorf("%w: empty %s", ErrInvalidSession, key)
+	}
+	return s, nil
+}
+
+func unquoteWebStorage(raw json.RawMessage) (string, error) {
+	var s string
+	err := json.Unmarshal(raw, &s)
+	return s, err
+}
+
+func quoteWebStorage(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+/*
+validateSession checks that session can be restored: the ClientId is the base64 encoding of 16 bytes, both tokens are
+set, both keys have 32 bytes and the Wid, if any, is a jid.
+*/
+func validateSession(session Session) error {
+	clientId, err := base64.StdEncoding.DecodeString(session.ClientId)
+	switch {
+	case err != nil || len(clientId) != 16:
+		return fmt.Errorf("%w: client id is not 16 base64 encoded bytes", ErrInvalidSession)
+	case session.ClientToken == "" || session.ServerToken == "":
+		return fmt.Errorf("%w: missing token", ErrInvalidSession)
+	case len(session.EncKey) != 32 || len(session.MacKey) != 32:
+		return fmt.Errorf("%w: keys must have 32 bytes", ErrInvalidSession)
+	case session.Wid != "" && !strings.Contains(session.Wid, "@"):
+		return fmt.Errorf("%w: invalid wid %q", ErrInvalidSession, session.Wid)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package whatsapp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func webTestSession() Session {
+	return Session{
+		ClientId:    base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16)),
+		ClientToken: "1@clientToken+/=",
+		ServerToken: "1@serverToken+/=",
+		EncKey:      bytes.Repeat([]byte{2}, 32),
+		MacKey:      bytes.Repeat([]byte{3}, 32),
+		Wid:         "4915100000001@c.us",
+	}
+}
+
+func TestWebSessionRoundTrip(t *testing.T) {
+	session := webTestSession()
+	exported, err := ExportWebSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items map[string]string
+	if err := json.Unmarshal(exported, &items); err != nil {
+		t.Fatalf("export is not a localStorage object: %v", err)
+	}
+	if items["WAToken1"] != `"1@clientToken+/="` || items["last-wid"] != `"4915100000001@c.us"` {
+		t.Errorf("items are not JSON encoded strings: %v", items)
+	}
+
+	imported, err := ImportWebSession(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imported, session) {
+		t.Errorf("round trip changed the session:\n%+v\n%+v", imported, session)
+	}
+
+	// without a wid, the server sets it on the next restore
+	session.Wid = ""
+	exported, _ = ExportWebSession(session)
+	if imported, err := ImportWebSession(exported); err != nil || !reflect.DeepEqual(imported, session) {
+		t.Errorf("round trip without wid failed: %v", err)
+	}
+}
+
+func TestImportWebSession(t *testing.T) {
+	want := webTestSession()
+	encKey := base64.StdEncoding.EncodeToString(want.EncKey)
+	macKey := base64.StdEncoding.EncodeToString(want.MacKey)
+
+	tests := map[string]string{
+		// the browser credentials Baileys loads, the bundle holds the private key as well
+		"baileys": `{
+			"WABrowserId": "\"` + want.ClientId + `\"",
+			"WASecretBundle": "{\"key\":\"cHJpdmF0ZQ==\",\"encKey\":\"` + encKey + `\",\"macKey\":\"` + macKey + `\"}",
+			"WAToken1": "\"1@clientToken+/=\"",
+			"WAToken2": "\"1@serverToken+/=\"",
+			"last-wid": "\"4915100000001@c.us\""
+		}`,
+		// Baileys also accepts the decoded bundle and unquoted strings
+		"decoded": `{
+			"WABrowserId": "` + want.ClientId + `",
+			"WASecretBundle": {"encKey": "` + encKey + `", "macKey": "` + macKey + `"},
+			"WAToken1": "1@clientToken+/=",
+			"WAToken2": "1@serverToken+/=",
+			"last-wid": "4915100000001@c.us"
+		}`,
+		// a localStorage dump as restored by whatsapp-web.js
+		"whatsapp-web.js": `{
+			"WABrowserId": "\"` + want.ClientId + `\"",
+			"WASecretBundle": "{\"key\":\"cHJpdmF0ZQ==\",\"encKey\":\"` + encKey + `\",\"macKey\":\"` + macKey + `\"}",
+			"WAToken1": "\"1@clientToken+/=\"",
+			"WAToken2": "\"1@serverToken+/=\"",
+			"last-wid": "\"4915100000001@c.us\"",
+			"WALangPref": "\"de\"",
+			"remember-me": "true",
+			"storage_test": null
+		}`,
+	}
+	for name, data := range tests {
+		session, err := ImportWebSession([]byte(data))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(session, want) {
+			t.Errorf("%s: unexpected session\n%+v\n%+v", name, session, want)
+		}
+	}
+}
+
+func TestImportWebSessionValidation(t *testing.T) {
+	exported, _ := ExportWebSession(webTestSession())
+	var valid map[string]interface{}
+	_ = json.Unmarshal(exported, &valid)
+
+	tests := map[string]func(items map[string]interface{}){
+		"missing client id":    func(items map[string]interface{}) { delete(items, "WABrowserId") },
+		"missing token":        func(items map[string]interface{}) { items["WAToken2"] = `""` },
+		"missing bundle":       func(items map[string]interface{}) { delete(items, "WASecretBundle") },
+		"bundle not an object": func(items map[string]interface{}) { items["WASecretBundle"] = `"keys"` },
+		"short client id":      func(items map[string]interface{}) { items["WABrowserId"] = `"AQID"` },
+		"invalid wid":          func(items map[string]interface{}) { items["last-wid"] = `"4915100000001"` },
+		"token not a string":   func(items map[string]interface{}) { items["WAToken1"] = 42 },
+		"short key": func(items map[string]interface{}) {
+			items["WASecretBundle"] = `{"encKey":"AQID","macKey":"AQID"}`
+		},
+		"key not base64": func(items map[string]interface{}) {
+			items["WASecretBundle"] = `{"encKey":"!!","macKey":"!!"}`
+		},
+	}
+	for name, modify := range tests {
+		items := make(map[string]interface{})
+		for k, v := range valid {
+			items[k] = v
+		}
+		modify(items)
+		data, _ := json.Marshal(items)
+		if _, err := ImportWebSession(data); !errors.Is(err, ErrInvalidSession) {
+			t.Errorf("%s: expected ErrInvalidSession, got %v", name, err)
+		}
+	}
+	if _, err := ImportWebSession([]byte(`["WABrowserId"]`)); !errors.Is(err, ErrInvalidSession) {
+		t.Errorf("expected ErrInvalidSession for a non-object, got %v", err)
+	}
+
+	invalid := webTestSession()
+	invalid.MacKey = nil
+	if _, err := ExportWebSession(invalid); err == nil || !strings.Contains(err.Error(), "32 bytes") {
+		t.Errorf("exported an invalid session: %v", err)
+	}
+}
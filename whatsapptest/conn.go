@@ -0,0 +1,308 @@
+package whatsapptest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/cristalinojr/go-whatsapp/binary"
+	"github.com/cristalinojr/go-whatsapp/crypto/cbc"
+)
+
+// serverConn is the server side of a single client connection.
+type serverConn struct {
+	srv *Server
+	ws  *websocket.Conn
+
+	writeLock sync.Mutex
+
+	mu        sync.Mutex
+	clientId  string
+	creds     *Credentials
+	challenge []byte
+	loginTag  string
+}
+
+func (c *serverConn) setCreds(creds *Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.creds = creds
+}
+
+func (c *serverConn) keys() (encKey, macKey []byte, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.creds == nil {
+		return nil, nil, ErrNotLoggedIn
+	}
+	return c.creds.EncKey, c.creds.MacKey, nil
+}
+
+func (c *serverConn) writeRaw(msgType int, data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.ws.WriteMessage(msgType, data)
+}
+
+func (c *serverConn) writeJSON(tag string, v interface{}) error {
+	d, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeRaw(websocket.TextMessage, []byte(tag+","+string(d)))
+}
+
+func (c *serverConn) writeNode(tag string, n binary.Node) error {
+	encKey, macKey, err := c.keys()
+	if err != nil {
+		return err
+	}
+	b, err := binary.Marshal(n)
+	if err != nil {
+		return err
+	}
+	cipher, err := cbc.Encrypt(encKey, nil, b)
+	if err != nil {
+		return err
+	}
+	h := hmac.New(sha256.New, macKey)
+	h.Write(cipher)
+
+	data := append([]byte(tag+","), h.Sum(nil)...)
+	data = append(data, cipher...)
+	return c.writeRaw(websocket.BinaryMessage, data)
+}
+
+func (c *serverConn) decrypt(msg []byte) (*binary.Node, error) {
+	encKey, macKey, err := c.keys()
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) < 33 {
+		return nil, errors.New("binary frame too short")
+	}
+	h := hmac.New(sha256.New, macKey)
+	h.Write(msg[32:])
+	if !hmac.Equal(h.Sum(nil), msg[:32]) {
+		return nil, errors.New("invalid hmac")
+	}
+	d, err := cbc.Decrypt(encKey, nil, msg[32:])
+	if err != nil {
+		return nil, err
+	}
+	return binary.Unmarshal(d)
+}
+
+func (c *serverConn) handleJSON(tag string, args []interface{}) {
+	command, _ := args[0].(string)
+	kind, _ := args[1].(string)
+
+	if command == "admin" {
+		switch kind {
+		case "init":
+			if len(args) > 4 {
+				if clientId, ok := args[4].(string); ok {
+					c.srv.register(c, clientId)
+				}
+			}
+			c.srv.mu.Lock()
+			ttl := c.srv.qrTTL
+			c.srv.mu.Unlock()
+			c.reply(tag, map[string]interface{}{
+				"status": 200,
+				"ref":    "1@" + randomToken(),
+				"ttl":    ttl / time.Millisecond,
+				"update": false,
+				"curr":   "2.2142.12",
+				"time":   time.Now().UnixNano() / int64(time.Millisecond),
+			})
+			return
+		case "login":
+			c.login(tag, args)
+			return
+		case "challenge":
+			c.solveChallenge(tag, args)
+			return
+		case "test":
+			// an unreachable phone does not answer at all
+			c.srv.mu.Lock()
+			connected := c.srv.account.Connected
+			c.srv.mu.Unlock()
+			if connected {
+				c.reply(tag, []interface{}{"Pong", true})
+			}
+			return
+		case "Conn":
+			// logout
+			c.srv.mu.Lock()
+			c.srv.creds = nil
+			c.srv.mu.Unlock()
+			return
+		}
+	}
+
+	c.srv.mu.Lock()
+	h := c.srv.jsonHandlers[command+"/"+kind]
+	c.srv.mu.Unlock()
+
+	var resp interface{} = map[string]interface{}{"status": 200}
+	if h != nil {
+		resp = h(args)
+	}
+	c.reply(tag, resp)
+}
+
+func (c *serverConn) login(tag string, args []interface{}) {
+	if len(args) < 5 {
+		c.reply(tag, map[string]interface{}{"status": 400})
+		return
+	}
+	clientToken, _ := args[2].(string)
+	serverToken, _ := args[3].(string)
+	clientId, _ := args[4].(string)
+	c.srv.register(c, clientId)
+
+	c.srv.mu.Lock()
+	creds := c.srv.creds
+	valid := creds != nil && creds.ClientId == clientId && creds.ClientToken == clientToken && creds.ServerToken == serverToken
+	challenge := c.srv.requireChallenge
+	c.srv.mu.Unlock()
+
+	if !valid {
+		c.reply(tag, map[string]interface{}{"status": 401})
+		return
+	}
+	c.setCreds(creds)
+
+	if challenge {
+		c.mu.Lock()
+		c.challenge = randomBytes(32)
+		c.loginTag = tag
+		ch := base64.StdEncoding.EncodeToString(c.challenge)
+		c.mu.Unlock()
+		_ = c.writeJSON("s1", []interface{}{"Cmd", map[string]interface{}{"type": "challenge", "challenge": ch}})
+		return
+	}
+
+	_ = c.writeJSON("s1", []interface{}{"Conn", c.srv.rotateTokens()})
+	c.srv.setActive(c)
+	c.reply(tag, map[string]interface{}{"status": 200})
+}
+
+func (c *serverConn) solveChallenge(tag string, args []interface{}) {
+	c.mu.Lock()
+	challenge, loginTag, creds := c.challenge, c.loginTag, c.creds
+	c.challenge, c.loginTag = nil, ""
+	c.mu.Unlock()
+
+	answer := ""
+	if len(args) > 2 {
+		answer, _ = args[2].(string)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(answer)
+	if challenge == nil || creds == nil || err != nil {
+		c.reply(tag, map[string]interface{}{"status": 400})
+		return
+	}
+	h := hmac.New(sha256.New, creds.MacKey)
+	h.Write(challenge)
+	if !hmac.Equal(h.Sum(nil), decoded) {
+		c.reply(tag, map[string]interface{}{"status": 401})
+		return
+	}
+
+	c.reply(tag, map[string]interface{}{"status": 200})
+	if loginTag == "" {
+		// challenged while logged in
+		return
+	}
+	_ = c.writeJSON("s2", []interface{}{"Conn", c.srv.rotateTokens()})
+	c.srv.setActive(c)
+	c.reply(loginTag, map[string]interface{}{"status": 200})
+}
+
+func (c *serverConn) handleNode(tag string, n *binary.Node) {
+	switch n.Description {
+	case "action":
+		status := 200
+		if n.Attributes["type"] == "relay" {
+			c.srv.mu.Lock()
+			status = c.srv.ackStatus
+			c.srv.mu.Unlock()
+		} else if child := firstChild(n); child != nil {
+			c.srv.mu.Lock()
+			h := c.srv.actionHandlers[child.Description]
+			c.srv.mu.Unlock()
+			if h != nil {
+				c.reply(tag, h(n))
+				return
+			}
+		}
+		c.reply(tag, map[string]interface{}{"status": status, "t": time.Now().Unix()})
+	case "query":
+		queryType := n.Attributes["type"]
+		c.srv.mu.Lock()
+		h := c.srv.nodeHandlers[queryType]
+		c.srv.mu.Unlock()
+
+		resp := &binary.Node{Description: "response", Attributes: map[string]string{"type": queryType}}
+		if h != nil {
+			resp = h(n)
+		}
+		c.srv.respond(func() {
+			_ = c.writeNode(tag, *resp)
+		})
+	default:
+		c.reply(tag, map[string]interface{}{"status": 400})
+	}
+}
+
+func (c *serverConn) reply(tag string, v interface{}) {
+	c.srv.respond(func() {
+		if err := c.writeJSON(tag, v); err != nil {
+			_ = c.ws.Close()
+		}
+	})
+}
+
+// rotateTokens issues new tokens for the paired client and returns the payload of the following Conn frame.
+func (s *Server) rotateTokens() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.creds != nil {
+		s.creds.ClientToken = randomToken()
+		s.creds.ServerToken = randomToken()
+	}
+	return s.connInfoLocked()
+}
+
+func (f Frame) String() string {
+	if f.Node != nil {
+		return fmt.Sprintf("%s,<%s %v>", f.Tag, f.Node.Description, f.Node.Attributes)
+	}
+	return fmt.Sprintf("%s,%v", f.Tag, f.JSON)
+}
+
+// firstChild returns the first node in the content of n, or nil.
+func firstChild(n *binary.Node) *binary.Node {
+	switch content := n.Content.(type) {
+	case []binary.Node:
+		if len(content) > 0 {
+			return &content[0]
+		}
+	case []interface{}:
+		if len(content) > 0 {
+			if child, ok := content[0].(binary.Node); ok {
+				return &child
+			}
+		}
+	}
+	return nil
+}
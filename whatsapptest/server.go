@@ -0,0 +1,796 @@
+/*
+Package whatsapptest provides an in-process stand-in for the WhatsAppWeb servers. It speaks the same protocol as
+whatsapp.Conn (admin init/login, the s1 handshake including the curve25519/HKDF key exchange, challenges,
+keep-alives and encrypted binary frames), so login, restore and send paths can be tested without a phone.
+
+A typical test points a Conn at the server and scans the qr code on behalf of the phone:
+
+	srv := whatsapptest.NewServer()
+	defer srv.Close()
+
+	wac, _ := whatsapp.NewConnWithTransport(time.Second, &whatsapp.WebsocketTransport{URL: srv.URL})
+	qr := make(chan string)
+	go func() { srv.Scan(<-qr) }()
+	session, err := wac.Login(qr)
+*/
+package whatsapptest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mau.fi/whatsmeow/binary/proto"
+
+	"github.com/cristalinojr/go-whatsapp/binary"
+	"github.com/cristalinojr/go-whatsapp/crypto/cbc"
+	"github.com/cristalinojr/go-whatsapp/crypto/curve25519"
+	"github.com/cristalinojr/go-whatsapp/crypto/hkdf"
+)
+
+var (
+	ErrNotLoggedIn   = errors.New("no client logged in")
+	ErrUnknownClient = errors.New("no connection with that client id")
+	ErrInvalidQR     = errors.New("invalid qr code")
+	ErrTimeout       = errors.New("timed out waiting for frame")
+)
+
+/*
+Credentials are the secrets shared between the server and a paired client. The fields match whatsapp.Session, so
+Credentials can be converted directly: whatsapp.Session(creds).
+*/
+type Credentials struct {
+	ClientId    string
+	ClientToken string
+	ServerToken string
+	EncKey      []byte
+	MacKey      []byte
+	Wid         string
+}
+
+// Account describes the phone the server pretends to be connected to.
+type Account struct {
+	Wid       string
+	Pushname  string
+	Platform  string
+	Battery   int
+	Plugged   bool
+	Connected bool
+}
+
+// Contact is pushed to the client with PushContacts.
+type Contact struct {
+	Jid    string
+	Notify string
+	Name   string
+	Short  string
+}
+
+// Chat is pushed to the client with PushChats.
+type Chat struct {
+	Jid             string
+	Name            string
+	Unread          string
+	LastMessageTime string
+	IsMuted         string
+	IsMarkedSpam    string
+}
+
+/*
+Frame is a request received from a client. JSON requests have JSON set, binary requests carry the decrypted Node
+together with the metric and flag bytes.
+*/
+type Frame struct {
+	Tag    string
+	JSON   []interface{}
+	Node   *binary.Node
+	Metric byte
+	Flag   byte
+}
+
+// JSONHandler answers a JSON request. The returned value is marshalled and sent with the tag of the request.
+type JSONHandler func(args []interface{}) interface{}
+
+/*
+ActionHandler answers a binary action. The returned value is marshalled and sent with the tag of the request, it
+should carry a "status".
+*/
+type ActionHandler func(action *binary.Node) interface{}
+
+// NodeHandler answers a binary query. The returned node is encrypted and sent with the tag of the request.
+type NodeHandler func(query *binary.Node) *binary.Node
+
+// Server is a fake WhatsAppWeb server listening on a local port.
+type Server struct {
+	// URL is the websocket endpoint to point a whatsapp.WebsocketTransport at.
+	URL string
+
+	http     *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu               sync.Mutex
+	account          Account
+	creds            *Credentials
+	conns            map[string]*serverConn
+	active           *serverConn
+	frames           []Frame
+	framesChanged    chan struct{}
+	messagesSeen     int
+	jsonHandlers     map[string]JSONHandler
+	nodeHandlers     map[string]NodeHandler
+	actionHandlers   map[string]ActionHandler
+	requireChallenge bool
+	ackStatus        int
+	delay            time.Duration
+	qrTTL            time.Duration
+	tagCount         int
+	clockOffset      time.Duration
+	dropKeepAlives   bool
+}
+
+// NewServer starts a server on a random local port. It must be stopped with Close.
+func NewServer() *Server {
+	s := &Server{
+		account: Account{
+			Wid:       "4915112345678@c.us",
+			Pushname:  "whatsapptest",
+			Platform:  "android",
+			Battery:   80,
+			Plugged:   true,
+			Connected: true,
+		},
+		conns:          make(map[string]*serverConn),
+		framesChanged:  make(chan struct{}),
+		jsonHandlers:   make(map[string]JSONHandler),
+		nodeHandlers:   make(map[string]NodeHandler),
+		actionHandlers: make(map[string]ActionHandler),
+		ackStatus:      200,
+		qrTTL:          20 * time.Second,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.serveWs)
+	s.http = httptest.NewServer(mux)
+	s.URL = "ws" + strings.TrimPrefix(s.http.URL, "http") + "/ws"
+	return s
+}
+
+// Close disconnects all clients and stops the server.
+func (s *Server) Close() {
+	s.DropConnections()
+	s.http.Close()
+}
+
+// SetAccount changes the phone information sent with every successful login.
+func (s *Server) SetAccount(account Account) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.account = account
+}
+
+// SetQRTTL changes the time after which clients have to request a new qr code.
+func (s *Server) SetQRTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.qrTTL = ttl
+}
+
+// RequireChallenge makes every following restore resolve a challenge before it succeeds.
+func (s *Server) RequireChallenge(require bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requireChallenge = require
+}
+
+// SetAckStatus sets the status the server acknowledges relayed messages with. The default is 200.
+func (s *Server) SetAckStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ackStatus = status
+}
+
+// SetClockOffset makes the server clock, as sent with every keep-alive, run ahead of the local clock by offset.
+func (s *Server) SetClockOffset(offset time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clockOffset = offset
+}
+
+// DropKeepAlives makes the server ignore keep-alives, like a stalled connection would.
+func (s *Server) DropKeepAlives(drop bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropKeepAlives = drop
+}
+
+// SetResponseDelay delays every response to a tagged request, e.g. to exercise timeouts and cancellation.
+func (s *Server) SetResponseDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+/*
+HandleJSON registers a handler for JSON requests starting with the given command and kind, e.g.
+HandleJSON("query", "exist", ...). Unhandled requests are answered with {"status":200}.
+*/
+func (s *Server) HandleJSON(command, kind string, h JSONHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jsonHandlers[command+"/"+kind] = h
+}
+
+/*
+HandleQuery registers a handler for binary queries of the given type, e.g. "contacts" or "message". Unhandled queries
+are answered with an empty response node.
+*/
+func (s *Server) HandleQuery(queryType string, h NodeHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodeHandlers[queryType] = h
+}
+
+/*
+HandleAction registers a handler for binary actions whose first child is a node with the given description, e.g.
+"group" or "presence". Unhandled actions are answered with {"status":200}.
+*/
+func (s *Server) HandleAction(child string, h ActionHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actionHandlers[child] = h
+}
+
+// Credentials returns the credentials of the currently paired client, or nil.
+func (s *Server) Credentials() *Credentials {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.creds == nil {
+		return nil
+	}
+	c := *s.creds
+	return &c
+}
+
+/*
+Pair creates credentials for a new client without a qr code scan. The result can be converted to a whatsapp.Session
+and restored.
+*/
+func (s *Server) Pair() Credentials {
+	clientId := make([]byte, 16)
+	_, _ = rand.Read(clientId)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds = &Credentials{
+		ClientId:    base64.StdEncoding.EncodeToString(clientId),
+		ClientToken: randomToken(),
+		ServerToken: randomToken(),
+		EncKey:      randomBytes(32),
+		MacKey:      randomBytes(32),
+		Wid:         s.account.Wid,
+	}
+	return *s.creds
+}
+
+/*
+Scan acts like a phone scanning the qr code a client pushed into its qr channel. The key exchange is completed and the
+client is logged in.
+*/
+func (s *Server) Scan(qr string) (Credentials, error) {
+	parts := strings.Split(qr, ",")
+	if len(parts) != 3 {
+		return Credentials{}, ErrInvalidQR
+	}
+	clientPub, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(clientPub) != 32 {
+		return Credentials{}, ErrInvalidQR
+	}
+
+	s.mu.Lock()
+	c, ok := s.conns[parts[2]]
+	s.mu.Unlock()
+	if !ok {
+		return Credentials{}, ErrUnknownClient
+	}
+
+	priv, pub, err := curve25519.GenerateKey()
+	if err != nil {
+		return Credentials{}, err
+	}
+	var clientKey [32]byte
+	copy(clientKey[:], clientPub)
+	shared := curve25519.GenerateSharedSecret(*priv, clientKey)
+
+	h := hmac.New(sha256.New, make([]byte, 32))
+	h.Write(shared)
+	expanded, err := hkdf.Expand(h.Sum(nil), 80, "")
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	creds := Credentials{
+		ClientId:    parts[2],
+		ClientToken: randomToken(),
+		ServerToken: randomToken(),
+		EncKey:      randomBytes(32),
+		MacKey:      randomBytes(32),
+	}
+	keys, err := cbc.Encrypt(expanded[:32], expanded[64:80], append(append([]byte{}, creds.EncKey...), creds.MacKey...))
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	signed := append(append([]byte{}, pub[:]...), keys...)
+	mac := hmac.New(sha256.New, expanded[32:64])
+	mac.Write(signed)
+
+	secret := append(append(append([]byte{}, pub[:]...), mac.Sum(nil)...), keys...)
+
+	s.mu.Lock()
+	creds.Wid = s.account.Wid
+	s.creds = &creds
+	info := s.connInfoLocked()
+	s.mu.Unlock()
+
+	info["secret"] = base64.StdEncoding.EncodeToString(secret)
+	c.setCreds(&creds)
+	if err := c.writeJSON("s1", []interface{}{"Conn", info}); err != nil {
+		return Credentials{}, err
+	}
+	s.setActive(c)
+	return creds, nil
+}
+
+/*
+Frames returns all requests received so far, in order.
+*/
+func (s *Server) Frames() []Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Frame{}, s.frames...)
+}
+
+// Messages returns all messages relayed by clients so far, in order.
+func (s *Server) Messages() []*proto.WebMessageInfo {
+	var messages []*proto.WebMessageInfo
+	for _, f := range s.Frames() {
+		messages = append(messages, relayedMessages(f)...)
+	}
+	return messages
+}
+
+/*
+WaitForFrame blocks until a request matching the predicate was received and returns it. Requests received before the
+call are considered as well.
+*/
+func (s *Server) WaitForFrame(timeout time.Duration, match func(Frame) bool) (Frame, error) {
+	deadline := time.After(timeout)
+	seen := 0
+	for {
+		s.mu.Lock()
+		frames := s.frames[seen:]
+		changed := s.framesChanged
+		s.mu.Unlock()
+
+		for _, f := range frames {
+			if match(f) {
+				return f, nil
+			}
+		}
+		seen += len(frames)
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return Frame{}, ErrTimeout
+		}
+	}
+}
+
+// WaitForMessage blocks until a client relayed a message and returns the first one not seen by a previous call.
+func (s *Server) WaitForMessage(timeout time.Duration) (*proto.WebMessageInfo, error) {
+	s.mu.Lock()
+	skip := s.messagesSeen
+	s.mu.Unlock()
+
+	var msg *proto.WebMessageInfo
+	_, err := s.WaitForFrame(timeout, func(f Frame) bool {
+		relayed := relayedMessages(f)
+		if len(relayed) <= skip {
+			skip -= len(relayed)
+			return false
+		}
+		msg = relayed[skip]
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.messagesSeen++
+	s.mu.Unlock()
+	return msg, nil
+}
+
+// PushMessage delivers incoming messages to the logged in client.
+func (s *Server) PushMessage(messages ...*proto.WebMessageInfo) error {
+	content := make([]interface{}, len(messages))
+	for i, m := range messages {
+		content[i] = m
+	}
+	return s.PushNode(binary.Node{
+		Description: "action",
+		Attributes:  map[string]string{"add": "relay"},
+		Content:     content,
+	})
+}
+
+// attributes builds node attributes from key value pairs. Empty values are left out, they can not be encoded.
+func attributes(kv ...string) map[string]string {
+	attrs := make(map[string]string, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			attrs[kv[i]] = kv[i+1]
+		}
+	}
+	return attrs
+}
+
+/*
+SetPhoneConnected changes whether the phone is reachable. Admin tests are not answered while it is not, and the
+logged in client is told about the change with a ["Conn",{...}] frame if notify is set.
+*/
+func (s *Server) SetPhoneConnected(connected, notify bool) error {
+	s.mu.Lock()
+	s.account.Connected = connected
+	info := s.connInfoLocked()
+	s.mu.Unlock()
+	if !notify {
+		return nil
+	}
+	return s.PushJSON([]interface{}{"Conn", info})
+}
+
+// RotateTokens issues new tokens for the paired client and sends them to the logged in client.
+func (s *Server) RotateTokens() error {
+	return s.PushJSON([]interface{}{"Conn", s.rotateTokens()})
+}
+
+/*
+Replace takes the session over like a second client logging in: the logged in client is sent
+["Cmd",{"type":"disconnect","kind":"replaced"}] and disconnected.
+*/
+func (s *Server) Replace() error {
+	return s.disconnectActive(map[string]interface{}{"type": "disconnect", "kind": "replaced"})
+}
+
+/*
+LogoutRemotely logs the session out like the phone does: the credentials are dropped, the logged in client is sent
+["Cmd",{"type":"disconnect"}] and disconnected.
+*/
+func (s *Server) LogoutRemotely() error {
+	s.mu.Lock()
+	s.creds = nil
+	s.mu.Unlock()
+	return s.disconnectActive(map[string]interface{}{"type": "disconnect"})
+}
+
+// disconnectActive sends the ["Cmd",cmd] frame to the logged in client and closes its connection.
+func (s *Server) disconnectActive(cmd map[string]interface{}) error {
+	c := s.activeConn()
+	if c == nil {
+		return ErrNotLoggedIn
+	}
+	err := c.writeJSON(s.nextTag(), []interface{}{"Cmd", cmd})
+
+	s.mu.Lock()
+	if s.active == c {
+		s.active = nil
+	}
+	delete(s.conns, c.clientId)
+	s.mu.Unlock()
+	_ = c.ws.Close()
+	return err
+}
+
+/*
+Challenge challenges the logged in client with a ["Cmd",{"type":"challenge"}] frame, it has to sign the challenge with
+its mac key. The challenge is answered with status 200 if the signature is right.
+*/
+func (s *Server) Challenge() error {
+	c := s.activeConn()
+	if c == nil {
+		return ErrNotLoggedIn
+	}
+	c.mu.Lock()
+	c.challenge = randomBytes(32)
+	c.loginTag = ""
+	ch := base64.StdEncoding.EncodeToString(c.challenge)
+	c.mu.Unlock()
+	return c.writeJSON(s.nextTag(), []interface{}{"Cmd", map[string]interface{}{"type": "challenge", "challenge": ch}})
+}
+
+// PushBattery sends a battery update of the phone to the logged in client.
+func (s *Server) PushBattery(percentage int, plugged bool) error {
+	s.mu.Lock()
+	s.account.Battery = percentage
+	s.account.Plugged = plugged
+	s.mu.Unlock()
+	return s.PushNode(binary.Node{
+		Description: "action",
+		Attributes:  map[string]string{"add": "update"},
+		Content: []interface{}{binary.Node{
+			Description: "battery",
+			Attributes: map[string]string{
+				"value":     strconv.Itoa(percentage),
+				"live":      strconv.FormatBool(plugged),
+				"powersave": "false",
+			},
+		}},
+	})
+}
+
+// PushContacts sends the contact list to the logged in client.
+func (s *Server) PushContacts(contacts ...Contact) error {
+	content := make([]interface{}, len(contacts))
+	for i, c := range contacts {
+		content[i] = binary.Node{
+			Description: "user",
+			Attributes: attributes(
+				"jid", c.Jid,
+				"notify", c.Notify,
+				"name", c.Name,
+				"short", c.Short,
+			),
+		}
+	}
+	return s.PushNode(binary.Node{
+		Description: "response",
+		Attributes:  map[string]string{"type": "contacts"},
+		Content:     content,
+	})
+}
+
+// PushChats sends the chat list to the logged in client.
+func (s *Server) PushChats(chats ...Chat) error {
+	content := make([]interface{}, len(chats))
+	for i, c := range chats {
+		content[i] = binary.Node{
+			Description: "chat",
+			Attributes: attributes(
+				"jid", c.Jid,
+				"name", c.Name,
+				"count", c.Unread,
+				"t", c.LastMessageTime,
+				"mute", c.IsMuted,
+				"spam", c.IsMarkedSpam,
+			),
+		}
+	}
+	return s.PushNode(binary.Node{
+		Description: "response",
+		Attributes:  map[string]string{"type": "chat"},
+		Content:     content,
+	})
+}
+
+/*
+PushNode encrypts and sends a binary node to the logged in client. Nodes with attributes must carry their children as
+[]interface{}, as required by binary.Marshal.
+*/
+func (s *Server) PushNode(n binary.Node) error {
+	c := s.activeConn()
+	if c == nil {
+		return ErrNotLoggedIn
+	}
+	return c.writeNode(s.nextTag(), n)
+}
+
+// PushJSON sends an unsolicited JSON frame, e.g. ["Presence",{...}], to the logged in client.
+func (s *Server) PushJSON(v interface{}) error {
+	c := s.activeConn()
+	if c == nil {
+		return ErrNotLoggedIn
+	}
+	return c.writeJSON(s.nextTag(), v)
+}
+
+// DropConnections closes all client connections without a websocket close handshake, like a dead network would.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	conns := make([]*serverConn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.conns = make(map[string]*serverConn)
+	s.active = nil
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		_ = c.ws.Close()
+	}
+}
+
+func (s *Server) serveWs(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &serverConn{srv: s, ws: ws}
+	defer func() {
+		_ = ws.Close()
+		s.mu.Lock()
+		if s.conns[c.clientId] == c {
+			delete(s.conns, c.clientId)
+		}
+		if s.active == c {
+			s.active = nil
+		}
+		s.mu.Unlock()
+	}()
+
+	for {
+		msgType, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if msgType == websocket.TextMessage && string(data) == "?,," {
+			s.mu.Lock()
+			drop, now := s.dropKeepAlives, time.Now().Add(s.clockOffset)
+			s.mu.Unlock()
+			if !drop {
+				_ = c.writeRaw(websocket.TextMessage, []byte("!"+strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)))
+			}
+			continue
+		}
+
+		parts := strings.SplitN(string(data), ",", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		tag, payload := parts[0], []byte(parts[1])
+
+		if msgType == websocket.BinaryMessage {
+			if len(payload) < 2 {
+				continue
+			}
+			node, err := c.decrypt(payload[2:])
+			if err != nil {
+				continue
+			}
+			s.record(Frame{Tag: tag, Node: node, Metric: payload[0], Flag: payload[1]})
+			c.handleNode(tag, node)
+			continue
+		}
+
+		var args []interface{}
+		if err := json.Unmarshal(payload, &args); err != nil || len(args) < 2 {
+			continue
+		}
+		s.record(Frame{Tag: tag, JSON: args})
+		c.handleJSON(tag, args)
+	}
+}
+
+func (s *Server) record(f Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frames = append(s.frames, f)
+	close(s.framesChanged)
+	s.framesChanged = make(chan struct{})
+}
+
+func (s *Server) register(c *serverConn, clientId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.clientId = clientId
+	s.conns[clientId] = c
+}
+
+func (s *Server) setActive(c *serverConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active = c
+}
+
+func (s *Server) activeConn() *serverConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+func (s *Server) nextTag() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tagCount++
+	return fmt.Sprintf("%d.--%d", time.Now().Unix(), s.tagCount)
+}
+
+// respond runs f after the configured response delay.
+func (s *Server) respond(f func()) {
+	s.mu.Lock()
+	delay := s.delay
+	s.mu.Unlock()
+
+	if delay <= 0 {
+		f()
+		return
+	}
+	go func() {
+		time.Sleep(delay)
+		f()
+	}()
+}
+
+// connInfoLocked builds the payload of a ["Conn",{...}] frame. s.mu must be held.
+func (s *Server) connInfoLocked() map[string]interface{} {
+	info := map[string]interface{}{
+		"battery":   s.account.Battery,
+		"platform":  s.account.Platform,
+		"connected": s.account.Connected,
+		"pushname":  s.account.Pushname,
+		"wid":       s.account.Wid,
+		"lc":        "DE",
+		"lg":        "de",
+		"plugged":   s.account.Plugged,
+		"tos":       0,
+		"is24h":     true,
+		"ref":       "1@" + randomToken(),
+		"phone": map[string]interface{}{
+			"mcc":                 "262",
+			"mnc":                 "001",
+			"os_version":          "11",
+			"device_manufacturer": "whatsapptest",
+			"device_model":        "fake",
+			"os_build_number":     "1",
+			"wa_version":          "2.21.1",
+		},
+	}
+	if s.creds != nil {
+		info["clientToken"] = s.creds.ClientToken
+		info["serverToken"] = s.creds.ServerToken
+	}
+	return info
+}
+
+func relayedMessages(f Frame) []*proto.WebMessageInfo {
+	if f.Node == nil || f.Node.Description != "action" || f.Node.Attributes["type"] != "relay" {
+		return nil
+	}
+	content, ok := f.Node.Content.([]interface{})
+	if !ok {
+		return nil
+	}
+	var messages []*proto.WebMessageInfo
+	for _, c := range content {
+		if m, ok := c.(*proto.WebMessageInfo); ok {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
+
+func randomToken() string {
+	return base64.StdEncoding.EncodeToString(randomBytes(24))
+}
@@ -1,11 +1,13 @@
 package whatsapp
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -16,17 +18,26 @@ import (
 
 //writeJson enqueues a json message into the writeChan
 func (wac *Conn) writeJson(data []interface{}) (<-chan string, error) {
+	_, ch, err := wac.writeJsonRequest(data)
+	return ch, err
+}
+
+// writeJsonRequest works like writeJson, but additionally returns the message tag the response is correlated with
+func (wac *Conn) writeJsonRequest(data []interface{}) (string, <-chan string, error) {
 
 	wac.writerLock.Lock()
 	defer wac.writerLock.Unlock()
+	if wac.closing.Load() {
+		return "", nil, ErrClosed
+	}
 
 	d, err := json.Marshal(data)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
 	ts := time.Now().Unix()
-	messageTag := fmt.Sprintf("%d.--%d", ts, wac.msgCount)
+	messageTag := fmt.Sprintf("%d.--%d", ts, wac.messageCount())
 	bytes := []byte(fmt.Sprintf("%s,%s", messageTag, d))
 
 	if wac.timeTag == "" {
@@ -36,11 +47,24 @@ func (wac *Conn) writeJson(data []interface{}) (<-chan string, error) {
 
 	ch, err := wac.write(websocket.TextMessage, messageTag, bytes)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
+	wac.logger().Debug("sent json frame", "tag", messageTag)
 
-	wac.msgCount++
-	return ch, nil
+	atomic.AddInt64(&wac.msgCount, 1)
+	return messageTag, ch, nil
+}
+
+func (wac *Conn) getTimeTag() string {
+	wac.writerLock.RLock()
+	defer wac.writerLock.RUnlock()
+	return wac.timeTag
+}
+
+func (wac *Conn) resetTimeTag() {
+	wac.writerLock.Lock()
+	defer wac.writerLock.Unlock()
+	wac.timeTag = ""
 }
 
 func (wac *Conn) writeBinary(node binary.Node, metric metric, flag flag, messageTag string) (<-chan string, error) {
@@ -50,6 +74,9 @@ func (wac *Conn) writeBinary(node binary.Node, metric metric, flag flag, message
 
 	wac.writerLock.Lock()
 	defer wac.writerLock.Unlock()
+	if wac.closing.Load() {
+		return nil, ErrClosed
+	}
 
 	data, err := wac.encryptBinaryMessage(node)
 	if err != nil {
@@ -64,27 +91,36 @@ func (wac *Conn) writeBinary(node binary.Node, metric metric, flag flag, message
 	if err != nil {
 		return nil, fmt.Errorf("failed to write message: %w", err)
 	}
+	wac.logger().Debug("sent binary frame", "tag", messageTag, "metric", int(metric), "flag", int(flag))
 
-	wac.msgCount++
+	atomic.AddInt64(&wac.msgCount, 1)
 	return ch, nil
 }
 
 func (wac *Conn) sendKeepAlive() error {
 	bytes := []byte("?,,")
+	sent := time.Now()
 	respChan, err := wac.write(websocket.TextMessage, "!", bytes)
 	if err != nil {
 		return fmt.Errorf("error sending keepAlive: %w", err)
 	}
 
 	select {
-	case resp := <-respChan:
+	case resp, ok := <-respChan:
+		if !ok {
+			return ErrClosed
+		}
+		rtt := time.Since(sent)
 		msecs, err := strconv.ParseInt(resp, 10, 64)
 		if err != nil {
 			return fmt.Errorf("Error converting time string to uint: %w", err)
 		}
-		wac.ServerLastSeen = time.Unix(msecs/1000, (msecs%1000)*int64(time.Millisecond))
+		serverTime := time.Unix(msecs/1000, (msecs%1000)*int64(time.Millisecond))
+		wac.setLastSeen(serverTime)
+		wac.quality.keepAliveAnswered(sent, rtt, serverTime)
 
 	case <-time.After(wac.msgTimeout):
+		wac.removeListener("!")
 		return ErrConnectionTimeout
 	}
 
@@ -95,57 +131,215 @@ func (wac *Conn) sendKeepAlive() error {
 	When phone is unreachable, WhatsAppWeb sends ["admin","test"] time after time to try a successful contact.
 	Tested with Airplane mode and no connection at all.
 */
-func (wac *Conn) sendAdminTest() error {
+func (wac *Conn) sendAdminTest(ctx context.Context) error {
 	data := []interface{}{"admin", "test"}
 
-	r, err := wac.writeJson(data)
+	tag, r, err := wac.writeJsonRequest(data)
 	if err != nil {
 		return fmt.Errorf("error sending admin test: %w", err)
 	}
 
-	var response []interface{}
-	var resp string
+	resp, err := wac.awaitResponse(ctx, tag, r)
+	if err == context.DeadlineExceeded {
+		return ErrConnectionTimeout
+	} else if err != nil {
+		return err
+	}
+	return decodePong(resp)
+}
+
+/*
+requestContext derives the context a single request waits with. If ctx carries no deadline, the timeout the Conn was
+created with is applied.
+*/
+func (wac *Conn) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, ok := ctx.Deadline(); ok || wac.msgTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, wac.msgTimeout)
+}
 
+/*
+awaitResponse waits for the response to the request sent with messageTag. If ctx is done first, the pending listener
+is removed and the context error is returned. If the Conn is closed first, ErrClosed is returned.
+*/
+func (wac *Conn) awaitResponse(ctx context.Context, messageTag string, ch <-chan string) (string, error) {
 	select {
-	case resp = <-r:
-		if err := json.Unmarshal([]byte(resp), &response); err != nil {
-			return fmt.Errorf("error decoding response message: %v\n", err)
+	case r, ok := <-ch:
+		if !ok {
+			return "", ErrClosed
 		}
-	case <-time.After(wac.msgTimeout):
-		return ErrConnectionTimeout
+		return r, nil
+	case <-wac.closed:
+		wac.removeListener(messageTag)
+		return "", ErrClosed
+	case <-ctx.Done():
+		wac.removeListener(messageTag)
+		return "", ctx.Err()
 	}
+}
+
+// abandonedListenerAge is the time after which the listener of an unanswered request is dropped.
+const abandonedListenerAge = 5 * time.Minute
+
+// addListener registers a listener that is kept until it received a message or is removed.
+func (wac *Conn) addListener(messageTag string, ch chan string) {
+	wac.listener.Lock()
+	n := len(wac.listener.m)
+	wac.listener.m[messageTag] = ch
+	delta := len(wac.listener.m) - n
+	wac.listener.Unlock()
+	wac.pendingRequestsChanged(delta)
+}
+
+// addRequestListener registers the listener of a request, it expires if no response arrives.
+func (wac *Conn) addRequestListener(messageTag string, ch chan string) {
+	wac.listener.Lock()
+	n := len(wac.listener.m)
+	wac.listener.m[messageTag] = ch
+	wac.listener.expires[messageTag] = time.Now().Add(abandonedListenerAge)
+	delta := len(wac.listener.m) - n
+	wac.listener.Unlock()
+	wac.pendingRequestsChanged(delta)
+}
+
+func (wac *Conn) removeListener(messageTag string) {
+	wac.listener.Lock()
+	n := len(wac.listener.m)
+	delete(wac.listener.m, messageTag)
+	delete(wac.listener.expires, messageTag)
+	wac.listener.broadcast()
+	delta := len(wac.listener.m) - n
+	wac.listener.Unlock()
+	wac.pendingRequestsChanged(delta)
+}
 
-	if len(response) == 2 && response[0].(string) == "Pong" && response[1].(bool) == true {
-		return nil
-	} else {
-		return fmt.Errorf("unexpected ping response: %s", resp)
+// takeListener removes the listener of messageTag and returns it, so a response is delivered at most once.
+func (wac *Conn) takeListener(messageTag string) (chan string, bool) {
+	wac.listener.Lock()
+	ch, ok := wac.listener.m[messageTag]
+	delete(wac.listener.m, messageTag)
+	delete(wac.listener.expires, messageTag)
+	if ok {
+		wac.listener.broadcast()
 	}
+	wac.listener.Unlock()
+	if ok {
+		wac.pendingRequestsChanged(-1)
+	}
+	return ch, ok
 }
 
-func (wac *Conn) write(messageType int, answerMessageTag string, data []byte) (<-chan string, error) {
-	var ch chan string
-	if answerMessageTag != "" {
-		ch = make(chan string, 1)
+// expireListeners drops the listeners of requests that were not answered in time.
+func (wac *Conn) expireListeners(now time.Time) {
+	wac.listener.Lock()
+	n := len(wac.listener.m)
+	for tag, expires := range wac.listener.expires {
+		if now.After(expires) {
+			delete(wac.listener.m, tag)
+			delete(wac.listener.expires, tag)
+			wac.listener.broadcast()
+		}
+	}
+	delta := len(wac.listener.m) - n
+	wac.listener.Unlock()
+	wac.pendingRequestsChanged(delta)
+}
+
+/*
+pendingRequestsChanged reports a change of the number of requests waiting for a response. The change is computed while
+wac.listener is locked, so the reports add up to the right number in whatever order they arrive.
+*/
+func (wac *Conn) pendingRequestsChanged(delta int) {
+	if delta != 0 {
+		wac.observer().PendingRequestsChanged(delta)
+	}
+}
 
+// pendingRequests counts the requests waiting for a response, without the keep-alive. wac.listener must be locked.
+func (wac *Conn) pendingRequests() int {
+	n := len(wac.listener.expires)
+	if _, ok := wac.listener.expires["!"]; ok {
+		n--
+	}
+	return n
+}
+
+// drainRequests waits until no request waits for a response anymore, or until ctx is done.
+func (wac *Conn) drainRequests(ctx context.Context) (pending int, err error) {
+	for {
 		wac.listener.Lock()
-		wac.listener.m[answerMessageTag] = ch
+		pending = wac.pendingRequests()
+		removed := wac.listener.removed()
 		wac.listener.Unlock()
+		if pending == 0 {
+			return 0, nil
+		}
+		select {
+		case <-removed:
+		case <-ctx.Done():
+			return pending, ctx.Err()
+		}
 	}
+}
 
-	if wac == nil || wac.ws == nil {
+/*
+failRequests makes everyone waiting for the response to a request fail with ErrClosed. The listeners of the requests
+are released, their channels are closed for callers of the deprecated methods that return them.
+*/
+func (wac *Conn) failRequests() {
+	wac.closeOnce.Do(func() {
+		if wac.closed != nil {
+			close(wac.closed)
+		}
+	})
+
+	wac.listener.Lock()
+	n := len(wac.listener.m)
+	for tag := range wac.listener.expires {
+		if ch, ok := wac.listener.m[tag]; ok {
+			close(ch)
+		}
+		delete(wac.listener.m, tag)
+		delete(wac.listener.expires, tag)
+	}
+	wac.listener.broadcast()
+	delta := len(wac.listener.m) - n
+	wac.listener.Unlock()
+	wac.pendingRequestsChanged(delta)
+}
+
+func (wac *Conn) write(messageType int, answerMessageTag string, data []byte) (<-chan string, error) {
+	if wac == nil {
+		return nil, ErrInvalidWebsocket
+	}
+	ws := wac.websocket()
+	if ws == nil {
 		return nil, ErrInvalidWebsocket
 	}
-	wac.ws.Lock()
-	err := wac.ws.conn.WriteMessage(messageType, data)
-	wac.ws.Unlock()
+
+	var ch chan string
+	if answerMessageTag != "" {
+		ch = make(chan string, 1)
+		wac.addRequestListener(answerMessageTag, ch)
+	}
+
+	ws.Lock()
+	err := ws.conn.WriteFrame(messageType, data)
+	ws.Unlock()
+	if err == nil {
+		wac.observer().FrameSent(messageType == websocket.BinaryMessage, len(data))
+	}
 
 	if err != nil {
 		if answerMessageTag != "" {
-			wac.listener.Lock()
-			delete(wac.listener.m, answerMessageTag)
-			wac.listener.Unlock()
+			wac.removeListener(answerMessageTag)
 		}
-		return nil, fmt.Errorf("error writing to websocket: %w", err)
+		wac.logger().Error("error writing frame", "tag", answerMessageTag, "err", err)
+		return nil, &ErrConnectionFailed{Err: fmt.Errorf("error writing to websocket: %w", err)}
 	}
 	return ch, nil
 }
@@ -156,12 +350,17 @@ func (wac *Conn) encryptBinaryMessage(node binary.Node) (data []byte, err error)
 		return nil, fmt.Errorf("binary node marshal failed: %w", err)
 	}
 
-	cipher, err := cbc.Encrypt(wac.session.EncKey, nil, b)
+	sess := wac.getSession()
+	if sess == nil {
+		return nil, ErrInvalidSession
+	}
+
+	cipher, err := cbc.Encrypt(sess.EncKey, nil, b)
 	if err != nil {
 		return nil, fmt.Errorf("encrypt failed: %w", err)
 	}
 
-	h := hmac.New(sha256.New, wac.session.MacKey)
+	h := hmac.New(sha256.New, sess.MacKey)
 	h.Write(cipher)
 	hash := h.Sum(nil)
 
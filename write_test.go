@@ -0,0 +1,42 @@
+package whatsapp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAwaitResponseRemovesListenerOnCancel(t *testing.T) {
+	wac := &Conn{
+		listener: &listenerWrapper{m: make(map[string]chan string)},
+	}
+	ch := make(chan string, 1)
+	wac.listener.m["tag"] = ch
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := wac.awaitResponse(ctx, "tag", ch); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, ok := wac.listener.m["tag"]; ok {
+		t.Error("listener was not removed")
+	}
+}
+
+func TestRequestContextAppliesTimeout(t *testing.T) {
+	wac := &Conn{msgTimeout: time.Minute}
+
+	ctx, cancel := wac.requestContext(context.Background())
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("expected the conn timeout to be applied")
+	}
+
+	parent, cancelParent := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelParent()
+	ctx, cancel = wac.requestContext(parent)
+	defer cancel()
+	if d, _ := ctx.Deadline(); time.Until(d) < 30*time.Minute {
+		t.Error("expected the caller's deadline to be kept")
+	}
+}